@@ -0,0 +1,64 @@
+// File: cmd/api/authors.go
+package main
+
+import (
+	"net/http"
+)
+
+// authorsMetadata is added to the authors list in cursor mode. NextCursor is
+// the after value to send for the next page, or "" when there are no more.
+type authorsMetadata struct {
+	NextCursor string `json:"next_cursor"`
+	PageSize   int    `json:"page_size"`
+}
+
+// listAuthorsHandler returns every author with a book in the catalog, each
+// once and in alphabetical order, as {"authors": [...]}. It's handy for
+// filling in a filter dropdown.
+//
+// Like the books list, it can be paged through with a cursor. Authors don't
+// have IDs, so the cursor is the last name on the previous page:
+//
+//	GET /authors?after=&page_size=10          (first page)
+//	GET /authors?after=Martin+Kleppmann&page_size=10
+func (app *App) listAuthorsHandler(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Cursor mode is opt-in, switched on by ?after
+	qs := r.URL.Query()
+	cursorMode := qs.Has("after")
+	after, pageSize := qs.Get("after"), 0
+	if cursorMode {
+		errs := make(map[string]string)
+		pageSize = app.readPageSize(qs, errs)
+		if len(errs) > 0 {
+			app.failedValidationResponse(w, r, errs)
+			return
+		}
+	}
+
+	// Step 2: Fetch the authors. As with books, we ask for one extra to find
+	// out whether there's another page.
+	limit := 0
+	if cursorMode {
+		limit = pageSize + 1
+	}
+	authors, err := app.Stores.Books.Authors(r.Context(), after, limit)
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	// Step 3: Send them, with the cursor for the next page
+	resp := envelope{"authors": authors}
+	if cursorMode {
+		metadata := authorsMetadata{PageSize: pageSize}
+		if len(authors) > pageSize {
+			authors = authors[:pageSize]
+			metadata.NextCursor = authors[pageSize-1]
+			resp["authors"] = authors
+		}
+		resp["metadata"] = metadata
+	}
+	if err := app.writeJSON(w, http.StatusOK, resp); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,80 @@
+// File: cmd/api/batch.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/garyclarke/first-go-app/internal/data"
+	"github.com/garyclarke/first-go-app/internal/request"
+)
+
+// maxBatchBooks caps how many books one batch request can create.
+const maxBatchBooks = 100
+
+// batchItemError says what's wrong with one element of a batch. Index is
+// the element's position in the request array, counting from 0.
+type batchItemError struct {
+	Index  int               `json:"index"`
+	Fields map[string]string `json:"fields"`
+}
+
+// createBooksBatchHandler creates several books from a JSON array in one go.
+//
+// Unlike POST /books/import, a batch is all or nothing: if any element is
+// invalid, nothing is created and we answer 422 with the errors for each
+// bad element, keyed by its index in the array:
+//
+//	{"errors": [{"index": 2, "fields": {"year": "year must be a positive integer"}}]}
+//
+// Books that already exist (same title and author) are skipped, so the
+// response lists only the books that were actually created.
+func (app *App) createBooksBatchHandler(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Decode the array of books
+	var brs []request.FullBookRequest
+	if err := app.readJSON(w, r, &brs); err != nil {
+		app.readJSONErrorResponse(w, r, err)
+		return
+	}
+
+	// Step 2: Check the size of the batch
+	if len(brs) == 0 || len(brs) > maxBatchBooks {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, fmt.Sprintf("a batch must contain between 1 and %d books", maxBatchBooks))
+		return
+	}
+
+	// Step 3: Validate every element, collecting the errors by index
+	batchErrors := []batchItemError{}
+	books := make([]*data.Book, len(brs))
+	for i := range brs {
+		if fields := request.ValidateFullBookRequest(&brs[i]); len(fields) > 0 {
+			batchErrors = append(batchErrors, batchItemError{Index: i, Fields: fields})
+			continue
+		}
+		book := brs[i].Book()
+		books[i] = &book
+	}
+	if len(batchErrors) > 0 {
+		if err := app.writeJSON(w, http.StatusUnprocessableEntity, envelope{"errors": batchErrors}); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Step 4: Insert them all in one transaction
+	if _, err := app.Stores.Books.InsertMany(r.Context(), books); err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	// Step 5: Return the books that were created (duplicates keep ID 0)
+	created := []data.Book{}
+	for _, b := range books {
+		if b.ID != 0 {
+			created = append(created, *b)
+		}
+	}
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"books": created}); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
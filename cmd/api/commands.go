@@ -0,0 +1,205 @@
+// File: cmd/api/commands.go
+package main
+
+import (
+	"database/sql"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/garyclarke/first-go-app/internal/data"
+)
+
+// commands holds what each of our subcommands does:
+//
+//	api [serve] [flags]   run the HTTP server (the default)
+//	api migrate [flags]   bring the database schema up to date, then exit
+//	api seed [flags]      add the demo books (or -seed-file's), then exit
+//	api version           print the version, then exit
+//
+// Keeping them as fields means main fills in the real work, while a test can
+// swap in stand-ins to check that run picks the right one.
+type commands struct {
+	serve   func() error
+	migrate func() error
+	seed    func() error
+	version func() error
+}
+
+// run runs the subcommand called name. An empty name means serve, so the
+// binary behaves as it did before it had subcommands.
+func (c commands) run(name string) error {
+	switch name {
+	case "", "serve":
+		return c.serve()
+	case "migrate":
+		return c.migrate()
+	case "seed":
+		return c.seed()
+	case "version":
+		return c.version()
+	default:
+		return fmt.Errorf("unknown command %q (want serve, migrate, seed or version)", name)
+	}
+}
+
+// splitCommand separates the subcommand, if there is one, from the flags.
+// It's the first argument, unless that's already a flag:
+//
+//	migrate -db-dsn=books.db  ->  "migrate", [-db-dsn=books.db]
+//	-addr=:4000               ->  "", [-addr=:4000]
+func splitCommand(args []string) (name string, rest []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", args
+	}
+	return args[0], args[1:]
+}
+
+// openDB opens the database described by the -db-* flags.
+func openDB(cfg config) (*sql.DB, error) {
+	// For SQLite, the journal mode and synchronous pragmas ride along in the DSN.
+	dsn := cfg.db.dsn
+	if cfg.db.driver == data.DriverSQLite {
+		var err error
+		dsn, err = data.SQLiteDSN(dsn, data.SQLitePragmas{WAL: cfg.db.wal, Synchronous: cfg.db.synchronous})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data.OpenDB(cfg.db.driver, dsn, data.PoolConfig{
+		MaxOpenConns: cfg.db.maxOpenConns,
+		MaxIdleConns: cfg.db.maxIdleConns,
+		MaxIdleTime:  cfg.db.maxIdleTime,
+	})
+}
+
+// migrateDB brings the schema up to date, or with -migrate-down undoes the
+// newest migration instead.
+func migrateDB(db *sql.DB, cfg config, logger *slog.Logger) error {
+	if !cfg.db.migrateDown {
+		return data.Migrate(db, cfg.db.driver)
+	}
+
+	current, err := data.SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		logger.Info("no migrations to roll back")
+		return nil
+	}
+	if err := data.MigrateDown(db, cfg.db.driver, current-1); err != nil {
+		return err
+	}
+	logger.Info("rolled back migration", "version", current)
+	return nil
+}
+
+// seedDB adds the demo books. With -seed-file they come from that file
+// instead of the built-in two.
+func seedDB(db *sql.DB, cfg config, logger *slog.Logger) error {
+	if cfg.seedFile == "" {
+		return data.SeedIfEmpty(db, cfg.db.driver)
+	}
+	books, err := loadSeedFile(cfg.seedFile, logger)
+	if err != nil {
+		return err
+	}
+	return data.SeedBooks(db, cfg.db.driver, books)
+}
+
+// seedOnStartup seeds the database as the server starts, if -seed is on,
+// and logs either way so it's clear whether demo books could have been added.
+// (The seed command always seeds: asking for it is the point.)
+func seedOnStartup(db *sql.DB, cfg config, logger *slog.Logger) error {
+	if !cfg.seed {
+		logger.Info("skipped seeding the database", "env", cfg.env)
+		return nil
+	}
+	if err := seedDB(db, cfg, logger); err != nil {
+		return err
+	}
+	logger.Info("seeded the database", "env", cfg.env, "seed_file", cfg.seedFile)
+	return nil
+}
+
+// runServer is the serve command: it opens, migrates and seeds the database,
+// then runs the HTTP server until we're told to stop.
+func runServer(cfg config, logger *slog.Logger) error {
+	// 1. Open a database connection.
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	// 2. Close it cleanly when the app shuts down.
+	defer db.Close()
+
+	// 3. Migrate and seed.
+	if err := migrateDB(db, cfg, logger); err != nil {
+		return err
+	}
+	// With -migrate-down we stop after undoing the newest migration, rather
+	// than starting a server on a schema it doesn't expect.
+	if cfg.db.migrateDown {
+		return nil
+	}
+	if err := seedOnStartup(db, cfg, logger); err != nil {
+		return err
+	}
+
+	// Publish the connection pool statistics (open connections, in use, idle,
+	// wait count, ...) alongside our other metrics at GET /debug/vars.
+	// expvar.Func calls db.Stats() each time the metrics are read.
+	expvar.Publish("database", expvar.Func(func() any {
+		return db.Stats()
+	}))
+
+	// With -debug-sql, the stores log their SQL through the same logger.
+	var sqlLogger *slog.Logger
+	if cfg.db.debugSQL {
+		sqlLogger = logger
+	}
+
+	// With -cache-enabled, book lists are cached for -cache-ttl.
+	var cacheTTL time.Duration
+	if cfg.cache.enabled {
+		cacheTTL = cfg.cache.ttl
+	}
+
+	// Build our App with all its dependencies: the config, the logger and
+	// the data stores, created from the DB connection.
+	app := &App{
+		Config: cfg,
+		Logger: logger,
+		Stores: data.NewStores(db, data.Options{
+			Driver:     cfg.db.driver,
+			MaxRetries: cfg.db.maxRetries,
+			RetryDelay: cfg.db.retryDelay,
+			SQLLogger:  sqlLogger,
+
+			SlowQueryThreshold: cfg.db.slowQueryThreshold,
+			Logger:             logger,
+			CacheTTL:           cacheTTL,
+			IdempotencyTTL:     cfg.idempotency.ttl,
+		}),
+		quit: make(chan struct{}),
+	}
+
+	// Build an explicit http.Server rather than using http.ListenAndServe.
+	// The server http.ListenAndServe creates for us has no timeouts at all, so a
+	// client that sends its request very slowly (a "slowloris" attack) could
+	// hold a connection open forever. Setting the timeouts closes that door.
+	srv := &http.Server{
+		Addr:         cfg.addr,
+		Handler:      app.routes(),
+		ReadTimeout:  cfg.server.readTimeout,
+		WriteTimeout: cfg.server.writeTimeout,
+		IdleTimeout:  cfg.server.idleTimeout,
+	}
+
+	// Run the server until we're told to stop, then shut down gracefully.
+	return app.serve(srv)
+}
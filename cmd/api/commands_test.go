@@ -0,0 +1,73 @@
+// File: cmd/api/commands_test.go
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCommandsRun(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"", "serve"},
+		{"serve", "serve"},
+		{"migrate", "migrate"},
+		{"seed", "seed"},
+		{"version", "version"},
+	}
+
+	for _, tt := range tests {
+		t.Run("command "+tt.name, func(t *testing.T) {
+			// Each stand-in records that it ran, so we can see which one was picked.
+			var ran []string
+			record := func(name string) func() error {
+				return func() error {
+					ran = append(ran, name)
+					return nil
+				}
+			}
+			cmds := commands{
+				serve:   record("serve"),
+				migrate: record("migrate"),
+				seed:    record("seed"),
+				version: record("version"),
+			}
+
+			if err := cmds.run(tt.name); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(ran, []string{tt.want}) {
+				t.Errorf("want only %s to run; got %v", tt.want, ran)
+			}
+		})
+	}
+}
+
+func TestCommandsRun_Unknown(t *testing.T) {
+	cmds := commands{}
+	if err := cmds.run("deploy"); err == nil {
+		t.Error("want an error for an unknown command")
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantName string
+		wantRest []string
+	}{
+		{nil, "", nil},
+		{[]string{"-addr=:4000"}, "", []string{"-addr=:4000"}},
+		{[]string{"migrate"}, "migrate", []string{}},
+		{[]string{"seed", "-seed-file=books.json"}, "seed", []string{"-seed-file=books.json"}},
+	}
+
+	for _, tt := range tests {
+		name, rest := splitCommand(tt.args)
+		if name != tt.wantName || !slices.Equal(rest, tt.wantRest) {
+			t.Errorf("splitCommand(%q): want %q, %q; got %q, %q", tt.args, tt.wantName, tt.wantRest, name, rest)
+		}
+	}
+}
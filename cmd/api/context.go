@@ -0,0 +1,28 @@
+// File: cmd/api/context.go
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// contextKey is our own type for keys stored in a request's context.
+//
+// Using a custom type (instead of a plain string) means our keys can never
+// clash with keys set by other packages, even if they use the same name.
+type contextKey string
+
+const requestIDContextKey = contextKey("requestID")
+
+// contextSetRequestID returns a copy of r with the request ID stored in its context.
+func contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// requestIDFromContext pulls the request ID back out of a context.
+// It returns "" if no ID has been set (e.g. outside the requestID middleware).
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
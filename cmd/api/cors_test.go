@@ -0,0 +1,174 @@
+// File: cmd/api/cors_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnableCORS(t *testing.T) {
+	tests := []struct {
+		name        string
+		origins     []string
+		credentials bool
+		maxAge      time.Duration
+		origin      string
+		preflight   bool
+		wantCode    int
+		wantOrigin  string
+		wantCreds   string
+		wantMaxAge  string
+	}{
+		{name: "no origins configured", origin: "https://example.com", wantCode: http.StatusOK},
+		{name: "untrusted origin", origins: []string{"https://example.com"}, origin: "https://evil.example", wantCode: http.StatusOK},
+		{name: "trusted origin", origins: []string{"https://example.com"}, origin: "https://example.com", wantCode: http.StatusOK, wantOrigin: "https://example.com"},
+		{name: "any origin", origins: []string{"*"}, origin: "https://example.com", wantCode: http.StatusOK, wantOrigin: "*"},
+		{
+			name: "credentials echo the origin", origins: []string{"https://example.com"}, credentials: true,
+			origin: "https://example.com", wantCode: http.StatusOK, wantOrigin: "https://example.com", wantCreds: "true",
+		},
+		{
+			name: "preflight with max-age", origins: []string{"https://example.com"}, maxAge: 10 * time.Minute,
+			origin: "https://example.com", preflight: true, wantCode: http.StatusOK, wantOrigin: "https://example.com", wantMaxAge: "600",
+		},
+		{
+			name: "preflight without max-age", origins: []string{"https://example.com"},
+			origin: "https://example.com", preflight: true, wantCode: http.StatusOK, wantOrigin: "https://example.com",
+		},
+		{
+			// Without a trusted origin the preflight falls through to the mux,
+			// which has no OPTIONS routes
+			name: "untrusted preflight", origins: []string{"https://example.com"}, maxAge: 10 * time.Minute,
+			origin: "https://evil.example", preflight: true, wantCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := setupTestApp(t)
+			app.Config.cors.trustedOrigins = tc.origins
+			app.Config.cors.allowCredentials = tc.credentials
+			app.Config.cors.maxAge = tc.maxAge
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/books", http.NoBody)
+			if tc.preflight {
+				r = httptest.NewRequest(http.MethodOptions, "/v1/books", http.NoBody)
+				r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			r.Header.Set("Origin", tc.origin)
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, r)
+
+			if rr.Code != tc.wantCode {
+				t.Errorf("want status code %d; got %d", tc.wantCode, rr.Code)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tc.wantOrigin {
+				t.Errorf("want Access-Control-Allow-Origin %q; got %q", tc.wantOrigin, got)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Credentials"); got != tc.wantCreds {
+				t.Errorf("want Access-Control-Allow-Credentials %q; got %q", tc.wantCreds, got)
+			}
+			if got := rr.Header().Get("Access-Control-Max-Age"); got != tc.wantMaxAge {
+				t.Errorf("want Access-Control-Max-Age %q; got %q", tc.wantMaxAge, got)
+			}
+			if got := rr.Header().Values("Vary"); len(tc.origins) > 0 && !containsValue(got, "Origin") {
+				t.Errorf("want Vary: Origin; got %q", got)
+			}
+		})
+	}
+}
+
+func TestEnableCORS_ExposedHeaders(t *testing.T) {
+	app := setupTestApp(t)
+	app.Config.cors.trustedOrigins = []string{"https://example.com"}
+
+	// A page on a trusted origin can read the headers it needs, like the
+	// ETag to send back in If-Match
+	r := httptest.NewRequest(http.MethodGet, "/v1/books/1", http.NoBody)
+	r.Header.Set("Origin", "https://example.com")
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, r)
+
+	exposed := rr.Header().Get("Access-Control-Expose-Headers")
+	for _, name := range []string{"ETag", "Location", "X-Request-ID"} {
+		if !strings.Contains(exposed, name) {
+			t.Errorf("want %s in Access-Control-Expose-Headers; got %q", name, exposed)
+		}
+	}
+	if rr.Header().Get("ETag") == "" {
+		t.Error("want an ETag to expose")
+	}
+
+	// The browser only looks for them on the real response, not the preflight
+	r = httptest.NewRequest(http.MethodOptions, "/v1/books/1", http.NoBody)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPut)
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, r)
+	if got := rr.Header().Get("Access-Control-Expose-Headers"); got != "" {
+		t.Errorf("want no Access-Control-Expose-Headers on a preflight; got %q", got)
+	}
+}
+
+func TestEnableCORS_PreferReturnMinimal(t *testing.T) {
+	app := setupTestApp(t)
+	app.Config.cors.trustedOrigins = []string{"https://example.com"}
+
+	// Step 1: The preflight lets the page send Prefer
+	r := httptest.NewRequest(http.MethodOptions, "/v1/books", http.NoBody)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	r.Header.Set("Access-Control-Request-Headers", "content-type, prefer")
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, r)
+	if allowed := rr.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(allowed, "Prefer") {
+		t.Errorf("want Prefer in Access-Control-Allow-Headers; got %q", allowed)
+	}
+
+	// Step 2: The minimal create's Location is readable
+	r = newJSONRequest(http.MethodPost, "/v1/books", strings.NewReader(`{"title": "Learning Go", "author": "Jon Bodner"}`))
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Prefer", "return=minimal")
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, r)
+	if rr.Code != http.StatusCreated || rr.Header().Get("Location") == "" {
+		t.Fatalf("want a 201 with a Location; got %d (Location %q)", rr.Code, rr.Header().Get("Location"))
+	}
+	if exposed := rr.Header().Get("Access-Control-Expose-Headers"); !strings.Contains(exposed, "Location") {
+		t.Errorf("want Location in Access-Control-Expose-Headers; got %q", exposed)
+	}
+}
+
+func TestValidateCORS(t *testing.T) {
+	var cfg config
+	cfg.cors.trustedOrigins = []string{"*"}
+	if err := validateCORS(cfg); err != nil {
+		t.Errorf("want any origin without credentials to be fine; got %v", err)
+	}
+
+	cfg.cors.allowCredentials = true
+	if err := validateCORS(cfg); err == nil {
+		t.Error("want an error for credentials with any origin")
+	}
+
+	cfg.cors.trustedOrigins = []string{"https://example.com"}
+	if err := validateCORS(cfg); err != nil {
+		t.Errorf("want credentials with a listed origin to be fine; got %v", err)
+	}
+}
+
+// containsValue reports whether want is one of the comma-separated values
+// in a header that may be set more than once, like Vary.
+func containsValue(values []string, want string) bool {
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if strings.TrimSpace(part) == want {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+// File: cmd/api/covers.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// maxCoverBytes is the largest cover image we accept (2MB).
+const maxCoverBytes = 2 << 20
+
+// coverExtensions maps the image types we accept to the file extension we
+// save them with. http.ServeFile later uses the extension to set the right
+// Content-Type when the cover is downloaded.
+var coverExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+}
+
+// uploadCoverHandler saves a cover image for a book. The image is sent as a
+// multipart form upload in a field called "cover", e.g.
+//
+//	curl -F cover=@cover.png http://localhost:8080/books/1/cover
+//
+// Uploading a new cover replaces the old one.
+func (app *App) uploadCoverHandler(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Make sure the book exists
+	bookID, ok := app.bookIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	// Step 2: Limit the upload size. The extra 1KB leaves room for the
+	// multipart boundaries and headers around the file itself.
+	r.Body = http.MaxBytesReader(w, r.Body, maxCoverBytes+1024)
+
+	file, _, err := r.FormFile("cover")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("cover must not be larger than %d bytes", maxCoverBytes))
+			return
+		}
+		app.errorResponse(w, r, http.StatusBadRequest, "upload the image in a form field called \"cover\"")
+		return
+	}
+	defer file.Close()
+
+	// Step 3: Check it really is a PNG or JPEG. We don't trust the filename or
+	// the Content-Type the client sent; DetectContentType looks at the first
+	// 512 bytes of the file itself.
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		app.errorResponse(w, r, http.StatusBadRequest, "could not read the uploaded cover")
+		return
+	}
+	ext, ok := coverExtensions[http.DetectContentType(head[:n])]
+	if !ok {
+		app.errorResponse(w, r, http.StatusUnsupportedMediaType, "cover must be a PNG or JPEG image")
+		return
+	}
+
+	// Step 4: Save it to disk, named after the book (e.g. "42.png").
+	// We write to a temporary file first and then rename it, so a
+	// half-written upload never replaces a good cover.
+	coverPath := fmt.Sprintf("%d%s", bookID, ext)
+	if err := app.saveCover(coverPath, io.MultiReader(bytes.NewReader(head[:n]), file)); err != nil {
+		app.requestLogger(r).Error("failed to save cover", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// Step 5: If the old cover had a different extension (PNG replaced by a
+	// JPEG, say), it's now an orphan, so remove it.
+	oldPath, err := app.Stores.Books.GetCoverPath(r.Context(), bookID)
+	if err == nil && oldPath != coverPath {
+		os.Remove(filepath.Join(app.Config.covers.dir, oldPath))
+	}
+
+	// Step 6: Record where the cover is
+	if err := app.Stores.Books.SetCoverPath(r.Context(), bookID, coverPath); err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	// Step 7: Confirm with a 201 Created, pointing at where to fetch it
+	w.Header().Set("Location", fmt.Sprintf("%s/books/%d/cover", apiPrefix, bookID))
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"message": "cover uploaded"}); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// showCoverHandler sends a book's cover image, or a 404 if it doesn't have one.
+func (app *App) showCoverHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	coverPath, err := app.Stores.Books.GetCoverPath(r.Context(), id)
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	// ServeFile sets Content-Type from the extension, handles Range and
+	// If-Modified-Since requests, and responds 404 if the file has gone missing.
+	http.ServeFile(w, r, filepath.Join(app.Config.covers.dir, coverPath))
+}
+
+// saveCover writes src to coverPath inside the covers directory, via a
+// temporary file that's renamed into place once it's complete.
+func (app *App) saveCover(coverPath string, src io.Reader) error {
+	if err := os.MkdirAll(app.Config.covers.dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(app.Config.covers.dir, "upload-*")
+	if err != nil {
+		return err
+	}
+	// Removing the temp file fails harmlessly once it's been renamed.
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(app.Config.covers.dir, coverPath))
+}
+
+// removeCover deletes a book's cover image from disk and forgets its path.
+// It's used when a book is deleted; failures are logged, not returned.
+func (app *App) removeCover(r *http.Request, bookID int64, coverPath string) {
+	err := os.Remove(filepath.Join(app.Config.covers.dir, coverPath))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		app.requestLogger(r).Error("failed to remove cover", "error", err)
+		return
+	}
+
+	if err := app.Stores.Books.SetCoverPath(r.Context(), bookID, ""); err != nil {
+		app.requestLogger(r).Error("failed to clear cover path", "error", err)
+	}
+}
@@ -0,0 +1,186 @@
+// File: cmd/api/errors.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/garyclarke/first-go-app/internal/data"
+)
+
+// errorResponse sends a JSON error to the client in a consistent shape:
+//
+//	{"error": "some message"}
+//
+// message is `any` so we can send either a simple string or something richer
+// (like a map of validation errors) when we need to.
+func (app *App) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
+	if err := app.writeJSON(w, status, envelope{"error": message}); err != nil {
+		// If we can't even send the JSON error, log it and fall back to an empty 500.
+		app.requestLogger(r).Error("failed to write error response", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// rateLimitExceededResponse sends a 429 Too Many Requests error.
+func (app *App) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusTooManyRequests, "rate limit exceeded")
+}
+
+// invalidAuthenticationTokenResponse sends a 401 Unauthorized error.
+// The WWW-Authenticate header tells the client how it's expected to
+// authenticate — here, with a bearer token.
+func (app *App) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	app.errorResponse(w, r, http.StatusUnauthorized, "invalid or missing authentication token")
+}
+
+// notFoundResponse sends a 404 Not Found error. It's used both for paths we
+// have no route for and for records that don't exist (or IDs that can't be
+// one), so a client can't tell those cases apart, and gets JSON either way.
+func (app *App) notFoundResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusNotFound, "the requested resource could not be found")
+}
+
+// notPermittedResponse sends a 403 Forbidden error.
+func (app *App) notPermittedResponse(w http.ResponseWriter, r *http.Request, message string) {
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// editConflictResponse sends a 409 Conflict error, for requests that clash
+// with data we already have.
+func (app *App) editConflictResponse(w http.ResponseWriter, r *http.Request, message string) {
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// idempotencyKeyReusedResponse sends a 422 Unprocessable Entity error when
+// an Idempotency-Key comes back with a different request from the first time.
+func (app *App) idempotencyKeyReusedResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, "this Idempotency-Key was already used for a different request")
+}
+
+// handleStoreError responds to an error from one of our data stores.
+//
+// The stores report the problems a client can do something about with the
+// sentinel errors in internal/data, and we map each one to its status code
+// here, so every handler answers them the same way:
+//
+//	data.ErrRecordNotFound  404 Not Found
+//	data.ErrDuplicateBook   409 Conflict
+//	data.ErrEditConflict    409 Conflict
+//
+// The stores run their queries with the request's context, so a query can
+// also be cut short:
+//
+//	context.Canceled          the client hung up; nobody's left to answer
+//	context.DeadlineExceeded  503 Service Unavailable (the query, or the
+//	                          whole request, ran out of time)
+//
+// A write to a read-only database (data.ErrReadOnly) isn't something the
+// client can fix, or that will go away on its own: someone has to give the
+// server write access again. We log it as an error, so it gets noticed, and
+// send a 503 that says what's wrong without the driver's details.
+//
+// For anything else we check whether the database is still reachable. If it
+// isn't (the connection dropped, or the SQLite file is briefly missing) we
+// send a 503, which tells the client the request is worth retrying shortly.
+// Otherwise the error is our fault rather than the client's: we log it and
+// send a 500 without the details.
+func (app *App) handleStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, data.ErrDuplicateBook):
+		app.editConflictResponse(w, r, "a book with this title and author already exists")
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r, "the record was changed by someone else, please fetch it and try again")
+	case errors.Is(err, data.ErrReadOnly):
+		app.requestLogger(r).Error("database is read-only", "error", err)
+		app.readOnlyResponse(w, r)
+	case errors.Is(err, context.Canceled):
+		// Clients giving up is normal, not a fault of ours, so it's only worth
+		// a debug line. There's no point writing a response nobody will read.
+		app.requestLogger(r).Debug("query cancelled, client went away", "error", err)
+	case errors.Is(err, context.DeadlineExceeded):
+		app.requestLogger(r).Warn("query timed out", "error", err)
+		app.timeoutResponse(w, r)
+	case !app.Stores.Books.Healthy():
+		app.requestLogger(r).Warn("database unavailable", "error", err)
+		app.serviceUnavailableResponse(w, r)
+	default:
+		app.requestLogger(r).Error("store error", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// preconditionRequiredResponse sends a 428 Precondition Required error, for
+// a write that has to say which version it's changing (with If-Match) but didn't.
+func (app *App) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusPreconditionRequired, "an If-Match header with the record's ETag is required")
+}
+
+// preconditionFailedResponse sends a 412 Precondition Failed error: the
+// client's If-Match didn't match, because the record has changed since it
+// was fetched.
+func (app *App) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusPreconditionFailed, "the record has changed since you fetched it, please fetch it and try again")
+}
+
+// timeoutResponse sends a 503 Service Unavailable error for a request that
+// ran out of time. Trying again later may well work, hence Retry-After.
+func (app *App) timeoutResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "5")
+	app.errorResponse(w, r, http.StatusServiceUnavailable, "the request took too long to process, please try again")
+}
+
+// serviceUnavailableResponse sends a 503 Service Unavailable error.
+// Retry-After suggests how many seconds the client should wait before trying again.
+func (app *App) serviceUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "5")
+	app.errorResponse(w, r, http.StatusServiceUnavailable, "the database is temporarily unavailable, please try again")
+}
+
+// readOnlyResponse sends a 503 Service Unavailable error for a write the
+// database couldn't accept because it's read-only. There's no Retry-After:
+// it won't be fixed in a few seconds.
+func (app *App) readOnlyResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusServiceUnavailable, "the database is read-only, so changes can't be saved right now")
+}
+
+// readJSONErrorResponse responds to an error from readJSON: a 415 if the
+// body wasn't sent as JSON, a 413 if it was over the size limit, or a plain
+// 400 Bad Request for anything else (such as badly formed JSON).
+func (app *App) readJSONErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errNotJSON) {
+		app.errorResponse(w, r, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		message := fmt.Sprintf("request body must not be larger than %d bytes", maxBytesErr.Limit)
+		app.errorResponse(w, r, http.StatusRequestEntityTooLarge, message)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+}
+
+// failedValidationResponse sends a 422 Unprocessable Entity error listing
+// what's wrong with each field, e.g.
+//
+//	{"error": "validation failed", "fields": {"title": "title is required"}}
+//
+// Every validation failure uses this shape, so clients can show the message
+// next to the right input without special-casing each endpoint.
+func (app *App) failedValidationResponse(w http.ResponseWriter, r *http.Request, fields map[string]string) {
+	err := app.writeJSON(w, http.StatusUnprocessableEntity, envelope{
+		"error":  "validation failed",
+		"fields": fields,
+	})
+	if err != nil {
+		app.requestLogger(r).Error("failed to write error response", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
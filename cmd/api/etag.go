@@ -0,0 +1,100 @@
+// File: cmd/api/etag.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/garyclarke/first-go-app/internal/data"
+)
+
+// bookETag builds a weak ETag for a book by hashing its JSON form.
+//
+// An ETag is a short fingerprint of a response. If the value changes, the
+// fingerprint changes too. Clients can send it back in an If-None-Match
+// header to ask "has this changed since I last saw it?", or in an If-Match
+// header on PUT to say "only if it hasn't changed" (see putBookHandler).
+//
+// The fingerprint is the first 16 bytes of the SHA-256 of the book encoded
+// with json.Marshal — always the compact JSON, whatever -pretty-json or the
+// Accept header says — written as 32 hex characters. Book.MarshalJSON writes
+// the keys in a fixed order, so the same book always gives the same ETag,
+// across requests and restarts. Anything in the JSON counts: editing the
+// book, or a new review changing its rating, gives a new ETag. (Adding a
+// field to data.Book changes every ETag, which only costs clients one
+// refetch.)
+//
+// The one exception is the view count. Every GET adds to it, so if it
+// counted, a client could never get a 304, and a GET followed by a PUT with
+// If-Match would fail with a 412 because of its own GET.
+//
+// We mark it as weak (the W/ prefix) because it describes the data, not the
+// exact bytes on the wire — the same book sent compressed or uncompressed
+// has the same ETag.
+func bookETag(book *data.Book) (string, error) {
+	unviewed := *book
+	unviewed.Views = 0
+	b, err := json.Marshal(unviewed)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	// 16 bytes (32 hex characters) of the hash is plenty to tell versions apart.
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// etagMatches reports whether etag appears in an If-None-Match or If-Match
+// header value.
+//
+// The header can hold a single ETag, a comma-separated list of them, or "*"
+// (meaning "any version"). ETags are compared weakly, so W/"abc" matches "abc".
+// HTTP strictly wants If-Match compared strongly, but our ETags describe the
+// book's data, which is exactly what a client needs to know hasn't changed.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLastModified sets the Last-Modified header on a list response and
+// reports whether the client's copy is still fresh.
+//
+// Last-Modified works like an ETag, but with a time instead of a
+// fingerprint: the client sends it back in If-Modified-Since, and if the
+// catalog hasn't changed since then we can answer 304 with no body. It's
+// the time of the newest change anywhere in the catalog, so any filter or
+// page of the list is covered by it. HTTP dates only go down to the second,
+// which happens to match what the database stores.
+func (app *App) checkLastModified(w http.ResponseWriter, r *http.Request) (bool, error) {
+	lastModified, err := app.Stores.Books.LastModified(r.Context())
+	if err != nil {
+		return false, err
+	}
+	// An empty catalog has no last modified time to offer
+	if lastModified.IsZero() {
+		return false, nil
+	}
+
+	w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		// Missing or unparseable: treat it as "send me everything"
+		return false, nil
+	}
+	return !lastModified.Truncate(time.Second).After(since), nil
+}
@@ -0,0 +1,138 @@
+// File: cmd/api/events.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/garyclarke/first-go-app/internal/data"
+)
+
+// bookBroker passes newly created books on to everyone listening at
+// GET /books/events.
+//
+// Each listener subscribes and gets its own channel. publish sends the book
+// down every channel. The channels are buffered, and a listener that has
+// fallen so far behind that its buffer is full misses the event rather than
+// holding up the request that created the book.
+//
+// The zero value is ready to use.
+type bookBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan data.Book]struct{}
+	closed      bool
+}
+
+// subscribe registers a new listener. The channel is closed when the broker
+// shuts down; call unsubscribe when the listener goes away.
+func (b *bookBroker) subscribe() chan data.Book {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan data.Book, 16)
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan data.Book]struct{})
+	}
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe removes a listener added with subscribe.
+func (b *bookBroker) unsubscribe(ch chan data.Book) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscribers, ch)
+}
+
+// publish sends book to every listener.
+func (b *bookBroker) publish(book data.Book) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- book:
+		default: // this listener's buffer is full: skip it
+		}
+	}
+}
+
+// close ends every subscription, so their event streams finish. It's
+// registered with srv.RegisterOnShutdown: otherwise graceful shutdown would
+// wait for the streams, which never end by themselves.
+func (b *bookBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		close(ch)
+		delete(b.subscribers, ch)
+	}
+}
+
+// bookEventsHandler streams newly created books as server-sent events.
+//
+// Server-sent events are a simple way to push updates to a browser over an
+// ordinary HTTP response that never ends. Each event is a "data:" line
+// followed by a blank line, and the browser's EventSource API hands each one
+// to a callback:
+//
+//	data: {"id":3,"title":"Learning Go",...}
+//
+// The stream stays open until the client disconnects (its request context
+// is cancelled) or the server shuts down. -request-timeout doesn't apply
+// here: the route is registered without it (see routes), since its
+// ResponseWriter can't be flushed and the stream would end straight away.
+func (app *App) bookEventsHandler(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Subscribe, and make sure we unsubscribe however we leave
+	events := app.events.subscribe()
+	defer app.events.unsubscribe(events)
+
+	// Step 2: The server's write timeout would cut the stream off, so lift
+	// it for this response. Not every ResponseWriter supports that, which is
+	// fine: the stream just ends sooner.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	// Step 3: Send the headers straight away, so the client knows it's
+	// connected before the first event arrives
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		app.requestLogger(r).Error("event stream can't be flushed", "error", err)
+		return
+	}
+
+	// Step 4: Pass on each book until the client or the server goes away
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case book, ok := <-events:
+			if !ok {
+				return
+			}
+			js, err := json.Marshal(book)
+			if err != nil {
+				app.requestLogger(r).Error("failed to encode book event", "error", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", js); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// File: cmd/api/export.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/garyclarke/first-go-app/internal/data"
+)
+
+// exportBooksHandler downloads the whole catalog as a CSV file.
+//
+// Rather than loading every book and then building the file, we write each
+// row to the client as soon as it's read from the database. That keeps memory
+// use flat no matter how big the catalog gets.
+//
+// The exception is a Range request (e.g. "Range: bytes=1000-"), which a
+// client sends to resume a download that broke off part way. To answer it we
+// need the whole file to cut the requested bytes from, so we build it in
+// memory first (see serveBooksCSVRange).
+func (app *App) exportBooksHandler(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Tell the client this is a CSV file to be saved, not displayed,
+	// and that it may ask for just part of it.
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="books.csv"`)
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	// Step 2: Resuming a download needs the Range treatment.
+	if r.Header.Get("Range") != "" {
+		app.serveBooksCSVRange(w, r)
+		return
+	}
+
+	// Step 3: Otherwise stream the whole file, row by row.
+	// The 200 status and some rows may already have been sent if this fails,
+	// so we can't switch to an error response now. The best we can do is log it.
+	if err := app.writeBooksCSV(r.Context(), w); err != nil {
+		app.requestLogger(r).Error("failed to export books", "error", err)
+	}
+}
+
+// serveBooksCSVRange answers a Range request for the CSV export.
+//
+// http.ServeContent does the hard part: it parses the Range header, replies
+// 206 Partial Content with a Content-Range header saying which bytes these
+// are (or 416 if the range is past the end of the file), and handles
+// If-Range. We only have to give it the file and when it last changed.
+//
+// That time matters. A client resuming a download sends back the
+// Last-Modified of its first attempt in If-Range; if a book has changed
+// since then, the bytes it's missing no longer line up with the ones it
+// has, so ServeContent sends the whole new file instead.
+func (app *App) serveBooksCSVRange(w http.ResponseWriter, r *http.Request) {
+	lastModified, err := app.Stores.Books.LastModified(r.Context())
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := app.writeBooksCSV(r.Context(), &buf); err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	http.ServeContent(w, r, "books.csv", lastModified, bytes.NewReader(buf.Bytes()))
+}
+
+// writeBooksCSV writes every (undeleted) book to w as CSV, starting with a
+// header row.
+//
+// csv.Writer buffers its output, so we Flush after every row to pass it
+// on to w straight away; when w is the response, that sends it to the client.
+func (app *App) writeBooksCSV(ctx context.Context, w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"id", "title", "author", "year"}); err != nil {
+		return err
+	}
+
+	err := app.Stores.Books.StreamAll(ctx, data.BookFilters{}, func(b *data.Book) error {
+		// An unknown year is left as an empty cell.
+		year := ""
+		if b.Year != nil {
+			year = strconv.Itoa(*b.Year)
+		}
+		record := []string{
+			strconv.FormatInt(b.ID, 10),
+			b.Title,
+			b.Author,
+			year,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+
+	// Make sure anything still buffered is written.
+	cw.Flush()
+	if err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// wantsNDJSON reports whether the client asked for the book list as NDJSON
+// (?format=ndjson), which streamBooksNDJSON sends.
+func wantsNDJSON(r *http.Request) bool {
+	return readString(r.URL.Query(), "format", "json") == "ndjson"
+}
+
+// streamBooksNDJSON writes books as newline-delimited JSON (NDJSON): one
+// complete JSON object per line, rather than one big array.
+//
+// Like the CSV export, each book is written and flushed as soon as it's read,
+// so the whole catalog is never held in memory and the client can start
+// processing lines before the response has finished.
+func (app *App) streamBooksNDJSON(w http.ResponseWriter, r *http.Request, filters data.BookFilters, fields []string) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	// http.ResponseController finds the Flush method even through our
+	// middleware's wrapped ResponseWriters (via their Unwrap methods).
+	rc := http.NewResponseController(w)
+
+	// json.Encoder ends every value it writes with a newline, which is
+	// exactly the NDJSON format.
+	enc := json.NewEncoder(w)
+
+	err := app.Stores.Books.StreamAll(r.Context(), filters, func(b *data.Book) error {
+		var v any = b
+		if fields != nil {
+			v = selectFields(b, fields)
+		}
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		// Flushing isn't supported by every ResponseWriter (e.g. in some
+		// tests); the rows still arrive, just not line by line.
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		return nil
+	})
+
+	// As with the CSV export, the 200 status has already gone, so just log it.
+	if err != nil {
+		app.requestLogger(r).Error("failed to stream books", "error", err)
+	}
+}
@@ -0,0 +1,74 @@
+// File: cmd/api/fallback.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// allowMethods are the methods we check for when building an Allow header.
+// HEAD comes for free with every GET route (see routes.go).
+var allowMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// fallback wraps the muxes so requests they have no route for get a JSON
+// error, like the rest of the API, instead of net/http's plain text: a 404
+// for an unknown path, or a 405 for a known path with the wrong method.
+//
+// mux.Handler tells us which pattern (if any) a request matches without
+// running it. The muxes are tried in order, and the first with a match
+// serves the request. (There's more than one only because of a route the
+// main mux can't hold; see routes.) When nothing matches, we ask the same
+// question again with each of the other methods: if some of them would
+// match, the path is known and only the method is wrong, so we answer 405
+// Method Not Allowed and list the methods that would have worked in the
+// Allow header.
+func (app *App) fallback(muxes ...*http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, mux := range muxes {
+			if _, pattern := mux.Handler(r); pattern != "" {
+				mux.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if allowed := allowedMethods(muxes, r); len(allowed) > 0 {
+			app.methodNotAllowedResponse(w, r, allowed)
+			return
+		}
+
+		app.notFoundResponse(w, r)
+	})
+}
+
+// allowedMethods lists the methods any of the muxes has a route for at r's path.
+func allowedMethods(muxes []*http.ServeMux, r *http.Request) []string {
+	var allowed []string
+	for _, method := range allowMethods {
+		// A shallow copy is enough: we only change the method
+		probe := *r
+		probe.Method = method
+		for _, mux := range muxes {
+			if _, pattern := mux.Handler(&probe); pattern != "" {
+				allowed = append(allowed, method)
+				break
+			}
+		}
+	}
+	return allowed
+}
+
+// methodNotAllowedResponse sends a 405 Method Not Allowed error. The Allow
+// header is required with a 405, so clients can see what they should use.
+func (app *App) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request, allowed []string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	message := fmt.Sprintf("the %s method is not allowed for this resource", r.Method)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+}
@@ -0,0 +1,90 @@
+// File: cmd/api/filters.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/garyclarke/first-go-app/internal/data"
+)
+
+// parseBookFilters reads the list filters from the query string:
+//
+//	?include_deleted=true           also list soft-deleted books
+//	?year_from=2000&year_to=2010    only books published in that range
+//	?sort=views                     most viewed first (the default is sort=id)
+//
+// Either end of the year range can be left off. Any problems are returned
+// as validation errors, keyed by parameter name.
+func parseBookFilters(r *http.Request) (data.BookFilters, map[string]string) {
+	qs := r.URL.Query()
+	errs := make(map[string]string)
+
+	var filters data.BookFilters
+
+	// Soft-deleted books are hidden unless an admin asks for them
+	filters.IncludeDeleted, _ = strconv.ParseBool(qs.Get("include_deleted"))
+
+	filters.YearFrom = readYear(qs, "year_from", errs)
+	filters.YearTo = readYear(qs, "year_to", errs)
+	if filters.YearFrom > 0 && filters.YearTo > 0 && filters.YearFrom > filters.YearTo {
+		errs["year_from"] = "year_from must not be after year_to"
+	}
+
+	switch qs.Get("sort") {
+	case "", "id":
+	case "views":
+		filters.SortByViews = true
+	default:
+		errs["sort"] = `sort must be "id" or "views"`
+	}
+
+	if len(errs) > 0 {
+		return data.BookFilters{}, errs
+	}
+	return filters, nil
+}
+
+// readYear parses an optional year parameter. An empty value gives 0 ("no
+// limit"); anything that isn't a positive integer adds an error to errs.
+func readYear(qs url.Values, key string, errs map[string]string) int {
+	year := readInt(qs, key, 0, errs)
+	if year < 1 && qs.Get(key) != "" {
+		errs[key] = key + " must be a positive integer"
+		return 0
+	}
+	return year
+}
+
+// maxBookIDs caps how many books can be fetched at once with ?ids=.
+const maxBookIDs = 100
+
+// parseBookIDs reads the comma-separated ?ids= parameter, e.g.
+//
+//	GET /books?ids=1,2,3
+//
+// It returns nil when the parameter is missing. Every ID must be a positive
+// integer, and there can be at most maxBookIDs of them.
+func parseBookIDs(r *http.Request) ([]int64, map[string]string) {
+	raw := readCSV(r.URL.Query(), "ids", nil)
+	if raw == nil {
+		return nil, nil
+	}
+
+	if len(raw) > maxBookIDs {
+		return nil, map[string]string{"ids": fmt.Sprintf("ids must not contain more than %d IDs", maxBookIDs)}
+	}
+
+	ids := make([]int64, len(raw))
+	for i, s := range raw {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id < 1 {
+			return nil, map[string]string{"ids": fmt.Sprintf("%q is not a valid book ID", s)}
+		}
+		ids[i] = id
+	}
+
+	return ids, nil
+}
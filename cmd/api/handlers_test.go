@@ -2,13 +2,32 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"database/sql"
+	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/garyclarke/first-go-app/internal/data"
+	"github.com/google/uuid"
 
 	// Blank import: registers the "sqlite" driver with database/sql
 	// The blank identifier (_) tells Go we're importing this package only for its side effect
@@ -18,6 +37,28 @@ import (
 	_ "modernc.org/sqlite"
 )
 
+// intPtr returns a pointer to n, for filling in data.Book.Year.
+func intPtr(n int) *int {
+	return &n
+}
+
+// testBooks are the books every test app starts with (see setupTestApp).
+// They're listed here, rather than borrowed from the demo data, so tests can
+// check against them and keep passing whatever the demo seed turns into.
+// Their fixed IDs make /v1/books/1 and /v1/books/2 safe to rely on.
+var testBooks = []data.Book{
+	{ID: 1, Title: "The Go Programming Language", Author: "Alan Donovan", Year: intPtr(2015)},
+	{ID: 2, Title: "Designing Data-Intensive Applications", Author: "Martin Kleppmann", Year: intPtr(2017)},
+}
+
+// newJSONRequest builds a test request with a JSON body, including the
+// Content-Type header readJSON insists on.
+func newJSONRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
 func setupTestApp(t *testing.T) *App {
 	// Mark this function as a test helper
 	// This tells Go's test runner that if a test fails, the error should point
@@ -47,20 +88,32 @@ func setupTestApp(t *testing.T) *App {
 
 	// Run the database migrations to create the tables we need
 	// If migration fails, we can't run the test, so we stop immediately
-	if err := data.Migrate(db); err != nil {
+	if err := data.Migrate(db, data.DriverSQLite); err != nil {
 		t.Fatal(err)
 	}
 
-	// Seed the database with initial test data if it's empty
+	// Seed the database with our test fixtures (testBooks)
 	// This gives us a known starting state for our tests
 	// If seeding fails, we stop the test
-	if err := data.SeedIfEmpty(db); err != nil {
+	if err := data.SeedBooks(db, data.DriverSQLite, testBooks); err != nil {
 		t.Fatal(err)
 	}
 
 	// Return a new App instance with the test database
 	// This is what our test handlers will use instead of the real database
-	return &App{Stores: data.NewStores(db)}
+	// Logs are thrown away (io.Discard) to keep the test output clean
+	app := &App{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Stores: data.NewStores(db, data.Options{Driver: data.DriverSQLite}),
+		quit:   make(chan struct{}),
+	}
+
+	// Stop any background tasks (like the rate limiter's clean-up) once the test is done
+	t.Cleanup(func() {
+		close(app.quit)
+	})
+
+	return app
 }
 
 func TestListBooksHandler(t *testing.T) {
@@ -68,7 +121,7 @@ func TestListBooksHandler(t *testing.T) {
 	app := setupTestApp(t)
 
 	// create test request
-	req := httptest.NewRequest(http.MethodGet, "/books", http.NoBody)
+	req := httptest.NewRequest(http.MethodGet, "/v1/books", http.NoBody)
 
 	// create test recorder
 	rr := httptest.NewRecorder()
@@ -81,60 +134,117 @@ func TestListBooksHandler(t *testing.T) {
 		t.Errorf("want status code %d; got %d", http.StatusOK, rr.Code)
 	}
 
-	// create a bookResponse var
-	var resp bookResponse
-
-	// decode the response body into the booksResponse var
-	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+	// decode the envelope, keeping each key's value as raw JSON for now
+	var env map[string]json.RawMessage
+	if err := json.NewDecoder(rr.Body).Decode(&env); err != nil {
 		t.Fatal(err)
 	}
 
-	// check length of books
-	booksCount := len(resp.Books)
-	if booksCount != 2 {
-		t.Errorf("want books count of 2; got %d", booksCount)
+	// outside cursor mode, the books are the only thing in the envelope
+	if len(env) != 1 {
+		t.Errorf("want just a books key in the envelope; got %d keys", len(env))
+	}
+	raw, ok := env["books"]
+	if !ok {
+		t.Fatal(`want a "books" key in the envelope`)
+	}
+
+	// decode the books and check how many there are
+	var books []data.Book
+	if err := json.Unmarshal(raw, &books); err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != len(testBooks) {
+		t.Errorf("want books count of %d; got %d", len(testBooks), len(books))
 	}
 }
 
 func TestShowBookHandler(t *testing.T) {
-	// setup test
-	app := setupTestApp(t)
+	t.Run("existing book", func(t *testing.T) {
+		// setup test
+		app := setupTestApp(t)
 
-	// create test request
-	req := httptest.NewRequest(http.MethodGet, "/books/1", http.NoBody)
+		// create test request
+		req := httptest.NewRequest(http.MethodGet, "/v1/books/1", http.NoBody)
 
-	// create test recorder
-	rr := httptest.NewRecorder()
+		// create test recorder
+		rr := httptest.NewRecorder()
 
-	// send the request through the router
-	// We’re updating our tests to send requests through the router, just like real HTTP traffic would.
-	// This ensures path parameters like {id} are parsed correctly, and keeps all of our handler tests consistent.
-	app.routes().ServeHTTP(rr, req)
+		// send the request through the router
+		// We’re updating our tests to send requests through the router, just like real HTTP traffic would.
+		// This ensures path parameters like {id} are parsed correctly, and keeps all of our handler tests consistent.
+		app.routes().ServeHTTP(rr, req)
 
-	// check status code
-	if rr.Code != http.StatusOK { // 200
-		t.Errorf("want status code %d; got %d", http.StatusOK, rr.Code)
-	}
+		// check status code
+		if rr.Code != http.StatusOK { // 200
+			t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+		}
 
-	// create a book var
-	var book data.Book
+		// create a book var
+		var book data.Book
 
-	// decode the response body into the book var
-	if err := json.NewDecoder(rr.Body).Decode(&book); err != nil {
-		t.Fatal(err)
-	}
+		// decode the response body into the book var
+		if err := json.NewDecoder(rr.Body).Decode(&book); err != nil {
+			t.Fatal(err)
+		}
+
+		// expected book: the first of our fixtures
+		expected := testBooks[0]
+
+		// check each field the client cares about, so a failure says which one is wrong
+		if book.ID != expected.ID {
+			t.Errorf("want ID %d; got %d", expected.ID, book.ID)
+		}
+		if book.Title != expected.Title {
+			t.Errorf("want title %q; got %q", expected.Title, book.Title)
+		}
+		if book.Author != expected.Author {
+			t.Errorf("want author %q; got %q", expected.Author, book.Author)
+		}
+		if book.Year == nil || *book.Year != *expected.Year {
+			t.Errorf("want year %d; got %v", *expected.Year, book.Year)
+		}
 
-	// expected book
-	expected := data.Book{
-		ID:     1,
-		Title:  "The Go Programming Language",
-		Author: "Alan Donovan",
-		Year:   2015,
+		// The timestamps are set by the database, so check they're filled in,
+		// then leave them out of the comparison
+		if book.CreatedAt.IsZero() || book.UpdatedAt.IsZero() {
+			t.Errorf("expected created_at and updated_at to be set; got %#v", book)
+		}
+		book.CreatedAt, book.UpdatedAt = time.Time{}, time.Time{}
+
+		// and nothing else should have crept in
+		// (reflect.DeepEqual compares the year a pointer points to, not the pointer)
+		if !reflect.DeepEqual(book, expected) {
+			t.Errorf("want %#v; got %#v", expected, book)
+		}
+	})
+
+	// An ID with no book, and one that can't be an ID at all, both get a JSON 404
+	notFound := []struct {
+		name string
+		path string
+	}{
+		{name: "non-existent id", path: "/v1/books/9999"},
+		{name: "invalid id", path: "/v1/books/abc"},
 	}
+	for _, tc := range notFound {
+		t.Run(tc.name, func(t *testing.T) {
+			app := setupTestApp(t)
+
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, http.NoBody))
 
-	// check book against expected
-	if book != expected {
-		t.Errorf("want %#v; got %#v", expected, book)
+			if rr.Code != http.StatusNotFound {
+				t.Errorf("want status code %d; got %d", http.StatusNotFound, rr.Code)
+			}
+			var resp map[string]any
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := resp["error"]; !ok {
+				t.Errorf("expected 'error' field in response, got: %#v", resp)
+			}
+		})
 	}
 }
 
@@ -154,7 +264,7 @@ func TestCreateBookHandler_ValidInput(t *testing.T) {
 	}`)
 
 	// Make POST request with valid JSON
-	req := httptest.NewRequest(http.MethodPost, "/books", body)
+	req := httptest.NewRequest(http.MethodPost, "/v1/books", body)
 
 	// It's important to set the Content-Type header so the server knows to treat the body as JSON.
 	req.Header.Set("Content-Type", "application/json")
@@ -186,12 +296,12 @@ func TestCreateBookHandler_ValidInput(t *testing.T) {
 	if book.Author != "Gary Clarke" {
 		t.Errorf("expected author to be 'Gary Clarke'; got %q", book.Author)
 	}
-	if book.Year != 2030 {
-		t.Errorf("expected year to be 2030; got %d", book.Year)
+	if book.Year == nil || *book.Year != 2030 {
+		t.Errorf("expected year to be 2030; got %v", book.Year)
 	}
 
 	// Verify book exists in the DB
-	stored, err := app.Stores.Books.Get(book.ID)
+	stored, err := app.Stores.Books.Get(t.Context(), book.ID)
 	if err != nil {
 		t.Fatalf("failed to fetch book from DB: %v", err)
 	}
@@ -200,23 +310,309 @@ func TestCreateBookHandler_ValidInput(t *testing.T) {
 	// stored is a *Book (a pointer), but book is a value.
 	// To compare them properly, we dereference stored using *stored
 	// so we’re comparing two Book values directly.
-	if *stored != book {
+	if !reflect.DeepEqual(*stored, book) {
 		t.Errorf("book in DB does not match response. got: %#v", stored)
 	}
 }
 
+// TestCreateBookHandler_Year checks a book can be created with or without a
+// year, and that an unknown year is left out of the response.
+func TestPutBookHandler_IfMatch(t *testing.T) {
+	app := setupTestApp(t)
+	router := app.routes()
+
+	put := func(ifMatch, title string) *httptest.ResponseRecorder {
+		req := newJSONRequest(http.MethodPut, "/v1/books/1",
+			strings.NewReader(`{"title": "`+title+`", "author": "Alan Donovan", "year": 2015}`))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Step 1: Fetch the book and its ETag
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/1", http.NoBody))
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("want an ETag on GET /v1/books/1")
+	}
+
+	// Step 2: Without If-Match, the update is refused
+	if rr := put("", "Unconditional"); rr.Code != http.StatusPreconditionRequired {
+		t.Errorf("without If-Match: want status code %d; got %d", http.StatusPreconditionRequired, rr.Code)
+	}
+
+	// Step 3: With the current ETag, it goes through and we get the new ETag
+	rr = put(etag, "First Edit")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("current If-Match: want status code %d; got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+	if newETag := rr.Header().Get("ETag"); newETag == "" || newETag == etag {
+		t.Errorf("want a new ETag after the update; got %q (was %q)", newETag, etag)
+	}
+
+	// Step 4: The ETag we started with is stale now, so a second update based on it fails
+	rr = put(etag, "Lost Update")
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale If-Match: want status code %d; got %d", http.StatusPreconditionFailed, rr.Code)
+	}
+	book, err := app.Stores.Books.Get(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Title != "First Edit" {
+		t.Errorf("want the first edit kept; got title %q", book.Title)
+	}
+}
+
+func TestPutBookHandler_Genre(t *testing.T) {
+	app := setupTestApp(t)
+	router := app.routes()
+
+	// put replaces book 1 using its current ETag, and returns the genre stored
+	put := func(body string) string {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/1", http.NoBody))
+
+		req := newJSONRequest(http.MethodPut, "/v1/books/1", strings.NewReader(body))
+		req.Header.Set("If-Match", rr.Header().Get("ETag"))
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("want status code %d; got %d: %s", http.StatusOK, rr.Code, rr.Body)
+		}
+
+		book, err := app.Stores.Books.Get(t.Context(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return book.Genre
+	}
+
+	// Step 1: A PUT with a genre sets it
+	if got := put(`{"title": "The Go Programming Language", "author": "Alan Donovan", "year": 2015, "genre": "Programming"}`); got != "Programming" {
+		t.Errorf("with a genre: want %q stored; got %q", "Programming", got)
+	}
+
+	// Step 2: PUT replaces the whole book, so leaving the genre out clears it
+	if got := put(`{"title": "The Go Programming Language", "author": "Alan Donovan", "year": 2015}`); got != "" {
+		t.Errorf("without a genre: want it cleared; got %q", got)
+	}
+}
+
+func TestPutBookHandler_BodyID(t *testing.T) {
+	app := setupTestApp(t)
+	router := app.routes()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/1", http.NoBody))
+	etag := rr.Header().Get("ETag")
+
+	put := func(body string) *httptest.ResponseRecorder {
+		req := newJSONRequest(http.MethodPut, "/v1/books/1", strings.NewReader(body))
+		req.Header.Set("If-Match", etag)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Step 1: An id that isn't the one in the path is refused, and neither book changes
+	rr = put(`{"id": 2, "title": "Cross Update", "author": "Alan Donovan", "year": 2015}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("mismatched id: want status code %d; got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+	var resp struct {
+		Fields map[string]string `json:"fields"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp.Fields["id"]; !ok {
+		t.Errorf("want an error for id; got %v", resp.Fields)
+	}
+	for _, id := range []int64{1, 2} {
+		book, err := app.Stores.Books.Get(t.Context(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if book.Title != testBooks[id-1].Title {
+			t.Errorf("want book %d unchanged; got title %q", id, book.Title)
+		}
+	}
+
+	// Step 2: The matching id is fine
+	rr = put(`{"id": 1, "title": "Same Book", "author": "Alan Donovan", "year": 2015}`)
+	if rr.Code != http.StatusOK {
+		t.Errorf("matching id: want status code %d; got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+}
+
+func TestPatchBookYearHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		payload    string
+		wantStatus int
+	}{
+		{name: "valid year", path: "/v1/books/1/year", payload: `{"year": 2016}`, wantStatus: http.StatusOK},
+		{name: "future year", path: "/v1/books/1/year", payload: `{"year": 3000}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "negative year", path: "/v1/books/1/year", payload: `{"year": -5}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "missing year", path: "/v1/books/1/year", payload: `{}`, wantStatus: http.StatusUnprocessableEntity},
+		{name: "unknown book", path: "/v1/books/9999/year", payload: `{"year": 2016}`, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := setupTestApp(t)
+
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, newJSONRequest(http.MethodPatch, tc.path, strings.NewReader(tc.payload)))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("want status code %d; got %d: %s", tc.wantStatus, rr.Code, rr.Body)
+			}
+
+			// Re-read the book: only a valid correction changes it
+			book, err := app.Stores.Books.Get(t.Context(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantYear := 2015 // the seeded year
+			if tc.wantStatus == http.StatusOK {
+				wantYear = 2016
+			}
+			if book.Year == nil || *book.Year != wantYear {
+				t.Errorf("want year %d; got %v", wantYear, book.Year)
+			}
+		})
+	}
+}
+
+func TestCreateBookHandler_TrimsWhitespace(t *testing.T) {
+	app := setupTestApp(t)
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, newJSONRequest(http.MethodPost, "/v1/books",
+		strings.NewReader(`{"title": "  Trimmed\t", "author": " Gary Clarke\n"}`)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("want status code %d; got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+	}
+
+	// The saved book has the trimmed values
+	var created data.Book
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := app.Stores.Books.Get(t.Context(), created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Title != "Trimmed" || saved.Author != "Gary Clarke" {
+		t.Errorf("want the title and author trimmed; got %q and %q", saved.Title, saved.Author)
+	}
+}
+
+func TestCreateBookHandler_Year(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string
+		wantYear string // the raw JSON year, or "" when it should be missing
+	}{
+		{name: "with a year", payload: `{"title": "Dated", "author": "Gary Clarke", "year": 2024}`, wantYear: "2024"},
+		{name: "without a year", payload: `{"title": "Undated", "author": "Gary Clarke"}`},
+		{name: "null year", payload: `{"title": "Null Year", "author": "Gary Clarke", "year": null}`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := setupTestApp(t)
+
+			// Step 1: Create the book
+			req := newJSONRequest(http.MethodPost, "/v1/books", strings.NewReader(tc.payload))
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("want status code %d; got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+			}
+
+			// Step 2: Check the year key in the raw JSON
+			var body map[string]json.RawMessage
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			year, ok := body["year"]
+			switch {
+			case tc.wantYear == "" && ok:
+				t.Errorf("want no year key; got %s", year)
+			case tc.wantYear != "" && string(year) != tc.wantYear:
+				t.Errorf("want year %s; got %s", tc.wantYear, year)
+			}
+		})
+	}
+}
+
+// TestCreateBookHandler_YearRoundTrip creates a book and reads it back, and
+// checks the year comes out under the same lowercase key it went in with.
+func TestCreateBookHandler_YearRoundTrip(t *testing.T) {
+	app := setupTestApp(t)
+	routes := app.routes()
+
+	// Step 1: Create a book
+	req := newJSONRequest(http.MethodPost, "/v1/books", strings.NewReader(`{"title": "Round Trip", "author": "Gary Clarke", "year": 2021}`))
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("want status code %d; got %d", http.StatusCreated, rr.Code)
+	}
+	var created map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	// Step 2: Read it back
+	req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/v1/books/%v", created["id"]), nil)
+	rr = httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+	var read map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&read); err != nil {
+		t.Fatal(err)
+	}
+
+	// Step 3: Both responses use "year", and agree on it
+	for name, body := range map[string]map[string]any{"create": created, "read": read} {
+		if _, ok := body["Year"]; ok {
+			t.Errorf(`%s: want no capitalised "Year" key; got %v`, name, body)
+		}
+		if body["year"] != float64(2021) {
+			t.Errorf("%s: want year 2021; got %v", name, body["year"])
+		}
+	}
+}
+
 func TestCreateBookHandler_InvalidInput(t *testing.T) {
 	tests := []struct {
 		name     string
 		payload  string
 		wantCode int
-		wantKeys []string // expected keys in the "errors" object of the response
+		wantKeys []string // expected keys in the "fields" object of the response
 	}{
 		{
 			name:     "missing all fields",
 			payload:  `{}`,
 			wantCode: http.StatusUnprocessableEntity,
-			wantKeys: []string{"title", "author", "year"},
+			wantKeys: []string{"title", "author"}, // the year is optional
+		},
+		{
+			name:     "invalid year (negative)",
+			payload:  `{"title": "Testing Go", "author": "Gary", "year": -5}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantKeys: []string{"year"},
 		},
 		{
 			name:     "missing title",
@@ -240,7 +636,7 @@ func TestCreateBookHandler_InvalidInput(t *testing.T) {
 			name:     "invalid JSON format",
 			payload:  `{`,
 			wantCode: http.StatusBadRequest,
-			wantKeys: nil, // No "errors" object expected — it's a decoding error
+			wantKeys: nil, // No "fields" object expected — it's a decoding error
 		},
 	}
 
@@ -252,7 +648,7 @@ func TestCreateBookHandler_InvalidInput(t *testing.T) {
 			// Step 2: Create a request using tc.payload as the body
 			body := strings.NewReader(tc.payload)
 
-			req := httptest.NewRequest(http.MethodPost, "/books", body)
+			req := httptest.NewRequest(http.MethodPost, "/v1/books", body)
 
 			// Step 3: Set the Content-Type header to application/json
 			req.Header.Set("Content-Type", "application/json")
@@ -277,10 +673,14 @@ func TestCreateBookHandler_InvalidInput(t *testing.T) {
 				if err != nil {
 					t.Fatal(err)
 				}
-				// Assert that "errors" field exists and is a map[string]any
-				errorsMap, ok := resp["errors"].(map[string]any)
+				// Assert that "error" says validation failed, and that
+				// "fields" exists and is a map[string]any
+				if resp["error"] != "validation failed" {
+					t.Errorf("want error %q; got %#v", "validation failed", resp["error"])
+				}
+				errorsMap, ok := resp["fields"].(map[string]any)
 				if !ok {
-					t.Fatalf("expected 'errors' field in response, got: %#v", resp)
+					t.Fatalf("expected 'fields' field in response, got: %#v", resp)
 				}
 
 				// Check that all expected error keys exist
@@ -293,3 +693,2625 @@ func TestCreateBookHandler_InvalidInput(t *testing.T) {
 		})
 	}
 }
+
+func TestShowBookHandler_ConditionalGet(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+
+	// Step 1: Fetch the book and grab its ETag
+	req := httptest.NewRequest(http.MethodGet, "/v1/books/1", http.NoBody)
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an ETag header")
+	}
+
+	// Step 2: Ask again, telling the server which version we already have
+	req = httptest.NewRequest(http.MethodGet, "/v1/books/1", http.NoBody)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	// Step 3: Nothing has changed, so we expect 304 and no body
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("want status code %d; got %d", http.StatusNotModified, rr.Code)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("expected an empty body; got %q", rr.Body.String())
+	}
+}
+
+func TestJSONContentType(t *testing.T) {
+	tests := []struct {
+		name    string
+		charset string
+		gzip    bool
+		want    string
+	}{
+		{name: "default charset", want: "application/json; charset=utf-8"},
+		{name: "configured charset", charset: "UTF-8", want: "application/json; charset=UTF-8"},
+		{name: "compressed", gzip: true, want: "application/json; charset=utf-8"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := setupTestApp(t)
+			app.Config.jsonCharset = tc.charset
+
+			// Go through every middleware, to catch any that adds a second value
+			req := httptest.NewRequest(http.MethodGet, "/v1/books", http.NoBody)
+			if tc.gzip {
+				req.Header.Set("Accept-Encoding", "gzip")
+			}
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, req)
+
+			if got := rr.Header().Values("Content-Type"); len(got) != 1 || got[0] != tc.want {
+				t.Errorf("want Content-Type %q; got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestListBooksHandler_Gzip(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+
+	// Add enough books that the list is worth compressing
+	for i := 0; i < 10; i++ {
+		book := &data.Book{Title: fmt.Sprintf("Gzip Book %d", i), Author: "Gary Clarke", Year: intPtr(2024)}
+		if _, err := app.Stores.Books.Insert(t.Context(), book); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// create test request, telling the server we can decode gzip
+	req := httptest.NewRequest(http.MethodGet, "/v1/books", http.NoBody)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	// check status code and headers
+	if rr.Code != http.StatusOK {
+		t.Errorf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+	if got := rr.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("want Content-Encoding gzip; got %q", got)
+	}
+	if got := rr.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("want Vary Accept-Encoding; got %q", got)
+	}
+
+	// the body should decompress into the usual JSON list
+	zr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp bookResponse
+	if err := json.NewDecoder(zr).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Books) != 12 {
+		t.Errorf("want books count of 12; got %d", len(resp.Books))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	// setup test with a limiter that allows a burst of 3 and refills very slowly
+	app := setupTestApp(t)
+	app.Config.limiter.enabled = true
+	app.Config.limiter.rps = 0.001
+	app.Config.limiter.burst = 3
+
+	// Build the router once so every request shares the same limiter
+	router := app.routes()
+
+	// Send burst+1 requests from the same client
+	var rr *httptest.ResponseRecorder
+	for i := 0; i <= app.Config.limiter.burst; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/healthz", http.NoBody)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		// Everything within the burst should be fine
+		if i < app.Config.limiter.burst && rr.Code != http.StatusOK {
+			t.Fatalf("request %d: want status code %d; got %d", i+1, http.StatusOK, rr.Code)
+		}
+	}
+
+	// The last one should have been rejected with a JSON error
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("want status code %d; got %d", http.StatusTooManyRequests, rr.Code)
+	}
+
+	var resp map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp["error"]; !ok {
+		t.Errorf("expected 'error' field in response, got: %#v", resp)
+	}
+}
+
+func TestShowBookHandler_ContentNegotiation(t *testing.T) {
+	// expected book: the first of our fixtures
+	expected := testBooks[0]
+
+	tests := []struct {
+		name            string
+		accept          string
+		wantContentType string
+		decode          func(body io.Reader, v any) error
+	}{
+		{
+			name:            "json",
+			accept:          "application/json",
+			wantContentType: "application/json; charset=utf-8",
+			decode:          func(body io.Reader, v any) error { return json.NewDecoder(body).Decode(v) },
+		},
+		{
+			name:            "xml",
+			accept:          "application/xml",
+			wantContentType: "application/xml",
+			decode:          func(body io.Reader, v any) error { return xml.NewDecoder(body).Decode(v) },
+		},
+		{
+			name:            "no preference defaults to json",
+			accept:          "*/*",
+			wantContentType: "application/json; charset=utf-8",
+			decode:          func(body io.Reader, v any) error { return json.NewDecoder(body).Decode(v) },
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// setup test
+			app := setupTestApp(t)
+
+			// create test request with the Accept header for this case
+			req := httptest.NewRequest(http.MethodGet, "/v1/books/1", http.NoBody)
+			req.Header.Set("Accept", tc.accept)
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, req)
+
+			// check status code and Content-Type
+			if rr.Code != http.StatusOK {
+				t.Errorf("want status code %d; got %d", http.StatusOK, rr.Code)
+			}
+			if got := rr.Header().Get("Content-Type"); got != tc.wantContentType {
+				t.Errorf("want Content-Type %q; got %q", tc.wantContentType, got)
+			}
+
+			// decode the body in the expected format
+			var book data.Book
+			if err := tc.decode(rr.Body, &book); err != nil {
+				t.Fatal(err)
+			}
+
+			// XMLName is only filled in by the XML decoder, and the timestamps are
+			// set by the database, so ignore them when comparing
+			book.XMLName = expected.XMLName
+			book.CreatedAt, book.UpdatedAt = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(book, expected) {
+				t.Errorf("want %+v; got %+v", expected, book)
+			}
+		})
+	}
+}
+
+func TestExportBooksHandler(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+
+	// create test request and recorder, then send it through the router
+	req := httptest.NewRequest(http.MethodGet, "/v1/books/export", http.NoBody)
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	// check status code and headers
+	if rr.Code != http.StatusOK {
+		t.Errorf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+	if got := rr.Header().Get("Content-Type"); got != "text/csv" {
+		t.Errorf("want Content-Type text/csv; got %q", got)
+	}
+	if got := rr.Header().Get("Content-Disposition"); !strings.Contains(got, `filename="books.csv"`) {
+		t.Errorf("want attachment filename books.csv; got %q", got)
+	}
+
+	// read the CSV back
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// header row + the two seeded books
+	if len(records) != 3 {
+		t.Fatalf("want 3 rows; got %d", len(records))
+	}
+	if got := strings.Join(records[0], ","); got != "id,title,author,year" {
+		t.Errorf("want header row id,title,author,year; got %q", got)
+	}
+}
+
+func TestExportBooksHandler_Range(t *testing.T) {
+	app := setupTestApp(t)
+	router := app.routes()
+
+	// Step 1: The full export says ranges are allowed
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/export", http.NoBody))
+	if got := rr.Header().Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("want Accept-Ranges bytes; got %q", got)
+	}
+	full := rr.Body.String()
+
+	// Step 2: Ask for the rest of the file from byte 10, as a resumed download
+	// would. Accept-Encoding checks the partial body isn't compressed.
+	req := httptest.NewRequest(http.MethodGet, "/v1/books/export", http.NoBody)
+	req.Header.Set("Range", "bytes=10-")
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusPartialContent {
+		t.Fatalf("want status code %d; got %d", http.StatusPartialContent, rr.Code)
+	}
+	wantRange := fmt.Sprintf("bytes 10-%d/%d", len(full)-1, len(full))
+	if got := rr.Header().Get("Content-Range"); got != wantRange {
+		t.Errorf("want Content-Range %q; got %q", wantRange, got)
+	}
+	if got := rr.Body.String(); got != full[10:] {
+		t.Errorf("want the bytes from 10 on:\n%q\ngot:\n%q", full[10:], got)
+	}
+
+	// Step 3: A range past the end can't be satisfied
+	req = httptest.NewRequest(http.MethodGet, "/v1/books/export", http.NoBody)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(full)+100))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("want status code %d; got %d", http.StatusRequestedRangeNotSatisfiable, rr.Code)
+	}
+}
+
+func TestImportBooksHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		contentType  string
+		payload      string
+		wantImported int
+		wantSkipped  int
+	}{
+		{
+			name:        "csv",
+			contentType: "text/csv",
+			payload: "title,author,year\n" +
+				"Learning Go,Jon Bodner,2021\n" +
+				",Missing Title,2020\n" +
+				"The Go Programming Language,Alan Donovan,2015\n", // already seeded
+			wantImported: 1,
+			wantSkipped:  2,
+		},
+		{
+			name:        "json",
+			contentType: "application/json",
+			payload: `[
+				{"title": "Learning Go", "author": "Jon Bodner", "year": 2021},
+				{"title": "Concurrency in Go", "author": "Katherine Cox-Buday", "year": 2017},
+				{"title": "Year Zero", "author": "Someone", "year": 0}
+			]`,
+			wantImported: 2,
+			wantSkipped:  1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// setup test
+			app := setupTestApp(t)
+
+			// send the import through the router
+			req := httptest.NewRequest(http.MethodPost, "/v1/books/import", strings.NewReader(tc.payload))
+			req.Header.Set("Content-Type", tc.contentType)
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("want status code %d; got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+			}
+
+			// check the summary
+			var resp importResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Imported != tc.wantImported {
+				t.Errorf("want %d imported; got %d", tc.wantImported, resp.Imported)
+			}
+			if resp.Skipped != tc.wantSkipped {
+				t.Errorf("want %d skipped; got %d", tc.wantSkipped, resp.Skipped)
+			}
+			if len(resp.Errors) != tc.wantSkipped {
+				t.Errorf("want %d row errors; got %d", tc.wantSkipped, len(resp.Errors))
+			}
+
+			// the imported books should now be in the DB alongside the seeded ones
+			books, err := app.Stores.Books.GetAll(t.Context(), data.BookFilters{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := len(testBooks) + tc.wantImported; len(books) != want {
+				t.Errorf("want %d books in DB; got %d", want, len(books))
+			}
+		})
+	}
+}
+
+func TestImportBooksHandler_DryRun(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+	payload := "title,author,year\n" +
+		"Learning Go,Jon Bodner,2021\n" +
+		",Missing Title,2020\n" +
+		"The Go Programming Language,Alan Donovan,2015\n" // already seeded
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/books/import?dry_run=true", strings.NewReader(payload))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// The summary is what a real import would report...
+	var resp importResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.DryRun || resp.Imported != 1 || resp.Skipped != 2 || len(resp.Errors) != 2 {
+		t.Errorf("want a dry run with 1 imported and 2 skipped; got %+v", resp)
+	}
+
+	// ...but nothing was saved
+	books, err := app.Stores.Books.GetAll(t.Context(), data.BookFilters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != len(testBooks) {
+		t.Errorf("want only the %d seeded books in DB; got %d", len(testBooks), len(books))
+	}
+}
+
+func TestHealthcheckHandler_Version(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         string
+		showVersion bool
+		wantVersion bool
+	}{
+		{name: "development", env: "development", wantVersion: true},
+		{name: "production", env: "production", wantVersion: false},
+		{name: "production with -show-version", env: "production", showVersion: true, wantVersion: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := setupTestApp(t)
+			app.Config.env = tc.env
+			app.Config.showVersion = tc.showVersion
+
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", http.NoBody))
+
+			var body map[string]any
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := body["version"]; ok != tc.wantVersion {
+				t.Errorf("want version in the response: %v; got %v", tc.wantVersion, body)
+			}
+			if body["status"] != "ok" {
+				t.Errorf("want status ok; got %v", body["status"])
+			}
+		})
+	}
+}
+
+func TestServerHeader(t *testing.T) {
+	app := setupTestApp(t)
+	app.Config.server.header = "first-go-app"
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books", http.NoBody))
+
+	if got := rr.Header().Get("Server"); got != "first-go-app" {
+		t.Errorf("want Server first-go-app; got %q", got)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+
+	t.Run("generated when absent", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/healthz", http.NoBody)
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		// a fresh UUID should be echoed back
+		if _, err := uuid.Parse(rr.Header().Get("X-Request-ID")); err != nil {
+			t.Errorf("expected a generated UUID request ID; got %q", rr.Header().Get("X-Request-ID"))
+		}
+	})
+
+	t.Run("reused when present", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/healthz", http.NoBody)
+		req.Header.Set("X-Request-ID", "abc-123")
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("X-Request-ID"); got != "abc-123" {
+			t.Errorf("want X-Request-ID abc-123; got %q", got)
+		}
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+	router := app.routes()
+
+	// make a request so there's something to count
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books", http.NoBody))
+
+	// then read the metrics
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/debug/vars", http.NoBody))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+
+	var resp map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// the counters are shared by every test, so we only check they've counted something
+	if got, _ := resp["total_requests_received"].(float64); got < 1 {
+		t.Errorf("want total_requests_received >= 1; got %v", resp["total_requests_received"])
+	}
+	byStatus, ok := resp["total_responses_sent_by_status"].(map[string]any)
+	if !ok {
+		t.Fatalf("expected total_responses_sent_by_status in metrics, got: %#v", resp)
+	}
+	if got, _ := byStatus["2xx"].(float64); got < 1 {
+		t.Errorf("want at least one 2xx response; got %v", byStatus["2xx"])
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		closeDB    bool // simulate the database going away
+		wantCode   int
+		wantStatus string
+	}{
+		{name: "live", path: "/healthz/live", wantCode: http.StatusOK, wantStatus: "ok"},
+		{name: "live with db down", path: "/healthz/live", closeDB: true, wantCode: http.StatusOK, wantStatus: "ok"},
+		{name: "ready", path: "/healthz/ready", wantCode: http.StatusOK, wantStatus: "ok"},
+		{name: "ready with db down", path: "/healthz/ready", closeDB: true, wantCode: http.StatusServiceUnavailable, wantStatus: "unavailable"},
+		{name: "combined", path: "/healthz", wantCode: http.StatusOK, wantStatus: "ok"},
+		{name: "combined with db down", path: "/healthz", closeDB: true, wantCode: http.StatusServiceUnavailable, wantStatus: "degraded"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// setup test
+			app := setupTestApp(t)
+			if tc.closeDB {
+				app.Stores.Books.DB.Close()
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, http.NoBody)
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantCode {
+				t.Errorf("want status code %d; got %d", tc.wantCode, rr.Code)
+			}
+
+			var resp healthResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Status != tc.wantStatus {
+				t.Errorf("want status %q; got %q", tc.wantStatus, resp.Status)
+			}
+		})
+	}
+}
+
+// listBookIDs fetches GET /v1/books (plus any query string) and returns the IDs in the response.
+func listBookIDs(t *testing.T, app *App, query string) []int64 {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/books"+query, http.NoBody)
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+
+	var resp bookResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []int64
+	for _, b := range resp.Books {
+		ids = append(ids, b.ID)
+	}
+	return ids
+}
+
+func TestDeleteBookHandler_SoftDelete(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+
+	// Step 1: Delete book 1
+	req := httptest.NewRequest(http.MethodDelete, "/v1/books/1", http.NoBody)
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+
+	// Step 2: It should be hidden from the list...
+	if ids := listBookIDs(t, app, ""); len(ids) != 1 || ids[0] != 2 {
+		t.Errorf("want only book 2 listed; got %v", ids)
+	}
+
+	// ...unless we ask for deleted books too
+	if ids := listBookIDs(t, app, "?include_deleted=true"); len(ids) != 2 {
+		t.Errorf("want both books listed with include_deleted; got %v", ids)
+	}
+
+	// Step 3: Deleting it again is a 404, since it's already gone
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/v1/books/1", http.NoBody))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("want status code %d; got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestDeleteBookHandler_Restore(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+
+	// Step 1: Delete book 1
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/v1/books/1", http.NoBody))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+
+	// Step 2: Restore it
+	if err := app.Stores.Books.Restore(t.Context(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Step 3: It should be visible again
+	if ids := listBookIDs(t, app, ""); len(ids) != 2 {
+		t.Errorf("want both books listed after restore; got %v", ids)
+	}
+
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/1", http.NoBody))
+	if rr.Code != http.StatusOK {
+		t.Errorf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+}
+
+func TestDeleteBookHandler_Recreate(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+	create := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, newJSONRequest(http.MethodPost, "/v1/books",
+			strings.NewReader(`{"title": "The Go Programming Language", "author": "Alan Donovan", "year": 2015}`)))
+		return rr
+	}
+
+	// Step 1: While book 1 is there, adding it again is a conflict
+	if rr := create(); rr.Code != http.StatusConflict {
+		t.Fatalf("before delete: want status code %d; got %d", http.StatusConflict, rr.Code)
+	}
+
+	// Step 2: Delete it
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/v1/books/1", http.NoBody))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+
+	// Step 3: Now the same book can be created again, as a new book
+	if rr := create(); rr.Code != http.StatusCreated {
+		t.Fatalf("after delete: want status code %d; got %d: %s", http.StatusCreated, rr.Code, rr.Body)
+	}
+
+	// Step 4: The deleted one can't come back while its replacement is there
+	if err := app.Stores.Books.Restore(t.Context(), 1); !errors.Is(err, data.ErrDuplicateBook) {
+		t.Errorf("restore: want ErrDuplicateBook; got %v", err)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	// setup test with an API token configured
+	app := setupTestApp(t)
+	app.Config.auth.token = "s3cret"
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		wantStatus    int
+	}{
+		{"read without a token", http.MethodGet, "", http.StatusOK},
+		{"write without a token", http.MethodDelete, "", http.StatusUnauthorized},
+		{"write with the wrong token", http.MethodDelete, "Bearer wrong", http.StatusUnauthorized},
+		{"write with the right token", http.MethodDelete, "Bearer s3cret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The unauthorized deletes never reach the handler, so book 2
+			// is still there for the authorized delete (which runs last).
+			req := httptest.NewRequest(tt.method, "/v1/books/2", http.NoBody)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("want status code %d; got %d", tt.wantStatus, rr.Code)
+			}
+			if tt.wantStatus == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("want WWW-Authenticate header %q; got %q", "Bearer", rr.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestReviewsHandlers(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+	router := app.routes()
+
+	// Step 1: Add a valid review to book 1
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, newJSONRequest(http.MethodPost, "/v1/books/1/reviews",
+		strings.NewReader(`{"rating": 4, "body": "A great introduction"}`)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("want status code %d; got %d", http.StatusCreated, rr.Code)
+	}
+
+	// Step 2: An invalid review is rejected with 422
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, newJSONRequest(http.MethodPost, "/v1/books/1/reviews",
+		strings.NewReader(`{"rating": 9, "body": ""}`)))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("want status code %d; got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+
+	// Step 3: Reviewing a book that doesn't exist is a 404
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, newJSONRequest(http.MethodPost, "/v1/books/999/reviews",
+		strings.NewReader(`{"rating": 4, "body": "Who wrote this?"}`)))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("want status code %d; got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// Step 4: Listing book 1's reviews returns just the valid one
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/1/reviews", http.NoBody))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+
+	var resp reviewResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Reviews) != 1 || resp.Reviews[0].Rating != 4 || resp.Reviews[0].BookID != 1 {
+		t.Errorf("want one 4-star review of book 1; got %+v", resp.Reviews)
+	}
+}
+
+func TestFieldSelection(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantKeys   []string
+	}{
+		{"show with fields", "/v1/books/1?fields=id,title", http.StatusOK, []string{"id", "title"}},
+		{"show with unknown field", "/v1/books/1?fields=id,password", http.StatusUnprocessableEntity, []string{"error", "fields"}},
+		{"list with fields", "/v1/books?fields=title", http.StatusOK, []string{"books"}},
+		{"list with unknown field", "/v1/books?fields=nope", http.StatusUnprocessableEntity, []string{"error", "fields"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.url, http.NoBody))
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("want status code %d; got %d", tt.wantStatus, rr.Code)
+			}
+
+			var resp map[string]any
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp) != len(tt.wantKeys) {
+				t.Errorf("want keys %v; got %v", tt.wantKeys, resp)
+			}
+			for _, key := range tt.wantKeys {
+				if _, ok := resp[key]; !ok {
+					t.Errorf("want key %q in %v", key, resp)
+				}
+			}
+		})
+	}
+
+	// Each listed book should only have the one field we asked for
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books?fields=title", http.NoBody))
+
+	var list struct {
+		Books []map[string]any `json:"books"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range list.Books {
+		if _, ok := b["title"]; len(b) != 1 || !ok {
+			t.Errorf("want only a title; got %v", b)
+		}
+	}
+}
+
+func TestFields_TimestampsInUTC(t *testing.T) {
+	app := setupTestApp(t)
+
+	// Step 1: ?fields=created_at gives a UTC timestamp, like the full response
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/1?fields=created_at", http.NoBody))
+	var resp map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(resp["created_at"], "Z") {
+		t.Errorf("want created_at in UTC, ending in Z; got %q", resp["created_at"])
+	}
+
+	// Step 2: Even when the driver hands the timestamps back in local time
+	local := time.Date(2024, 5, 1, 10, 30, 0, 0, time.FixedZone("UTC+1", 60*60))
+	book := &data.Book{ID: 1, CreatedAt: local, UpdatedAt: local, DeletedAt: &local}
+	b, err := json.Marshal(selectFields(book, []string{"created_at", "updated_at", "deleted_at"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"created_at":"2024-05-01T09:30:00Z","deleted_at":"2024-05-01T09:30:00Z","updated_at":"2024-05-01T09:30:00Z"}`
+	if string(b) != want {
+		t.Errorf("want %s; got %s", want, b)
+	}
+}
+
+func TestListBooksHandler_NDJSON(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books?format=ndjson", http.NoBody))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("want Content-Type application/x-ndjson; got %q", ct)
+	}
+
+	// Every line should decode to a book on its own
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("want 2 lines; got %d: %q", len(lines), rr.Body.String())
+	}
+	for i, line := range lines {
+		var book data.Book
+		if err := json.Unmarshal([]byte(line), &book); err != nil {
+			t.Fatalf("line %d: %v", i+1, err)
+		}
+		if book.ID == 0 || book.Title == "" {
+			t.Errorf("line %d: want a valid book; got %+v", i+1, book)
+		}
+	}
+}
+
+func TestListBooksHandler_Cursor(t *testing.T) {
+	// setup test: add a third book so two pages of 2 have something on the second
+	app := setupTestApp(t)
+	if _, err := app.Stores.Books.Insert(t.Context(), &data.Book{Title: "Third", Author: "Someone", Year: intPtr(2024)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// getPage fetches one page of books and decodes it
+	getPage := func(query string) bookResponse {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books"+query, http.NoBody))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+		}
+		var resp bookResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Metadata == nil {
+			t.Fatal("want cursor metadata in the response")
+		}
+		return resp
+	}
+
+	// Step 1: The first page holds books 1 and 2, and points at the next page
+	first := getPage("?after_id=0&page_size=2")
+	if len(first.Books) != 2 || first.Books[0].ID != 1 || first.Books[1].ID != 2 {
+		t.Fatalf("want books 1 and 2 on the first page; got %+v", first.Books)
+	}
+	if first.Metadata.NextCursor != 2 {
+		t.Fatalf("want next_cursor 2; got %d", first.Metadata.NextCursor)
+	}
+
+	// Step 2: Following the cursor gives the last book, and no next cursor
+	second := getPage(fmt.Sprintf("?after_id=%d&page_size=2", first.Metadata.NextCursor))
+	if len(second.Books) != 1 || second.Books[0].ID != 3 {
+		t.Fatalf("want book 3 on the second page; got %+v", second.Books)
+	}
+	if second.Metadata.NextCursor != 0 {
+		t.Errorf("want next_cursor 0 on the last page; got %d", second.Metadata.NextCursor)
+	}
+
+	// Step 3: A bad page size is rejected (in strict mode, as in production)
+	app.Config.pagination.strict = true
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books?after_id=0&page_size=1000", http.NoBody))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("want status code %d; got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+}
+
+func TestDeleteAllBooksHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        string
+		url        string
+		wantStatus int
+		wantBooks  int
+	}{
+		{"outside development", "production", "/v1/books?confirm=true", http.StatusForbidden, 2},
+		{"without confirm", "development", "/v1/books", http.StatusBadRequest, 2},
+		{"confirmed in development", "development", "/v1/books?confirm=true", http.StatusOK, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// setup test with a fresh database for each case
+			app := setupTestApp(t)
+			app.Config.env = tt.env
+
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, tt.url, http.NoBody))
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("want status code %d; got %d", tt.wantStatus, rr.Code)
+			}
+
+			if tt.wantStatus == http.StatusOK {
+				var resp map[string]int64
+				if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp["deleted"] != 2 {
+					t.Errorf("want deleted 2; got %v", resp)
+				}
+			}
+
+			// Soft-deleted books count too, so check with include_deleted
+			if ids := listBookIDs(t, app, "?include_deleted=true"); len(ids) != tt.wantBooks {
+				t.Errorf("want %d books left; got %v", tt.wantBooks, ids)
+			}
+		})
+	}
+}
+
+func TestDeleteBooksHandler_IDs(t *testing.T) {
+	// setup test: a third book next to the two demo books
+	app := setupTestApp(t)
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, newJSONRequest(http.MethodPost, "/v1/books",
+		strings.NewReader(`{"title": "Keeper", "author": "Gary Clarke", "year": 2024}`)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("want status code %d; got %d", http.StatusCreated, rr.Code)
+	}
+
+	// Step 1: Delete two of the three. The unknown ID 99 is skipped.
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/v1/books?ids=1,2,99", http.NoBody))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+	var resp map[string]int64
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp["deleted"] != 2 {
+		t.Errorf("want deleted 2; got %v", resp)
+	}
+
+	// Step 2: Only the third book is left
+	if ids := listBookIDs(t, app, ""); !slices.Equal(ids, []int64{3}) {
+		t.Errorf("want only book 3 left; got %v", ids)
+	}
+
+	// Step 3: Bad lists are rejected without deleting anything
+	tooMany := strings.TrimSuffix(strings.Repeat("3,", maxBookIDs+1), ",")
+	for _, query := range []string{"?ids=", "?ids=3,abc", "?ids=" + tooMany} {
+		rr = httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/v1/books"+query, http.NoBody))
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: want status code %d; got %d", query, http.StatusUnprocessableEntity, rr.Code)
+		}
+	}
+	if ids := listBookIDs(t, app, ""); !slices.Equal(ids, []int64{3}) {
+		t.Errorf("want book 3 still there; got %v", ids)
+	}
+}
+
+func TestCreateBookHandler_Prefer(t *testing.T) {
+	tests := []struct {
+		name        string
+		prefer      string
+		wantBody    bool
+		wantApplied string
+	}{
+		{name: "no preference", wantBody: true},
+		{name: "minimal", prefer: "return=minimal", wantApplied: "return=minimal"},
+		{name: "representation", prefer: "return=representation", wantBody: true, wantApplied: "return=representation"},
+		{name: "among other preferences", prefer: "respond-async, return=minimal; foo=bar", wantApplied: "return=minimal"},
+		{name: "unknown value", prefer: "return=everything", wantBody: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// setup test
+			app := setupTestApp(t)
+
+			req := newJSONRequest(http.MethodPost, "/v1/books",
+				strings.NewReader(`{"title": "Preferred", "author": "Gary Clarke", "year": 2024}`))
+			if tc.prefer != "" {
+				req.Header.Set("Prefer", tc.prefer)
+			}
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("want status code %d; got %d", http.StatusCreated, rr.Code)
+			}
+			// The new book is always the next ID after the fixtures
+			wantLocation := fmt.Sprintf("/v1/books/%d", len(testBooks)+1)
+			if got := rr.Header().Get("Location"); got != wantLocation {
+				t.Errorf("want Location %q; got %q", wantLocation, got)
+			}
+			if got := rr.Header().Get("Preference-Applied"); got != tc.wantApplied {
+				t.Errorf("want Preference-Applied %q; got %q", tc.wantApplied, got)
+			}
+
+			if !tc.wantBody {
+				if rr.Body.Len() != 0 {
+					t.Errorf("want an empty body; got %q", rr.Body)
+				}
+				return
+			}
+			var book data.Book
+			if err := json.NewDecoder(rr.Body).Decode(&book); err != nil {
+				t.Fatal(err)
+			}
+			if book.Title != "Preferred" {
+				t.Errorf("want the created book in the body; got %+v", book)
+			}
+		})
+	}
+}
+
+func TestCreateBookHandler_Duplicate(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+	body := `{"title": "Twice Told", "author": "Nathaniel Hawthorne", "year": 1837}`
+
+	// The first insert works...
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, newJSONRequest(http.MethodPost, "/v1/books", strings.NewReader(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("want status code %d; got %d", http.StatusCreated, rr.Code)
+	}
+
+	// ...but the same title and author again is a conflict
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, newJSONRequest(http.MethodPost, "/v1/books", strings.NewReader(body)))
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("want status code %d; got %d", http.StatusConflict, rr.Code)
+	}
+
+	var resp map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp["error"]; !ok {
+		t.Errorf("expected 'error' field in response, got: %#v", resp)
+	}
+}
+
+func TestCreateBookHandler_IdempotencyKey(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+	body := `{"title": "Retried", "author": "Gary Clarke", "year": 2024}`
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := newJSONRequest(http.MethodPost, "/v1/books", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Step 1: The first request creates the book
+	first := post("key-1", body)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("want status code %d; got %d", http.StatusCreated, first.Code)
+	}
+
+	// Step 2: A retry (even with different spacing) gets the same response,
+	// rather than a 409 for the duplicate
+	second := post("key-1", `{"title":"Retried","author":"Gary Clarke","year":2024}`)
+	if second.Code != http.StatusCreated {
+		t.Fatalf("want status code %d; got %d", http.StatusCreated, second.Code)
+	}
+	if got := second.Header().Get("Idempotent-Replayed"); got != "true" {
+		t.Errorf("want Idempotent-Replayed: true; got %q", got)
+	}
+	if first.Body.String() != second.Body.String() {
+		t.Errorf("want the same body again\nfirst:  %s\nsecond: %s", first.Body, second.Body)
+	}
+
+	// ...and only one book was created
+	count, err := app.Stores.Books.Count(t.Context(), data.BookFilters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := len(testBooks) + 1; count != want {
+		t.Errorf("want %d books; got %d", want, count)
+	}
+
+	// Step 3: The same key with a different book is a 422
+	rr := post("key-1", `{"title": "Something Else", "author": "Gary Clarke"}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("want status code %d; got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+
+	// Step 4: An over-long key is rejected
+	rr = post(strings.Repeat("k", maxIdempotencyKeyLength+1), body)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("want status code %d for a long key; got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+}
+
+func TestUpsertBookByISBNHandler(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+
+	put := func(isbn, body string) (*httptest.ResponseRecorder, data.Book) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, newJSONRequest(http.MethodPut, "/v1/books/by-isbn/"+isbn, strings.NewReader(body)))
+
+		var book data.Book
+		if rr.Code == http.StatusOK || rr.Code == http.StatusCreated {
+			if err := json.NewDecoder(rr.Body).Decode(&book); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr, book
+	}
+
+	// Step 1: The first PUT creates the book
+	rr, created := put("978-0-13-419044-0", `{"title": "Go Brain Teasers", "author": "Miki Tebeka", "year": 2021}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("want status code %d; got %d", http.StatusCreated, rr.Code)
+	}
+	if created.ISBN != "9780134190440" {
+		t.Errorf("want isbn stored without hyphens; got %q", created.ISBN)
+	}
+
+	// Step 2: The same ISBN again updates that book in place
+	rr, updated := put("9780134190440", `{"title": "Go Brain Teasers", "author": "Miki Tebeka", "year": 2022}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+	if updated.ID != created.ID || updated.Year == nil || *updated.Year != 2022 {
+		t.Errorf("want book %d updated to 2022; got %+v", created.ID, updated)
+	}
+
+	// Step 3: An invalid ISBN is rejected
+	if rr, _ := put("not-an-isbn", `{"title": "X", "author": "Y", "year": 2000}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("want status code %d; got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+}
+
+func TestShowBookByISBNHandler(t *testing.T) {
+	// setup test: give the first demo book an ISBN
+	app := setupTestApp(t)
+	book := testBooks[0]
+	if _, err := app.Stores.Books.DB.Exec(`UPDATE books SET isbn = '9780134190440' WHERE id = ?`, book.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		isbn       string
+		wantStatus int
+	}{
+		{name: "isbn as stored", isbn: "9780134190440", wantStatus: http.StatusOK},
+		{name: "with hyphens", isbn: "978-0-13-419044-0", wantStatus: http.StatusOK},
+		{name: "with spaces", isbn: "978%200%2013%20419044%200", wantStatus: http.StatusOK},
+		{name: "no such book", isbn: "9781593279288", wantStatus: http.StatusNotFound},
+		{name: "invalid isbn", isbn: "not-an-isbn", wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/isbn/"+tc.isbn, http.NoBody))
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("want status code %d; got %d", tc.wantStatus, rr.Code)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got data.Book
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != book.ID || got.Title != book.Title {
+				t.Errorf("want book %d %q; got %d %q", book.ID, book.Title, got.ID, got.Title)
+			}
+		})
+	}
+
+	// The route sits on a mux of its own (see routes), so check the two
+	// still work together: another method gets a 405 that lists GET...
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/v1/books/isbn/9780134190440", http.NoBody))
+	if rr.Code != http.StatusMethodNotAllowed || !strings.Contains(rr.Header().Get("Allow"), http.MethodGet) {
+		t.Errorf("DELETE: want status code %d allowing GET; got %d (Allow: %q)", http.StatusMethodNotAllowed, rr.Code, rr.Header().Get("Allow"))
+	}
+
+	// ...and the routes under /books/{id}/ are unaffected
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/1/reviews", http.NoBody))
+	if rr.Code != http.StatusOK {
+		t.Errorf("reviews: want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+}
+
+func TestCreateBookHandler_BodyTooLarge(t *testing.T) {
+	// setup test with a tiny body limit
+	app := setupTestApp(t)
+	app.Config.body.maxBytes = 64
+
+	// A valid book, padded with a long title so the body is well over 64 bytes
+	body := fmt.Sprintf(`{"title": %q, "author": "Someone", "year": 2024}`, strings.Repeat("a", 200))
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, newJSONRequest(http.MethodPost, "/v1/books", strings.NewReader(body)))
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("want status code %d; got %d", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+
+	var resp map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp["error"]; !ok {
+		t.Errorf("expected 'error' field in response, got: %#v", resp)
+	}
+}
+
+func TestCoverHandlers(t *testing.T) {
+	// setup test, saving covers in a temporary directory
+	app := setupTestApp(t)
+	app.Config.covers.dir = t.TempDir()
+	router := app.routes()
+
+	// upload sends the given bytes as the "cover" field of a multipart form
+	upload := func(content []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, err := mw.CreateFormFile("cover", "cover.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(content)
+		mw.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/books/1/cover", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Step 1: No cover yet, so GET is a 404
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/1/cover", http.NoBody))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("want status code %d; got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// Step 2: Anything that isn't a PNG or JPEG is rejected
+	if rr := upload([]byte("definitely not an image")); rr.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("want status code %d; got %d", http.StatusUnsupportedMediaType, rr.Code)
+	}
+
+	// Step 3: Upload a PNG (the 8-byte PNG signature is enough to be detected)
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 32)...)
+	if rr := upload(png); rr.Code != http.StatusCreated {
+		t.Fatalf("want status code %d; got %d", http.StatusCreated, rr.Code)
+	}
+
+	// Step 4: Fetch it back with the right Content-Type
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/1/cover", http.NoBody))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("want Content-Type image/png; got %q", ct)
+	}
+	if !bytes.Equal(rr.Body.Bytes(), png) {
+		t.Error("want the same bytes back that we uploaded")
+	}
+
+	// Step 5: Deleting the book removes the file
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/v1/books/1", http.NoBody))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+	if _, err := os.Stat(filepath.Join(app.Config.covers.dir, "1.png")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want the cover file removed; got %v", err)
+	}
+}
+
+func TestListBooksHandler_YearRange(t *testing.T) {
+	// setup test: the seed books are from 2015 and 2017, add one from 2020
+	app := setupTestApp(t)
+	if _, err := app.Stores.Books.Insert(t.Context(), &data.Book{Title: "Newer", Author: "Someone", Year: intPtr(2020)}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		wantIDs []int64
+	}{
+		{"open-ended lower bound", "?year_to=2017", []int64{1, 2}},
+		{"open-ended upper bound", "?year_from=2016", []int64{2, 3}},
+		{"bounded range", "?year_from=2016&year_to=2019", []int64{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids := listBookIDs(t, app, tt.query)
+			if fmt.Sprint(ids) != fmt.Sprint(tt.wantIDs) {
+				t.Errorf("want ids %v; got %v", tt.wantIDs, ids)
+			}
+		})
+	}
+
+	// A backwards range is rejected
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books?year_from=2020&year_to=2010", http.NoBody))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("want status code %d; got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+}
+
+func TestRandomBookHandler(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+
+	// Step 1: With the two seed books, we should always get one of them
+	for i := 0; i < 5; i++ {
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/random", http.NoBody))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+		}
+
+		var book data.Book
+		if err := json.NewDecoder(rr.Body).Decode(&book); err != nil {
+			t.Fatal(err)
+		}
+		if book.ID != 1 && book.ID != 2 {
+			t.Fatalf("want book 1 or 2; got %d", book.ID)
+		}
+	}
+
+	// Step 2: Once every book is deleted there's nothing to pick
+	for _, id := range []int64{1, 2} {
+		if err := app.Stores.Books.Delete(t.Context(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/random", http.NoBody))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("want status code %d; got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestCountBooksHandler(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+
+	tests := []struct {
+		name      string
+		query     string
+		wantCount int
+	}{
+		{"every book", "", 2},
+		{"filtered like the list", "?year_from=2016", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/count"+tt.query, http.NoBody))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+			}
+
+			var resp map[string]int
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp["count"] != tt.wantCount {
+				t.Errorf("want count %d; got %v", tt.wantCount, resp)
+			}
+		})
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/openapi.json", http.NoBody))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+
+	// The document should be valid JSON...
+	var doc struct {
+		OpenAPI    string                    `json:"openapi"`
+		Paths      map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+
+	// ...and look like an OpenAPI 3 description of our books endpoints
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("want an OpenAPI 3 document; got version %q", doc.OpenAPI)
+	}
+	if _, ok := doc.Paths["/v1/books"]["get"]; !ok {
+		t.Error("want GET /v1/books in the paths")
+	}
+
+	// The Book schema comes from the struct's json tags
+	for _, field := range []string{"id", "title", "author", "year", "created_at"} {
+		if _, ok := doc.Components.Schemas["Book"].Properties[field]; !ok {
+			t.Errorf("want %q in the Book schema", field)
+		}
+	}
+	if _, ok := doc.Components.Schemas["Book"].Properties["XMLName"]; ok {
+		t.Error("XMLName shouldn't be in the Book schema")
+	}
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+	router := app.routes()
+
+	// Step 1: Make a request we can look for
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/1", http.NoBody))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+
+	// Step 2: Scrape the metrics
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+
+	// Step 3: The request should be counted under its route pattern, not /v1/books/1
+	body := rr.Body.String()
+	for _, want := range []string{
+		"# TYPE http_requests_total counter",
+		`http_requests_total{method="GET",route="/v1/books/{id}",status="200"}`,
+		`http_request_duration_seconds_count{method="GET",route="/v1/books/{id}",status="200"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want metrics to contain %q; got:\n%s", want, body)
+		}
+	}
+}
+
+func TestLegacyRoutes(t *testing.T) {
+	tests := []struct {
+		name         string
+		legacyRoutes bool
+		wantStatus   int
+	}{
+		{"legacy routes on", true, http.StatusOK},
+		{"legacy routes off", false, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp(t)
+			app.Config.legacyRoutes = tt.legacyRoutes
+
+			// The unversioned path only works when legacy routes are on...
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/books/1", http.NoBody))
+			if rr.Code != tt.wantStatus {
+				t.Errorf("GET /books/1: want status code %d; got %d", tt.wantStatus, rr.Code)
+			}
+
+			// ...but the versioned path always works
+			rr = httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/1", http.NoBody))
+			if rr.Code != http.StatusOK {
+				t.Errorf("GET /v1/books/1: want status code %d; got %d", http.StatusOK, rr.Code)
+			}
+		})
+	}
+}
+
+func TestBackground(t *testing.T) {
+	// setup test
+	app := setupTestApp(t)
+
+	// Step 1: Start a task that takes a little while, and one that panics
+	var finished atomic.Bool
+	app.background(func() {
+		time.Sleep(50 * time.Millisecond)
+		finished.Store(true)
+	})
+	app.background(func() {
+		panic("boom")
+	})
+
+	// Step 2: Waiting should block until the slow task is done, and the
+	// panic should have been recovered rather than crashing the test
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := app.waitForBackground(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !finished.Load() {
+		t.Error("want the background task to have finished before the wait returned")
+	}
+
+	// Step 3: A task that never finishes makes the wait time out
+	block := make(chan struct{})
+	defer close(block)
+	app.background(func() { <-block })
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := app.waitForBackground(ctx); err == nil {
+		t.Error("want an error when background tasks don't finish in time")
+	}
+}
+
+func TestListBooksHandler_LastModified(t *testing.T) {
+	app := setupTestApp(t)
+	routes := app.routes()
+
+	// Step 1: The first request gets the full list and a Last-Modified header
+	req := httptest.NewRequest(http.MethodGet, "/v1/books", nil)
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+	lastModified := rr.Header().Get("Last-Modified")
+	if _, err := http.ParseTime(lastModified); err != nil {
+		t.Fatalf("want a valid Last-Modified header; got %q", lastModified)
+	}
+
+	// Step 2: Asking again with that time gets a 304 and no body
+	req = httptest.NewRequest(http.MethodGet, "/v1/books", nil)
+	req.Header.Set("If-Modified-Since", lastModified)
+	rr = httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Fatalf("want status code %d; got %d", http.StatusNotModified, rr.Code)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("want an empty body; got %q", rr.Body)
+	}
+
+	// Step 3: A time from before the last change gets the list again
+	req = httptest.NewRequest(http.MethodGet, "/v1/books", nil)
+	req.Header.Set("If-Modified-Since", "Mon, 01 Jan 2001 00:00:00 GMT")
+	rr = httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+}
+
+func TestListBooksHandler_PageSizeConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		query        string
+		strict       bool
+		wantStatus   int
+		wantPageSize int
+	}{
+		{name: "configured default", query: "?after_id=0", wantStatus: http.StatusOK, wantPageSize: 1},
+		{name: "within the max", query: "?after_id=0&page_size=2", wantStatus: http.StatusOK, wantPageSize: 2},
+		{name: "clamped to the max", query: "?after_id=0&page_size=50", wantStatus: http.StatusOK, wantPageSize: 2},
+		{name: "rejected in strict mode", query: "?after_id=0&page_size=50", strict: true, wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// setup test: a default of 1 and a max of 2 books per page
+			app := setupTestApp(t)
+			app.Config.pagination.defaultSize = 1
+			app.Config.pagination.maxSize = 2
+			app.Config.pagination.strict = tc.strict
+
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books"+tc.query, http.NoBody))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("want status code %d; got %d", tc.wantStatus, rr.Code)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp bookResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Metadata == nil || resp.Metadata.PageSize != tc.wantPageSize {
+				t.Fatalf("want page_size %d; got %+v", tc.wantPageSize, resp.Metadata)
+			}
+			if len(resp.Books) != tc.wantPageSize {
+				t.Errorf("want %d books; got %d", tc.wantPageSize, len(resp.Books))
+			}
+		})
+	}
+}
+
+func TestListBooksHandler_IDs(t *testing.T) {
+	// setup test: a deleted third book shouldn't come back either
+	app := setupTestApp(t)
+	third, err := app.Stores.Books.Insert(t.Context(), &data.Book{Title: "Third", Author: "Someone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Stores.Books.Delete(t.Context(), third.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tooMany := strings.TrimSuffix(strings.Repeat("1,", maxBookIDs+1), ",")
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []int64
+	}{
+		{name: "some ids", query: "?ids=2,1", wantStatus: http.StatusOK, wantIDs: []int64{1, 2}},
+		{name: "missing and deleted ids are left out", query: "?ids=1,3,999", wantStatus: http.StatusOK, wantIDs: []int64{1}},
+		{name: "invalid id", query: "?ids=1,abc", wantStatus: http.StatusUnprocessableEntity},
+		{name: "too many ids", query: "?ids=" + tooMany, wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books"+tc.query, http.NoBody))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("want status code %d; got %d", tc.wantStatus, rr.Code)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp bookResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			var got []int64
+			for _, b := range resp.Books {
+				got = append(got, b.ID)
+			}
+			if !slices.Equal(got, tc.wantIDs) {
+				t.Errorf("want books %v; got %v", tc.wantIDs, got)
+			}
+		})
+	}
+}
+
+func TestCacheControlAndContentLength(t *testing.T) {
+	app := setupTestApp(t)
+	app.Config.cache.maxAge = 30 * time.Second
+
+	tests := []struct {
+		name      string
+		url       string
+		wantCache string
+	}{
+		{name: "books list", url: "/v1/books", wantCache: "max-age=30"},
+		{name: "single book", url: "/v1/books/1", wantCache: "max-age=30"},
+		{name: "random book", url: "/v1/books/random", wantCache: "no-store"},
+		{name: "health check", url: "/healthz", wantCache: "no-store"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.url, http.NoBody))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+			}
+
+			if got := rr.Header().Get("Cache-Control"); got != tc.wantCache {
+				t.Errorf("want Cache-Control %q; got %q", tc.wantCache, got)
+			}
+			if got, want := rr.Header().Get("Content-Length"), strconv.Itoa(rr.Body.Len()); got != want {
+				t.Errorf("want Content-Length %s; got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestHandleStoreError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{name: "not found", err: data.ErrRecordNotFound, wantStatus: http.StatusNotFound},
+		{name: "wrapped not found", err: fmt.Errorf("get book: %w", data.ErrRecordNotFound), wantStatus: http.StatusNotFound},
+		{name: "duplicate book", err: data.ErrDuplicateBook, wantStatus: http.StatusConflict},
+		{name: "edit conflict", err: data.ErrEditConflict, wantStatus: http.StatusConflict},
+		{name: "query timed out", err: fmt.Errorf("get book: %w", context.DeadlineExceeded), wantStatus: http.StatusServiceUnavailable},
+		{name: "read-only database", err: fmt.Errorf("%w: connection refused", data.ErrReadOnly), wantStatus: http.StatusServiceUnavailable},
+		{name: "anything else", err: errors.New("connection refused"), wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := setupTestApp(t)
+
+			rr := httptest.NewRecorder()
+			app.handleStoreError(rr, httptest.NewRequest(http.MethodGet, "/v1/books/1", http.NoBody), tc.err)
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("want status code %d; got %d", tc.wantStatus, rr.Code)
+			}
+			// The details of an unexpected error stay in our logs
+			if strings.Contains(rr.Body.String(), "connection refused") {
+				t.Errorf("want the error hidden from the client; got %q", rr.Body)
+			}
+		})
+	}
+}
+
+func TestHandleStoreError_ClientGone(t *testing.T) {
+	app := setupTestApp(t)
+	var logs bytes.Buffer
+	app.Logger = slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	// A client that has already hung up: its request context is cancelled
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/v1/books/1", http.NoBody)
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	// Nothing is written for nobody to read, and it isn't logged as our error
+	if rr.Body.Len() > 0 {
+		t.Errorf("want no response body; got %q", rr.Body)
+	}
+	if strings.Contains(logs.String(), "level=ERROR") {
+		t.Errorf("want no error logged; got:\n%s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "query cancelled") {
+		t.Errorf("want the cancellation logged at debug level; got:\n%s", logs.String())
+	}
+}
+
+func TestStoreUnavailable(t *testing.T) {
+	app := setupTestApp(t)
+
+	// Lose the database out from under the app
+	app.Stores.Books.DB.Close()
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books", http.NoBody))
+
+	// 503 (not 500) tells the client it's worth trying again shortly
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("want status code %d; got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("want a Retry-After header")
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		pretty bool
+		want   string
+	}{
+		{name: "compact by default", pretty: false, want: `{"count":2}`},
+		{name: "indented with -pretty-json", pretty: true, want: "{\n  \"count\": 2\n}\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := setupTestApp(t)
+			app.Config.prettyJSON = tc.pretty
+
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/count", http.NoBody))
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+			}
+			if got := rr.Body.String(); got != tc.want {
+				t.Errorf("want body %q; got %q", tc.want, got)
+			}
+			if got := rr.Header().Get("Content-Type"); got != "application/json; charset=utf-8" {
+				t.Errorf("want Content-Type application/json; charset=utf-8; got %q", got)
+			}
+			if got, want := rr.Header().Get("Content-Length"), strconv.Itoa(len(tc.want)); got != want {
+				t.Errorf("want Content-Length %s; got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestShowBookHandler_OmitsEmptyFields(t *testing.T) {
+	// setup test: a book with no author and no year. The API can't create
+	// one, but older rows in the database can look like this.
+	app := setupTestApp(t)
+	book, err := app.Stores.Books.Insert(t.Context(), &data.Book{Title: "Anonymous"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		wantKeys []string
+	}{
+		{name: "full book", query: "", wantKeys: []string{"id", "title"}},
+		{name: "selected fields", query: "?fields=id,author,year", wantKeys: []string{"id"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/v1/books/%d%s", book.ID, tc.query), http.NoBody))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+			}
+
+			var body map[string]any
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range []string{"author", "year"} {
+				if v, ok := body[key]; ok {
+					t.Errorf("want no %q key; got %v", key, v)
+				}
+			}
+			for _, key := range tc.wantKeys {
+				if _, ok := body[key]; !ok {
+					t.Errorf("want a %q key; got %v", key, body)
+				}
+			}
+		})
+	}
+}
+
+func TestBookStatsHandler(t *testing.T) {
+	// setup test: the two demo books are from 2015 and 2017
+	app := setupTestApp(t)
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/stats", http.NoBody))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+
+	var got map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"total":            2.0,
+		"earliest_year":    2015.0,
+		"latest_year":      2017.0,
+		"average_year":     2016.0,
+		"distinct_authors": 2.0,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v; got %v", want, got)
+	}
+}
+
+func TestHeadBookHandler(t *testing.T) {
+	app := setupTestApp(t)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{name: "existing book", path: "/v1/books/1", wantStatus: http.StatusOK},
+		{name: "missing book", path: "/v1/books/999", wantStatus: http.StatusNotFound},
+		{name: "invalid id", path: "/v1/books/abc", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodHead, tc.path, http.NoBody))
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("want status code %d; got %d", tc.wantStatus, rr.Code)
+			}
+			if tc.wantStatus == http.StatusOK {
+				if rr.Body.Len() != 0 {
+					t.Errorf("want an empty body; got %q", rr.Body.String())
+				}
+				if rr.Header().Get("ETag") == "" {
+					t.Error("want an ETag header")
+				}
+			}
+		})
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	app := setupTestApp(t)
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		wantAllow string
+	}{
+		{name: "post to a book", method: http.MethodPost, path: "/v1/books/1", wantAllow: "GET, HEAD, PUT, DELETE"},
+		{name: "patch the list", method: http.MethodPatch, path: "/v1/books", wantAllow: "GET, HEAD, POST, DELETE"},
+		{name: "post to the spec", method: http.MethodPost, path: "/openapi.json", wantAllow: "GET, HEAD"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, http.NoBody))
+
+			if rr.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("want status code %d; got %d", http.StatusMethodNotAllowed, rr.Code)
+			}
+			if got := rr.Header().Get("Allow"); got != tc.wantAllow {
+				t.Errorf("want Allow %q; got %q", tc.wantAllow, got)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+				t.Errorf("want Content-Type application/json; charset=utf-8; got %q", ct)
+			}
+
+			var body map[string]string
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if want := "the " + tc.method + " method is not allowed for this resource"; body["error"] != want {
+				t.Errorf("want error %q; got %q", want, body["error"])
+			}
+		})
+	}
+}
+
+func TestNotFoundResponse(t *testing.T) {
+	app := setupTestApp(t)
+
+	// An unknown path and a missing book both get the same JSON 404
+	for _, path := range []string{"/v1/nowhere", "/v1/books/999"} {
+		t.Run(path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+
+			if rr.Code != http.StatusNotFound {
+				t.Fatalf("want status code %d; got %d", http.StatusNotFound, rr.Code)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+				t.Errorf("want Content-Type application/json; charset=utf-8; got %q", ct)
+			}
+
+			var body map[string]string
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if want := "the requested resource could not be found"; body["error"] != want {
+				t.Errorf("want error %q; got %q", want, body["error"])
+			}
+		})
+	}
+}
+
+func TestReadJSON_ContentType(t *testing.T) {
+	app := setupTestApp(t)
+	body := `{"title": "Typed", "author": "Gary Clarke"}`
+
+	tests := []struct {
+		name        string
+		contentType string
+		wantStatus  int
+	}{
+		{name: "json", contentType: "application/json", wantStatus: http.StatusCreated},
+		{name: "json with a charset", contentType: "application/json; charset=utf-8", wantStatus: http.StatusCreated},
+		{name: "plain text", contentType: "text/plain", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "missing", contentType: "", wantStatus: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Each successful create needs a different book, or it's a 409
+			req := httptest.NewRequest(http.MethodPost, "/v1/books", strings.NewReader(strings.Replace(body, "Typed", "Typed "+tc.name, 1)))
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("want status code %d; got %d: %s", tc.wantStatus, rr.Code, rr.Body.String())
+			}
+			if tc.wantStatus == http.StatusUnsupportedMediaType {
+				var resp map[string]string
+				if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp["error"] != errNotJSON.Error() {
+					t.Errorf("want error %q; got %q", errNotJSON.Error(), resp["error"])
+				}
+			}
+		})
+	}
+}
+
+func TestCreateBooksBatchHandler(t *testing.T) {
+	app := setupTestApp(t)
+
+	// Step 1: One valid and one invalid book: nothing is created, and the
+	// error points at the second element
+	body := `[{"title": "Valid", "author": "Gary Clarke"}, {"title": "Invalid", "author": "Gary Clarke", "year": -1}]`
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, newJSONRequest(http.MethodPost, "/v1/books/batch", strings.NewReader(body)))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("want status code %d; got %d: %s", http.StatusUnprocessableEntity, rr.Code, rr.Body.String())
+	}
+
+	var resp struct {
+		Errors []batchItemError `json:"errors"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []batchItemError{{Index: 1, Fields: map[string]string{"year": "year must be a positive integer"}}}
+	if !reflect.DeepEqual(resp.Errors, want) {
+		t.Errorf("want errors %+v; got %+v", want, resp.Errors)
+	}
+	if count, _ := app.Stores.Books.Count(t.Context(), data.BookFilters{}); count != 2 {
+		t.Errorf("want only the 2 demo books after a failed batch; got %d", count)
+	}
+
+	// Step 2: Fix the invalid book and both are created
+	body = `[{"title": "Valid", "author": "Gary Clarke"}, {"title": "Fixed", "author": "Gary Clarke", "year": 2024}]`
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, newJSONRequest(http.MethodPost, "/v1/books/batch", strings.NewReader(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("want status code %d; got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+
+	var created bookResponse
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if len(created.Books) != 2 || created.Books[0].ID == 0 || created.Books[1].Title != "Fixed" {
+		t.Errorf("want the two new books; got %+v", created.Books)
+	}
+
+	// Step 3: An empty batch is rejected
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, newJSONRequest(http.MethodPost, "/v1/books/batch", strings.NewReader(`[]`)))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("empty batch: want status code %d; got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+}
+
+func TestResponseTimeHeader(t *testing.T) {
+	app := setupTestApp(t)
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books", http.NoBody))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+	header := rr.Header().Get("X-Response-Time")
+	ms, err := strconv.ParseFloat(header, 64)
+	if err != nil || ms < 0 {
+		t.Errorf("want X-Response-Time to be a number of milliseconds; got %q", header)
+	}
+}
+
+func TestChain(t *testing.T) {
+	// Each middleware notes when the request reaches it, and when the
+	// response comes back through it
+	var calls []string
+	record := func(name string) middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name+" in")
+				next.ServeHTTP(w, r)
+				calls = append(calls, name+" out")
+			})
+		}
+	}
+	h := func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	}
+
+	chain(h, record("first"), record("second"), record("third")).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+
+	// The first listed is the outermost
+	want := []string{"first in", "second in", "third in", "handler", "third out", "second out", "first out"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("want calls %q; got %q", want, calls)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	// A handler that's slower than the timeout, and reports whether its
+	// request context was cancelled (as a slow query's would be)
+	cancelled := make(chan bool, 1)
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			cancelled <- true
+		case <-time.After(time.Second):
+			cancelled <- false
+			w.Write([]byte("too late"))
+		}
+	})
+
+	rr := httptest.NewRecorder()
+	timeout(20*time.Millisecond, "application/json; charset=utf-8")(slow).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books", http.NoBody))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("want status code %d; got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("want Content-Type application/json; charset=utf-8; got %q", ct)
+	}
+	if rr.Body.String() != timeoutMessage {
+		t.Errorf("want body %q; got %q", timeoutMessage, rr.Body.String())
+	}
+	if !<-cancelled {
+		t.Error("want the slow handler's context to be cancelled")
+	}
+
+	// Fast handlers are unaffected, and 0 switches the timeout off
+	for _, d := range []time.Duration{time.Second, 0} {
+		rr := httptest.NewRecorder()
+		app := setupTestApp(t)
+		app.Config.server.requestTimeout = d
+		app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/1", http.NoBody))
+		if rr.Code != http.StatusOK {
+			t.Errorf("timeout %v: want status code %d; got %d", d, http.StatusOK, rr.Code)
+		}
+	}
+}
+
+func TestRecoverPanic(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       string
+		wantStack bool
+	}{
+		{name: "development", env: "development", wantStack: true},
+		{name: "production", env: "production", wantStack: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := setupTestApp(t)
+			app.Config.env = tt.env
+			var logs bytes.Buffer
+			app.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+			// A handler with a bug in it
+			h := func(w http.ResponseWriter, r *http.Request) {
+				panic("something went badly wrong")
+			}
+
+			rr := httptest.NewRecorder()
+			chain(h, app.recoverPanic).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+
+			// The client gets a clean 500, with none of the details
+			if rr.Code != http.StatusInternalServerError {
+				t.Errorf("want status code %d; got %d", http.StatusInternalServerError, rr.Code)
+			}
+			if got := rr.Header().Get("Connection"); got != "close" {
+				t.Errorf("want Connection: close; got %q", got)
+			}
+			for _, leak := range []string{"something went badly wrong", "goroutine", "TestRecoverPanic"} {
+				if strings.Contains(rr.Body.String(), leak) {
+					t.Errorf("want %q kept out of the response; got %q", leak, rr.Body)
+				}
+			}
+
+			// The panic is always logged, the stack only outside production
+			if !strings.Contains(logs.String(), "something went badly wrong") {
+				t.Errorf("want the panic logged; got:\n%s", logs.String())
+			}
+			gotStack := strings.Contains(logs.String(), "stack=") && strings.Contains(logs.String(), "TestRecoverPanic")
+			if gotStack != tt.wantStack {
+				t.Errorf("want stack logged %v; got %v:\n%s", tt.wantStack, gotStack, logs.String())
+			}
+		})
+	}
+}
+
+func TestPanicStack(t *testing.T) {
+	if got := panicStack([]byte("short"), 10); got != "short" {
+		t.Errorf("want a short stack unchanged; got %q", got)
+	}
+
+	got := panicStack([]byte(strings.Repeat("x", 100)), 10)
+	if want := strings.Repeat("x", 10) + "\n... (truncated)"; got != want {
+		t.Errorf("want %q; got %q", want, got)
+	}
+}
+
+// countingWriter counts the writes and flushes that reach it, so a test can
+// tell a streamed response from one that was held back and sent in one go.
+type countingWriter struct {
+	*httptest.ResponseRecorder
+	writes, flushes int
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	cw.writes++
+	return cw.ResponseRecorder.Write(b)
+}
+
+func (cw *countingWriter) Flush() {
+	cw.flushes++
+	cw.ResponseRecorder.Flush()
+}
+
+func TestTimeout_StreamedResponsesExempt(t *testing.T) {
+	app := setupTestApp(t)
+	app.Config.server.requestTimeout = 5 * time.Second
+	routes := app.routes()
+
+	// The CSV export writes each of the two books as it goes (the header
+	// goes with the first), rather than all at once at the end
+	cw := &countingWriter{ResponseRecorder: httptest.NewRecorder()}
+	routes.ServeHTTP(cw, httptest.NewRequest(http.MethodGet, "/v1/books/export", http.NoBody))
+	if cw.Code != http.StatusOK {
+		t.Fatalf("export: want status code %d; got %d", http.StatusOK, cw.Code)
+	}
+	if cw.writes < 2 {
+		t.Errorf("export: want a write per book; got %d writes", cw.writes)
+	}
+
+	// NDJSON flushes after each book
+	cw = &countingWriter{ResponseRecorder: httptest.NewRecorder()}
+	routes.ServeHTTP(cw, httptest.NewRequest(http.MethodGet, "/v1/books?format=ndjson", http.NoBody))
+	if cw.Code != http.StatusOK {
+		t.Fatalf("ndjson: want status code %d; got %d", http.StatusOK, cw.Code)
+	}
+	if cw.flushes < 2 {
+		t.Errorf("ndjson: want a flush per book; got %d flushes", cw.flushes)
+	}
+}
+
+func TestListAuthorsHandler(t *testing.T) {
+	// setup test: the demo books are by Alan Donovan and Martin Kleppmann,
+	// and we add a second book by Alan Donovan
+	app := setupTestApp(t)
+	if _, err := app.Stores.Books.Insert(t.Context(), &data.Book{Title: "Another", Author: "Alan Donovan"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantAuthors []string
+		wantNext    string
+	}{
+		{name: "all authors, once each", query: "", wantStatus: http.StatusOK, wantAuthors: []string{"Alan Donovan", "Martin Kleppmann"}},
+		{name: "first page", query: "?after=&page_size=1", wantStatus: http.StatusOK, wantAuthors: []string{"Alan Donovan"}, wantNext: "Alan Donovan"},
+		{name: "last page", query: "?after=Alan+Donovan&page_size=1", wantStatus: http.StatusOK, wantAuthors: []string{"Martin Kleppmann"}},
+		{name: "invalid page size", query: "?after=&page_size=0", wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/authors"+tc.query, http.NoBody))
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("want status code %d; got %d", tc.wantStatus, rr.Code)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Authors  []string        `json:"authors"`
+				Metadata authorsMetadata `json:"metadata"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resp.Authors, tc.wantAuthors) {
+				t.Errorf("want authors %q; got %q", tc.wantAuthors, resp.Authors)
+			}
+			if resp.Metadata.NextCursor != tc.wantNext {
+				t.Errorf("want next cursor %q; got %q", tc.wantNext, resp.Metadata.NextCursor)
+			}
+		})
+	}
+}
+
+func TestBookFacetsHandler(t *testing.T) {
+	// setup test: add a genre to a new book from one of the demo years
+	app := setupTestApp(t)
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, newJSONRequest(http.MethodPost, "/v1/books",
+		strings.NewReader(`{"title": "Faceted", "author": "Gary Clarke", "year": 2015, "genre": "Programming"}`)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("want status code %d; got %d", http.StatusCreated, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/facets", http.NoBody))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+
+	var got data.BookFacets
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := data.BookFacets{
+		ByYear:  map[string]int{"2015": 2, "2017": 1},
+		ByGenre: map[string]int{"Programming": 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %+v; got %+v", want, got)
+	}
+}
+
+func TestBooksByDecadeHandler(t *testing.T) {
+	// setup test: add a book without a year next to the two demo books
+	app := setupTestApp(t)
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, newJSONRequest(http.MethodPost, "/v1/books",
+		strings.NewReader(`{"title": "Undated", "author": "Gary Clarke"}`)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("want status code %d; got %d", http.StatusCreated, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/by-decade", http.NoBody))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+
+	var got map[string][]data.Book
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Errorf("want the 2010s and unknown; got %v", got)
+	}
+	if books := got["2010s"]; len(books) != 2 || books[0].Title != testBooks[0].Title || books[1].Title != testBooks[1].Title {
+		t.Errorf("want both demo books in the 2010s; got %v", books)
+	}
+	if books := got["unknown"]; len(books) != 1 || books[0].Title != "Undated" {
+		t.Errorf("want the undated book in unknown; got %v", books)
+	}
+}
+
+func TestRelatedBooksHandler(t *testing.T) {
+	// setup test: a second book by the author of book 1, and a book with
+	// nothing in common with the others
+	app := setupTestApp(t)
+	for _, body := range []string{
+		`{"title": "The Go Workshop", "author": "Alan Donovan"}`,
+		`{"title": "Undated", "author": "Gary Clarke"}`,
+	} {
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, newJSONRequest(http.MethodPost, "/v1/books", strings.NewReader(body)))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("want status code %d; got %d", http.StatusCreated, rr.Code)
+		}
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, http.NoBody))
+		return rr
+	}
+
+	// Step 1: The same author's book comes before the one from the same decade
+	rr := get("/v1/books/1/related")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+	var resp bookResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, b := range resp.Books {
+		titles = append(titles, b.Title)
+	}
+	want := []string{"The Go Workshop", testBooks[1].Title}
+	if !slices.Equal(titles, want) {
+		t.Errorf("want related books %q; got %q", want, titles)
+	}
+
+	// Step 2: Nothing related is an empty list, not a 404
+	rr = get("/v1/books/4/related")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+	if body := strings.TrimSpace(rr.Body.String()); body != `{"books":[]}` {
+		t.Errorf("want an empty list; got %s", body)
+	}
+
+	// Step 3: An unknown book is a 404
+	if rr = get("/v1/books/999/related"); rr.Code != http.StatusNotFound {
+		t.Errorf("want status code %d; got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestShowBookHandler_CountsViews(t *testing.T) {
+	app := setupTestApp(t)
+
+	// Step 1: Show book 1 twice. The count is updated in the background, so
+	// we wait for that to finish before looking.
+	var etags []string
+	for range 2 {
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/1", http.NoBody))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+		}
+		etags = append(etags, rr.Header().Get("ETag"))
+		app.wg.Wait()
+	}
+
+	// Step 2: Both views were counted
+	book, err := app.Stores.Books.Get(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Views != 2 {
+		t.Errorf("want 2 views; got %d", book.Views)
+	}
+
+	// Step 3: Viewing the book doesn't change its ETag
+	if etags[0] != etags[1] {
+		t.Errorf("want the same ETag after a view; got %s then %s", etags[0], etags[1])
+	}
+
+	// Step 4: The list can be sorted by views, but not while paging by ID
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books?sort=views&after_id=0", http.NoBody))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("want status code %d; got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+}
+
+func TestListBooksHandler_SortByViewsIgnoresIfModifiedSince(t *testing.T) {
+	app := setupTestApp(t)
+	routes := app.routes()
+
+	// Step 1: Note when the catalog last changed
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books", http.NoBody))
+	lastModified := rr.Header().Get("Last-Modified")
+	if lastModified == "" {
+		t.Fatal("want a Last-Modified header on GET /v1/books")
+	}
+
+	// Step 2: View book 2, which moves it to the top of the ranking without
+	// changing any book
+	rr = httptest.NewRecorder()
+	routes.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/books/2", http.NoBody))
+	app.wg.Wait()
+
+	// Step 3: The sorted list is sent again, with the new order, rather than a 304
+	req := httptest.NewRequest(http.MethodGet, "/v1/books?sort=views", http.NoBody)
+	req.Header.Set("If-Modified-Since", lastModified)
+	rr = httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status code %d; got %d", http.StatusOK, rr.Code)
+	}
+	if got := rr.Header().Get("Last-Modified"); got != "" {
+		t.Errorf("want no Last-Modified when sorting by views; got %q", got)
+	}
+	var resp bookResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Books) == 0 || resp.Books[0].ID != 2 {
+		t.Errorf("want the viewed book 2 first; got %v", resp.Books)
+	}
+}
+
+func TestBookEventsHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{name: "no request timeout", timeout: 0},
+		// The stream isn't cut off by -request-timeout, however long it stays open
+		{name: "with a request timeout", timeout: 100 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A real server this time: httptest.NewRecorder can't stream
+			app := setupTestApp(t)
+			app.Config.server.requestTimeout = tt.timeout
+			srv := httptest.NewServer(app.routes())
+			t.Cleanup(srv.Close)
+
+			// Step 1: Subscribe. The headers arrive once the subscription is registered.
+			ctx, cancel := context.WithCancel(t.Context())
+			defer cancel() // disconnecting ends the stream on the server
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/v1/books/events", http.NoBody)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Fatalf("want Content-Type text/event-stream; got %q", ct)
+			}
+
+			// Step 2: Wait until any timeout has passed, then create a book
+			time.Sleep(2 * tt.timeout)
+			created, err := srv.Client().Post(srv.URL+"/v1/books", "application/json",
+				strings.NewReader(`{"title": "Live", "author": "Gary Clarke"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			created.Body.Close()
+			if created.StatusCode != http.StatusCreated {
+				t.Fatalf("want status code %d; got %d", http.StatusCreated, created.StatusCode)
+			}
+
+			// Step 3: Read the event for it
+			line, err := bufio.NewReader(resp.Body).ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload, ok := strings.CutPrefix(strings.TrimSpace(line), "data: ")
+			if !ok {
+				t.Fatalf("want a data: line; got %q", line)
+			}
+			var book data.Book
+			if err := json.Unmarshal([]byte(payload), &book); err != nil {
+				t.Fatal(err)
+			}
+			if book.Title != "Live" || book.ID == 0 {
+				t.Errorf("want the new book; got %+v", book)
+			}
+		})
+	}
+}
+
+func TestBookBroker_Close(t *testing.T) {
+	var b bookBroker
+	ch := b.subscribe()
+	b.close()
+
+	if _, ok := <-ch; ok {
+		t.Error("want the subscriber's channel closed")
+	}
+	if _, ok := <-b.subscribe(); ok {
+		t.Error("want subscribing after close to give a closed channel")
+	}
+	b.publish(data.Book{Title: "Nobody listening"}) // mustn't panic
+}
@@ -0,0 +1,99 @@
+// File: cmd/api/idempotency.go
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/garyclarke/first-go-app/internal/data"
+	"github.com/garyclarke/first-go-app/internal/request"
+)
+
+// maxIdempotencyKeyLength caps the Idempotency-Key header. Clients usually
+// send a UUID; anything much longer is more likely a mistake than a key.
+const maxIdempotencyKeyLength = 255
+
+// Idempotency keys make it safe to retry POST /books.
+//
+// If a create times out, the client can't tell whether the book was saved.
+// Sending the request again might make a second copy. With an
+// Idempotency-Key header (any unique string, like a UUID), we remember the
+// response to the first request for -idempotency-ttl, and answer a retry
+// with the same response instead of creating the book again. Replayed
+// responses carry an Idempotent-Replayed: true header.
+//
+// A key belongs to the request it was first sent with. Reusing it for a
+// different book is a 422, since silently returning the other book would
+// hide the client's bug.
+
+// checkIdempotencyKey looks up key for a create request. If we've already
+// answered it, the saved response is sent and done is true. Otherwise the
+// caller goes ahead and passes hash to saveIdempotentBook afterwards.
+func (app *App) checkIdempotencyKey(w http.ResponseWriter, r *http.Request, key string, br request.FullBookRequest) (hash string, done bool) {
+	if len(key) > maxIdempotencyKeyLength {
+		app.failedValidationResponse(w, r, map[string]string{
+			"Idempotency-Key": "must not be more than 255 characters long",
+		})
+		return "", true
+	}
+
+	// We fingerprint the decoded request rather than the raw body, so a
+	// retry with different whitespace or key order still counts as the same.
+	b, err := json.Marshal(br)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return "", true
+	}
+	sum := sha256.Sum256(b)
+	hash = hex.EncodeToString(sum[:])
+
+	saved, err := app.Stores.Idempotency.Get(r.Context(), key)
+	if errors.Is(err, data.ErrRecordNotFound) {
+		return hash, false
+	}
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return "", true
+	}
+
+	if saved.RequestHash != hash {
+		app.idempotencyKeyReusedResponse(w, r)
+		return "", true
+	}
+
+	// Send the saved book again. Going through writeCreatedBook means the
+	// replay still honours this request's Accept and Prefer headers.
+	var book data.Book
+	if err := json.Unmarshal(saved.Body, &book); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return "", true
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	if err := app.writeCreatedBook(w, r, saved.Status, &book); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+	return "", true
+}
+
+// saveIdempotentBook remembers the response to a create under key.
+//
+// The book has already been saved by now, so if we can't remember the key
+// we only log it: the client still gets its 201, and a retry would at worst
+// get a 409 for the duplicate book.
+func (app *App) saveIdempotentBook(r *http.Request, key, hash string, status int, book *data.Book) {
+	body, err := json.Marshal(book)
+	if err == nil {
+		err = app.Stores.Idempotency.Save(r.Context(), &data.IdempotentResponse{
+			Key:         key,
+			RequestHash: hash,
+			Status:      status,
+			Body:        body,
+		})
+	}
+	if err != nil {
+		app.requestLogger(r).Error("failed to save idempotency key", "error", err)
+	}
+}
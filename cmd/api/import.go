@@ -0,0 +1,186 @@
+// File: cmd/api/import.go
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/garyclarke/first-go-app/internal/data"
+	"github.com/garyclarke/first-go-app/internal/request"
+)
+
+// maxImportBytes caps the size of an uploaded import file (5MB).
+const maxImportBytes = 5 << 20
+
+// importRowError describes why one row of an import was skipped.
+// Row numbers start at 1 and don't count the CSV header row.
+type importRowError struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+// importResponse summarises what happened to an import. For a dry run,
+// Imported is how many books would have been imported.
+type importResponse struct {
+	Imported int              `json:"imported"`
+	Skipped  int              `json:"skipped"`
+	Errors   []importRowError `json:"errors"`
+	DryRun   bool             `json:"dry_run,omitempty"`
+}
+
+// importBooksHandler adds many books at once from an uploaded file.
+//
+// The body can be either CSV (Content-Type: text/csv) with a header row naming
+// the title, author and year columns, or a JSON array of books
+// (Content-Type: application/json). Every row is validated; the valid ones are
+// inserted together in one transaction and the invalid ones are reported back.
+//
+// With ?dry_run=true nothing is saved: the rows are validated and inserted as
+// usual, duplicates included, but the transaction is rolled back at the end.
+// The summary shows what a real import would do, so it can be checked first.
+func (app *App) importBooksHandler(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Cap the upload size so a huge file can't exhaust memory.
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	// Step 2: Parse the body into FullBookRequest values, based on its Content-Type.
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var rows []request.FullBookRequest
+	var err error
+	switch mediaType {
+	case "text/csv":
+		rows, err = parseCSVImport(r.Body)
+	case "application/json":
+		err = json.NewDecoder(r.Body).Decode(&rows)
+	default:
+		app.errorResponse(w, r, http.StatusUnsupportedMediaType, "import must be text/csv or application/json")
+		return
+	}
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("import must not be larger than %d bytes", maxBytesErr.Limit))
+			return
+		}
+		app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Step 3: Validate every row, keeping the valid ones as books to insert.
+	resp := importResponse{Errors: []importRowError{}}
+	var books []*data.Book
+	var bookRows []int // the row number each book in books came from
+	for i := range rows {
+		if validationErrors := request.ValidateFullBookRequest(&rows[i]); len(validationErrors) > 0 {
+			resp.Errors = append(resp.Errors, importRowError{Row: i + 1, Errors: validationErrors})
+			continue
+		}
+		book := rows[i].Book()
+		books = append(books, &book)
+		bookRows = append(bookRows, i+1)
+	}
+
+	// Step 4: Insert the valid books in a single transaction (or just try it,
+	// for a dry run).
+	resp.DryRun, _ = strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	insert := app.Stores.Books.InsertMany
+	if resp.DryRun {
+		insert = app.Stores.Books.PreviewInsertMany
+	}
+	imported, err := insert(r.Context(), books)
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	// Books left with ID 0 were already in the catalog, so they were skipped too.
+	for i, b := range books {
+		if b.ID == 0 {
+			resp.Errors = append(resp.Errors, importRowError{
+				Row:    bookRows[i],
+				Errors: map[string]string{"book": "a book with this title and author already exists"},
+			})
+		}
+	}
+
+	// Step 5: Report the summary.
+	resp.Imported = imported
+	resp.Skipped = len(rows) - imported
+	if err := app.writeJSON(w, http.StatusOK, resp); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// parseCSVImport reads CSV rows into FullBookRequest values.
+//
+// The first row must be a header naming the columns. Columns can appear in any
+// order and unknown columns (such as id) are ignored, so a file produced by
+// GET /books/export can be imported straight back in.
+func parseCSVImport(body io.Reader) ([]request.FullBookRequest, error) {
+	cr := csv.NewReader(body)
+	// Allow rows with a different number of fields; missing values just fail validation.
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("csv import is empty")
+		}
+		return nil, err
+	}
+
+	// Remember which position each column we care about is in.
+	columns := map[string]int{"title": -1, "author": -1, "year": -1}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := columns[name]; ok {
+			columns[name] = i
+		}
+	}
+	if columns["title"] < 0 {
+		return nil, errors.New("csv header must include a title column")
+	}
+
+	// field returns the value in the named column, or "" if it's missing.
+	field := func(record []string, name string) string {
+		i := columns[name]
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+
+	var rows []request.FullBookRequest
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		row := request.FullBookRequest{
+			Title:  field(record, "title"),
+			Author: field(record, "author"),
+		}
+
+		// An empty year means it's unknown. A year that isn't a number is
+		// left as 0, which the validator rejects.
+		if y := strings.TrimSpace(field(record, "year")); y != "" {
+			year, _ := strconv.Atoi(y)
+			row.Year = &year
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
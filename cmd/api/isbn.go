@@ -0,0 +1,80 @@
+// File: cmd/api/isbn.go
+package main
+
+import (
+	"net/http"
+
+	"github.com/garyclarke/first-go-app/internal/request"
+)
+
+// showBookByISBNHandler returns the book with the ISBN in the path, for
+// clients that start from a barcode rather than one of our IDs. Hyphens and
+// spaces in the ISBN are ignored. An ISBN that isn't valid is a 422; a valid
+// one we don't have a book for is a 404.
+func (app *App) showBookByISBNHandler(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Validate the ISBN from the route (and strip any hyphens)
+	isbn, ok := request.NormalizeISBN(r.PathValue("isbn"))
+	if !ok {
+		app.failedValidationResponse(w, r, map[string]string{"isbn": "isbn must be a valid ISBN-10 or ISBN-13"})
+		return
+	}
+
+	// Step 2: Look the book up
+	book, err := app.Stores.Books.GetByISBN(r.Context(), isbn)
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	// Step 3: Write the response (JSON, or XML if the client asked for it)
+	if err := app.writeResponse(w, r, http.StatusOK, book); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// upsertBookByISBNHandler creates or replaces the book with the ISBN in the
+// path. It's meant for keeping our catalog in sync with another system that
+// identifies books by ISBN rather than by our IDs.
+//
+// It responds 201 Created when the book is new, and 200 OK when an existing
+// book was updated. Like any PUT, sending the same request twice is safe.
+func (app *App) upsertBookByISBNHandler(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Validate the ISBN from the route (and strip any hyphens)
+	isbn, ok := request.NormalizeISBN(r.PathValue("isbn"))
+	if !ok {
+		app.failedValidationResponse(w, r, map[string]string{"isbn": "isbn must be a valid ISBN-10 or ISBN-13"})
+		return
+	}
+
+	// Step 2: Decode the request body into a FullBookRequest
+	var br request.FullBookRequest
+	if err := app.readJSON(w, r, &br); err != nil {
+		app.readJSONErrorResponse(w, r, err)
+		return
+	}
+
+	// Step 3: Validate the input
+	validationErrors := request.ValidateFullBookRequest(&br)
+	if len(validationErrors) > 0 {
+		app.failedValidationResponse(w, r, validationErrors)
+		return
+	}
+
+	// Step 4: Create or update the book
+	book := br.Book()
+	book.ISBN = isbn
+	book, created, err := app.Stores.Books.UpsertByISBN(r.Context(), book)
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	// Step 5: 201 if we created it, 200 if we updated it
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	if err := app.writeResponse(w, r, status, &book); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
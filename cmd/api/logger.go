@@ -0,0 +1,30 @@
+// File: cmd/api/logger.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// newLogger builds the app's structured logger, writing to w.
+//
+// format is "text" (key=value pairs, easy to read in a terminal) or "json"
+// (one object per line, easy for log collectors to parse). level is the
+// least severe level that gets written: "debug", "info", "warn" or "error".
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q (want text or json)", format)
+	}
+}
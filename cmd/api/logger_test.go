@@ -0,0 +1,48 @@
+// File: cmd/api/logger_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	// At debug level, debug lines are written
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "json", "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("checking the shelves", "count", 3)
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("want a JSON log line; got %q: %v", buf.String(), err)
+	}
+	if line["level"] != "DEBUG" || line["msg"] != "checking the shelves" {
+		t.Errorf("want a DEBUG line saying %q; got %v", "checking the shelves", line)
+	}
+
+	// At the default info level, they're left out
+	buf.Reset()
+	logger, err = newLogger(&buf, "text", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("hidden")
+	logger.Info("shown")
+	if got := buf.String(); strings.Contains(got, "hidden") || !strings.Contains(got, "msg=shown") {
+		t.Errorf("want only the info line; got %q", got)
+	}
+}
+
+func TestNewLogger_Invalid(t *testing.T) {
+	if _, err := newLogger(&bytes.Buffer{}, "yaml", "info"); err == nil {
+		t.Error("want an error for an unknown format")
+	}
+	if _, err := newLogger(&bytes.Buffer{}, "text", "loud"); err == nil {
+		t.Error("want an error for an unknown level")
+	}
+}
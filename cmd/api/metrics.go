@@ -0,0 +1,34 @@
+// File: cmd/api/metrics.go
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"strconv"
+)
+
+// Operational metrics, published with the standard library's expvar package.
+//
+// expvar keeps a registry of named variables and serves them all as one JSON
+// document (see GET /debug/vars). Variables can only be registered once per
+// process, so we create them here at package level rather than per App.
+var (
+	totalRequestsReceived = expvar.NewInt("total_requests_received")
+	totalResponsesSent    = expvar.NewInt("total_responses_sent")
+	// Keyed by status class: "2xx", "4xx", "5xx", ...
+	totalResponsesByStatus = expvar.NewMap("total_responses_sent_by_status")
+)
+
+// metrics counts every request, and every response by its status class.
+func (app *App) metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		totalRequestsReceived.Add(1)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		totalResponsesSent.Add(1)
+		// 404 / 100 = 4, so this turns any status code into "4xx" etc.
+		totalResponsesByStatus.Add(strconv.Itoa(rec.Status()/100)+"xx", 1)
+	})
+}
@@ -0,0 +1,595 @@
+// File: cmd/api/middleware.go
+package main
+
+import (
+	"compress/gzip"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+// Middleware in Go is just a function that takes an http.Handler and returns
+// a new http.Handler. The returned handler can do some work before and/or
+// after calling next.ServeHTTP(), which runs the rest of the chain.
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h in each of mws, so that
+//
+//	chain(h, a, b, c)
+//
+// is the same as a(b(c(h))). The first middleware listed is the outermost:
+// it sees the request first and the response last. Reading the list from
+// left to right follows a request on its way in to h.
+func chain(h http.HandlerFunc, mws ...middleware) http.Handler {
+	var handler http.Handler = h
+	for i := len(mws) - 1; i >= 0; i-- {
+		handler = mws[i](handler)
+	}
+	return handler
+}
+
+// requestID makes sure every request has an ID we can use to tie log lines together.
+//
+// If the client (or a proxy in front of us) already sent an X-Request-ID header
+// we reuse it, so the same ID follows the request across services. Otherwise we
+// generate a new random UUID. Either way the ID is stored in the request context
+// and echoed back in the X-Request-ID response header.
+func (app *App) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, contextSetRequestID(r, id))
+	})
+}
+
+// serverHeader names us in the Server response header, using -server-header.
+//
+// It's just a name, never a version number (see healthcheckHandler for why).
+func (app *App) serverHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name := app.Config.server.header; name != "" {
+			w.Header().Set("Server", name)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID reports whether an incoming request ID is safe to reuse.
+// We only accept short IDs made of printable ASCII, so a client can't stuff
+// huge values or control characters into our logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestLogger returns the app's logger with the current request's ID attached,
+// so every line logged while handling a request can be matched up.
+func (app *App) requestLogger(r *http.Request) *slog.Logger {
+	return app.Logger.With("request_id", requestIDFromContext(r.Context()))
+}
+
+// logRequest logs one line per request once it has been handled, including
+// the status code and how long it took.
+func (app *App) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		app.requestLogger(r).Info("request",
+			"client_ip", realIP(r, app.Config.proxy.trusted),
+			"method", r.Method,
+			"uri", r.URL.RequestURI(),
+			"status", rec.Status(),
+			"duration", time.Since(start),
+		)
+	})
+}
+
+// responseTime adds an X-Response-Time header saying how long, in
+// milliseconds, the rest of the chain took to produce the response, e.g.
+//
+//	X-Response-Time: 12.345
+//
+// Headers have to be sent before the body, so the clock stops when the
+// handler starts its response rather than when it finishes. For streamed
+// responses that's the time to the first byte.
+func (app *App) responseTime(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&responseTimeWriter{ResponseWriter: w, start: time.Now()}, r)
+	})
+}
+
+// responseTimeWriter sets X-Response-Time just before the headers go out.
+type responseTimeWriter struct {
+	http.ResponseWriter
+	start       time.Time
+	wroteHeader bool
+}
+
+func (rw *responseTimeWriter) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.wroteHeader = true
+		ms := float64(time.Since(rw.start)) / float64(time.Millisecond)
+		rw.Header().Set("X-Response-Time", strconv.FormatFloat(ms, 'f', 3, 64))
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseTimeWriter) Write(b []byte) (int, error) {
+	// Writing without calling WriteHeader first means an implicit 200 OK.
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// Unwrap gives http.ResponseController access to the original ResponseWriter.
+func (rw *responseTimeWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// maxPanicStackBytes caps how much of a panic's stack trace we log. The
+// frames that matter are at the top; deep recursion could otherwise fill the
+// logs with thousands of identical lines.
+const maxPanicStackBytes = 8 << 10
+
+// recoverPanic turns a panic in a handler into a 500 Internal Server Error.
+//
+// Without it, net/http recovers the panic itself, but only logs it to its own
+// error log and drops the connection, so the client gets no response at all
+// and our logs have no request ID to match it up with.
+//
+// We log the panic with the request's logger. Outside production the log
+// line also gets the stack trace (see panicStack), which is usually what
+// you need to find the bug. In production we leave it out, as a stack trace
+// shows a lot about how the server is put together. The client never sees
+// either: it just gets the usual JSON error.
+//
+// Connection: close tells net/http to close the connection once the response
+// is sent, since we can't be sure what state the handler left things in.
+//
+// http.ErrAbortHandler is how a handler deliberately aborts a response, so we
+// let that one carry on up to net/http.
+func (app *App) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			attrs := []any{"panic", fmt.Sprint(p), "method", r.Method, "path", r.URL.Path}
+			if app.Config.env != "production" {
+				attrs = append(attrs, "stack", panicStack(debug.Stack(), maxPanicStackBytes))
+			}
+			app.requestLogger(r).Error("panic recovered", attrs...)
+
+			w.Header().Set("Connection", "close")
+			app.errorResponse(w, r, http.StatusInternalServerError, "the server encountered a problem and could not process your request")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// panicStack returns stack as a string, cut to at most limit bytes (plus a
+// note saying so) if it's longer.
+func panicStack(stack []byte, limit int) string {
+	if len(stack) <= limit {
+		return string(stack)
+	}
+	return string(stack[:limit]) + "\n... (truncated)"
+}
+
+// statusRecorder wraps an http.ResponseWriter and remembers the status code
+// written to it, so middleware can see it after the handler has run.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	// Writing without calling WriteHeader first means an implicit 200 OK.
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Status returns the recorded status code, defaulting to 200 if nothing was written.
+func (rec *statusRecorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// Unwrap gives http.ResponseController access to the original ResponseWriter.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// gzipMinSize is the smallest response body (in bytes) we bother compressing.
+// For tiny bodies the gzip header and footer can make the response bigger.
+const gzipMinSize = 512
+
+// compressResponse gzips response bodies for clients that say they accept it.
+//
+// Clients advertise what they can decode in the Accept-Encoding request header,
+// e.g. "Accept-Encoding: gzip, deflate, br". When gzip is listed we wrap the
+// ResponseWriter so everything the handler writes is compressed on the way out.
+func (app *App) compressResponse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The response now depends on Accept-Encoding, so tell caches about it.
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		// Close flushes any remaining compressed data once the handler is done.
+		defer gw.Close()
+
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the Accept-Encoding header lists gzip
+// (and hasn't explicitly disabled it with q=0).
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		// A quality value of 0 means "not acceptable", e.g. "gzip;q=0".
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, err := strconv.ParseFloat(v, 64)
+			return err == nil && q > 0
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter wraps an http.ResponseWriter and compresses the body.
+//
+// We can't decide whether to compress until we see the first chunk of the body:
+// if it's tiny, the handler has already compressed it (it set its own
+// Content-Encoding), or it's only part of the body (206 Partial Content),
+// we pass it through untouched. So WriteHeader only records
+// the status code, and the real headers are sent on the first Write.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	status      int
+	wroteHeader bool
+}
+
+func (gw *gzipResponseWriter) WriteHeader(status int) {
+	if gw.status == 0 {
+		gw.status = status
+	}
+}
+
+func (gw *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !gw.wroteHeader {
+		gw.start(len(b))
+	}
+
+	if gw.gz != nil {
+		return gw.gz.Write(b)
+	}
+	return gw.ResponseWriter.Write(b)
+}
+
+// start decides whether to compress, based on the first chunk of the body,
+// then sends the headers.
+func (gw *gzipResponseWriter) start(firstChunk int) {
+	gw.wroteHeader = true
+	if gw.status == 0 {
+		gw.status = http.StatusOK
+	}
+
+	h := gw.Header()
+	alreadyEncoded := h.Get("Content-Encoding") != ""
+	// A partial response's Content-Range counts bytes of the uncompressed
+	// body, so compressing it would make the two disagree.
+	partial := h.Get("Content-Range") != ""
+	if !alreadyEncoded && !partial && firstChunk >= gzipMinSize && bodyAllowed(gw.status) {
+		h.Set("Content-Encoding", "gzip")
+		// The original length no longer matches what we'll send.
+		h.Del("Content-Length")
+		gw.gz = gzip.NewWriter(gw.ResponseWriter)
+	}
+
+	gw.ResponseWriter.WriteHeader(gw.status)
+}
+
+// Flush sends any buffered compressed data to the client straight away,
+// which streaming handlers rely on.
+func (gw *gzipResponseWriter) Flush() {
+	if !gw.wroteHeader {
+		gw.start(0)
+	}
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	// The writer underneath may be another of our wrappers (like
+	// statusRecorder) that doesn't have a Flush method itself, so let
+	// ResponseController look for one through their Unwrap methods.
+	_ = http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+// Close finishes the gzip stream. If the handler never wrote a body
+// (e.g. a 304 Not Modified) it sends the recorded status instead.
+func (gw *gzipResponseWriter) Close() error {
+	if !gw.wroteHeader {
+		gw.wroteHeader = true
+		if gw.status != 0 {
+			gw.ResponseWriter.WriteHeader(gw.status)
+		}
+		return nil
+	}
+	if gw.gz != nil {
+		return gw.gz.Close()
+	}
+	return nil
+}
+
+// Unwrap gives http.ResponseController access to the original ResponseWriter.
+func (gw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+// bodyAllowed reports whether a response with this status code can have a body.
+func bodyAllowed(status int) bool {
+	return status != http.StatusNoContent && status != http.StatusNotModified &&
+		(status < 100 || status > 199)
+}
+
+// rateLimit limits how many requests each client (identified by IP address)
+// can make. Behind a trusted proxy, the IP comes from X-Forwarded-For.
+//
+// Each client gets its own "token bucket" limiter from golang.org/x/time/rate.
+// The bucket holds up to `burst` tokens and refills at `rps` tokens per second.
+// Every request takes one token; when the bucket is empty we reply with
+// 429 Too Many Requests until it refills.
+func (app *App) rateLimit(next http.Handler) http.Handler {
+	// If rate limiting is switched off, there's nothing to wrap.
+	if !app.Config.limiter.enabled {
+		return next
+	}
+
+	// client holds the limiter for one IP, plus when we last saw it.
+	type client struct {
+		limiter  *rate.Limiter
+		lastSeen time.Time
+	}
+
+	// The clients map is shared by every request, and requests run concurrently,
+	// so a mutex guards all access to it.
+	var (
+		mu      sync.Mutex
+		clients = make(map[string]*client)
+	)
+
+	// Without clean-up the map would grow forever. Once a minute, a background
+	// goroutine removes clients we haven't heard from in the last three minutes.
+	// It stops when app.quit is closed during shutdown.
+	app.background(func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.quit:
+				return
+			case <-ticker.C:
+			}
+
+			mu.Lock()
+			for ip, c := range clients {
+				if time.Since(c.lastSeen) > 3*time.Minute {
+					delete(clients, ip)
+				}
+			}
+			mu.Unlock()
+		}
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The client's IP, looking past any trusted proxies (see realIP)
+		ip := realIP(r, app.Config.proxy.trusted)
+
+		mu.Lock()
+
+		// First time we've seen this IP? Give it a fresh limiter.
+		c, found := clients[ip]
+		if !found {
+			c = &client{
+				limiter: rate.NewLimiter(rate.Limit(app.Config.limiter.rps), app.Config.limiter.burst),
+			}
+			clients[ip] = c
+		}
+		c.lastSeen = time.Now()
+
+		// Allow() takes a token if one is available, and reports whether it could.
+		if !c.limiter.Allow() {
+			mu.Unlock()
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+
+		// Unlock before calling the next handler — we don't want to hold the
+		// lock (and block every other client) while the request is processed.
+		mu.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate checks the request carries the API token in its Authorization
+// header, in the form:
+//
+//	Authorization: Bearer <token>
+//
+// Requests without it, or with the wrong token, are rejected with a 401.
+// If no token has been configured (-api-token is empty), auth is switched off
+// and every request is let through, which keeps local development simple.
+//
+// Reads stay public, so it isn't part of the global chain. Routes that
+// change data (POST, PUT, PATCH, DELETE) add it when they're registered:
+//
+//	api("POST", "/books", chain(app.createBookHandler, app.authenticate))
+func (app *App) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.Config.auth.token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The "Bearer" scheme name is case-insensitive, the token itself isn't.
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		// ConstantTimeCompare takes the same time however many characters match,
+		// so an attacker can't guess the token one character at a time by
+		// measuring how quickly we reject them.
+		if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(app.Config.auth.token)) != 1 {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cacheNoStore tells clients and proxies not to keep a copy of the response.
+const cacheNoStore = "no-store"
+
+// cacheControl returns middleware that sets the Cache-Control header to
+// policy on every response. Each route picks its own policy when it's
+// registered, and a handler can still overwrite the header if one response
+// needs something different.
+func cacheControl(policy string) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", policy)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// readCachePolicy is the Cache-Control policy for read endpoints: clients
+// may reuse a response for the configured max age. With no max age they may
+// still store it, but must check it's current (e.g. with If-None-Match)
+// before each use.
+func (app *App) readCachePolicy() string {
+	seconds := int(app.Config.cache.maxAge.Seconds())
+	if seconds <= 0 {
+		return "no-cache"
+	}
+	return "max-age=" + strconv.Itoa(seconds)
+}
+
+// timeoutMessage is the body of the 503 sent when a request times out.
+const timeoutMessage = `{"error":"the request took too long to process, please try again"}`
+
+// timeout returns middleware that gives each request at most d to complete.
+// d <= 0 switches it off. The 503 it sends is labelled with contentType.
+//
+// http.TimeoutHandler does the work. It runs next with a request context
+// that's cancelled after d, and because our stores run their queries with
+// the request's context, a slow query is cancelled at that point too rather
+// than carrying on for nobody. If next hasn't finished by then, the client
+// gets a 503 instead.
+//
+// To be able to send that 503, TimeoutHandler holds the whole response in
+// memory until next returns, and its ResponseWriter can't be flushed. A
+// streamed response (like the CSV export) would arrive all at once, so
+// routes leaves the streaming routes out.
+func timeout(d time.Duration, contentType string) middleware {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+
+		th := http.TimeoutHandler(next, d, timeoutMessage)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			th.ServeHTTP(&timeoutResponseWriter{ResponseWriter: w, contentType: contentType}, r)
+		})
+	}
+}
+
+// unless returns middleware that applies mw to every request except the
+// ones skip reports true for, which go straight on to the next handler.
+func unless(skip func(*http.Request) bool, mw middleware) middleware {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
+
+// timeoutResponseWriter labels TimeoutHandler's 503 as JSON, which it
+// doesn't do itself. Responses from next come with their own headers
+// already copied across, so they're left alone.
+type timeoutResponseWriter struct {
+	http.ResponseWriter
+	contentType string
+}
+
+func (tw *timeoutResponseWriter) WriteHeader(status int) {
+	if status == http.StatusServiceUnavailable && tw.Header().Get("Content-Type") == "" {
+		tw.Header().Set("Content-Type", tw.contentType)
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap gives http.ResponseController access to the original ResponseWriter.
+func (tw *timeoutResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
@@ -0,0 +1,465 @@
+// File: cmd/api/openapi.go
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyclarke/first-go-app/internal/data"
+)
+
+// This file describes the API as an OpenAPI 3 document, served at
+// GET /openapi.json. Tools like Swagger UI or openapi-generator can read it
+// to show interactive docs or generate client libraries.
+//
+// The paths are written out by hand, so REMEMBER TO UPDATE THEM when you add
+// or change an endpoint in routes.go. The Book schema is built from the
+// data.Book struct itself, so it keeps up with new fields automatically.
+
+// openAPIDocument builds the document once, the first time it's needed.
+var openAPIDocument = sync.OnceValue(buildOpenAPIDocument)
+
+// openAPIHandler serves the OpenAPI document.
+func (app *App) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if err := app.writeJSON(w, http.StatusOK, openAPIDocument()); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// object is shorthand for the nested JSON objects an OpenAPI document is made of.
+type object = map[string]any
+
+func buildOpenAPIDocument() object {
+	// Reusable pieces, referenced below with "$ref".
+	bookRef := object{"$ref": "#/components/schemas/Book"}
+	idParam := object{"name": "id", "in": "path", "required": true, "schema": object{"type": "integer", "minimum": 1}}
+	bookRequestBody := object{
+		"required": true,
+		"content":  object{"application/json": object{"schema": object{"$ref": "#/components/schemas/BookRequest"}}},
+	}
+	auth := []object{{"bearerAuth": []string{}}}
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":   "Books API",
+			"version": version,
+		},
+		"paths": object{
+			apiPrefix + "/books": object{
+				"get": object{
+					"summary": "List books",
+					"parameters": []object{
+						queryParam("include_deleted", "boolean", "Also list soft-deleted books"),
+						queryParam("year_from", "integer", "Only books published in or after this year"),
+						queryParam("year_to", "integer", "Only books published in or before this year"),
+						queryParam("sort", "string", "id (the default) or views, for the most viewed first; views can't be used with after_id"),
+						queryParam("fields", "string", "Comma-separated list of fields to return, e.g. id,title"),
+						queryParam("ids", "string", "Comma-separated list of book IDs to fetch, e.g. 1,2,3 (at most 100)"),
+						queryParam("after_id", "integer", "Cursor: only books with a greater ID (switches on cursor pagination)"),
+						queryParam("page_size", "integer", "Books per page in cursor mode"),
+						queryParam("format", "string", "Set to ndjson to stream one book per line"),
+					},
+					"responses": object{
+						"200": jsonResponse("The books", object{
+							"type": "object",
+							"properties": object{
+								"books":    object{"type": "array", "items": bookRef},
+								"metadata": object{"$ref": "#/components/schemas/CursorMetadata"},
+							},
+						}),
+						"422": errorResponseRef("Invalid query parameters"),
+					},
+				},
+				"post": object{
+					"summary":  "Create a book",
+					"security": auth,
+					"parameters": []object{{
+						"name": "Idempotency-Key", "in": "header",
+						"description": "A unique key (e.g. a UUID) that makes retries safe: a repeat gets the first response, with Idempotent-Replayed: true",
+						"schema":      object{"type": "string", "maxLength": maxIdempotencyKeyLength},
+					}, {
+						"name": "Prefer", "in": "header",
+						"description": "return=minimal for an empty body (just the Location header), or return=representation (the default) for the book",
+						"schema":      object{"type": "string", "enum": []string{"return=minimal", "return=representation"}},
+					}},
+					"requestBody": bookRequestBody,
+					"responses": object{
+						"201": jsonResponse("The created book (no body with Prefer: return=minimal); Location is its URL", bookRef),
+						"409": errorResponseRef("A book with this title and author already exists"),
+						"422": errorResponseRef("Validation failed, or the Idempotency-Key was used for a different book"),
+					},
+				},
+				"delete": object{
+					"summary":  "Soft-delete the books listed in ids, or with no ids, permanently delete every book (development only)",
+					"security": auth,
+					"parameters": []object{
+						queryParam("ids", "string", "Comma-separated list of book IDs to delete, e.g. 1,2,3 (at most 100)"),
+						queryParam("confirm", "boolean", "Must be true to delete every book"),
+					},
+					"responses": object{
+						"200": jsonResponse("How many books were deleted", object{
+							"type": "object", "properties": object{"deleted": object{"type": "integer"}},
+						}),
+						"403": errorResponseRef("Deleting every book when not running in development"),
+						"422": errorResponseRef("Invalid or too many ids"),
+					},
+				},
+			},
+			apiPrefix + "/books/count": object{
+				"get": object{
+					"summary": "Count books (accepts the same filters as the list)",
+					"responses": object{
+						"200": jsonResponse("The count", object{
+							"type": "object", "properties": object{"count": object{"type": "integer"}},
+						}),
+					},
+				},
+			},
+			apiPrefix + "/books/stats": object{
+				"get": object{
+					"summary":   "Aggregate statistics about the catalog",
+					"responses": object{"200": jsonResponse("The statistics", schemaFor(reflect.TypeOf(data.BookStats{})))},
+				},
+			},
+			apiPrefix + "/books/facets": object{
+				"get": object{
+					"summary": "Count books per year and per genre",
+					"responses": object{"200": jsonResponse("The counts", object{
+						"type": "object",
+						"properties": object{
+							"by_year":  object{"type": "object", "additionalProperties": object{"type": "integer"}},
+							"by_genre": object{"type": "object", "additionalProperties": object{"type": "integer"}},
+						},
+					})},
+				},
+			},
+			apiPrefix + "/books/by-decade": object{
+				"get": object{
+					"summary": "List books grouped by decade (\"2010s\", ..., or \"unknown\" without a year)",
+					"responses": object{"200": jsonResponse("The books in each decade", object{
+						"type":                 "object",
+						"additionalProperties": object{"type": "array", "items": bookRef},
+					})},
+				},
+			},
+			apiPrefix + "/books/random": object{
+				"get": object{
+					"summary":   "Get a random book",
+					"responses": object{"200": jsonResponse("A book", bookRef), "404": object{"description": "No books"}},
+				},
+			},
+			apiPrefix + "/books/export": object{
+				"get": object{
+					"summary": "Download every book as CSV",
+					"responses": object{"200": object{
+						"description": "A CSV file",
+						"content":     object{"text/csv": object{"schema": object{"type": "string"}}},
+					}},
+				},
+			},
+			apiPrefix + "/books/events": object{
+				"get": object{
+					"summary": "Stream newly created books as server-sent events",
+					"responses": object{"200": object{
+						"description": "A data: line holding each new book as JSON",
+						"content":     object{"text/event-stream": object{"schema": object{"type": "string"}}},
+					}},
+				},
+			},
+			apiPrefix + "/books/import": object{
+				"post": object{
+					"summary":    "Import books from CSV or a JSON array",
+					"security":   auth,
+					"parameters": []object{queryParam("dry_run", "boolean", "Validate and check for duplicates, but save nothing")},
+					"requestBody": object{"required": true, "content": object{
+						"text/csv":         object{"schema": object{"type": "string"}},
+						"application/json": object{"schema": object{"type": "array", "items": object{"$ref": "#/components/schemas/BookRequest"}}},
+					}},
+					"responses": object{"200": object{"description": "How many books were imported and skipped"}},
+				},
+			},
+			apiPrefix + "/books/batch": object{
+				"post": object{
+					"summary":  "Create several books at once (all or nothing)",
+					"security": auth,
+					"requestBody": object{"required": true, "content": object{
+						"application/json": object{"schema": object{"type": "array", "maxItems": maxBatchBooks, "items": object{"$ref": "#/components/schemas/BookRequest"}}},
+					}},
+					"responses": object{
+						"201": jsonResponse("The created books (existing ones are skipped)", object{
+							"type": "object", "properties": object{"books": object{"type": "array", "items": bookRef}},
+						}),
+						"422": jsonResponse("Validation failed for some of the books", object{
+							"type":       "object",
+							"properties": object{"errors": object{"type": "array", "items": schemaFor(reflect.TypeOf(batchItemError{}))}},
+						}),
+					},
+				},
+			},
+			apiPrefix + "/books/{id}": object{
+				"parameters": []object{idParam},
+				"get": object{
+					"summary":   "Get a book",
+					"responses": object{"200": jsonResponse("The book", bookRef), "304": object{"description": "Not modified"}, "404": object{"description": "Not found"}},
+				},
+				"head": object{
+					"summary":   "Check a book exists (headers only)",
+					"responses": object{"200": object{"description": "Exists"}, "304": object{"description": "Not modified"}, "404": object{"description": "Not found"}},
+				},
+				"put": object{
+					"summary":  "Replace a book",
+					"security": auth,
+					"parameters": []object{{
+						"name": "If-Match", "in": "header", "required": true,
+						"description": "The ETag from GET /books/{id}",
+						"schema":      object{"type": "string"},
+					}},
+					"requestBody": object{
+						"required": true,
+						"content": object{"application/json": object{"schema": object{"allOf": []object{
+							{"$ref": "#/components/schemas/BookRequest"},
+							{"type": "object", "properties": object{
+								"id": object{"type": "integer", "description": "Optional; if sent, it must match {id} in the path"},
+							}},
+						}}}},
+					},
+					"responses": object{
+						"200": jsonResponse("The updated book", bookRef),
+						"404": object{"description": "Not found"},
+						"422": errorResponseRef("Validation failed, or the id in the body isn't the one in the path"),
+						"412": object{"description": "The book has changed since its ETag was fetched"},
+						"428": object{"description": "No If-Match header"},
+					},
+				},
+				"delete": object{
+					"summary":   "Soft-delete a book",
+					"security":  auth,
+					"responses": object{"200": object{"description": "Deleted"}, "404": object{"description": "Not found"}},
+				},
+			},
+			apiPrefix + "/books/by-isbn/{isbn}": object{
+				"put": object{
+					"summary":     "Create or update a book by ISBN",
+					"security":    auth,
+					"parameters":  []object{{"name": "isbn", "in": "path", "required": true, "schema": object{"type": "string"}}},
+					"requestBody": bookRequestBody,
+					"responses":   object{"200": jsonResponse("Updated", bookRef), "201": jsonResponse("Created", bookRef)},
+				},
+			},
+			apiPrefix + "/books/isbn/{isbn}": object{
+				"get": object{
+					"summary":    "Look up a book by ISBN (hyphens and spaces are ignored)",
+					"parameters": []object{{"name": "isbn", "in": "path", "required": true, "schema": object{"type": "string"}}},
+					"responses": object{
+						"200": jsonResponse("The book", bookRef),
+						"404": object{"description": "No book with that ISBN"},
+						"422": errorResponseRef("Not a valid ISBN-10 or ISBN-13"),
+					},
+				},
+			},
+			apiPrefix + "/books/{id}/year": object{
+				"parameters": []object{idParam},
+				"patch": object{
+					"summary":  "Correct a book's year",
+					"security": auth,
+					"requestBody": object{"required": true, "content": object{"application/json": object{"schema": object{
+						"type":       "object",
+						"properties": object{"year": object{"type": "integer", "minimum": 1}},
+						"required":   []string{"year"},
+					}}}},
+					"responses": object{
+						"200": jsonResponse("The updated book", bookRef),
+						"404": object{"description": "Not found"},
+						"422": object{"description": "Missing, negative or future year"},
+					},
+				},
+			},
+			apiPrefix + "/books/{id}/related": object{
+				"parameters": []object{idParam},
+				"get": object{
+					"summary": "List up to five books by the same author, then the same genre or decade",
+					"responses": object{
+						"200": jsonResponse("The related books (possibly none)", object{
+							"type": "object", "properties": object{"books": object{"type": "array", "items": bookRef}},
+						}),
+						"404": object{"description": "No such book"},
+					},
+				},
+			},
+			apiPrefix + "/books/{id}/reviews": object{
+				"parameters": []object{idParam},
+				"get": object{
+					"summary": "List a book's reviews",
+					"responses": object{"200": jsonResponse("The reviews", object{
+						"type": "object", "properties": object{"reviews": object{"type": "array", "items": object{"$ref": "#/components/schemas/Review"}}},
+					})},
+				},
+				"post": object{
+					"summary":  "Review a book",
+					"security": auth,
+					"requestBody": object{"required": true, "content": object{"application/json": object{"schema": object{
+						"type": "object",
+						"properties": object{
+							"rating": object{"type": "integer", "minimum": 1, "maximum": 5},
+							"body":   object{"type": "string"},
+						},
+						"required": []string{"rating", "body"},
+					}}}},
+					"responses": object{"201": jsonResponse("The review", object{"$ref": "#/components/schemas/Review"})},
+				},
+			},
+			apiPrefix + "/books/{id}/cover": object{
+				"parameters": []object{idParam},
+				"get": object{
+					"summary": "Download a book's cover image",
+					"responses": object{"200": object{"description": "The image", "content": object{
+						"image/png":  object{"schema": object{"type": "string", "format": "binary"}},
+						"image/jpeg": object{"schema": object{"type": "string", "format": "binary"}},
+					}}},
+				},
+				"post": object{
+					"summary":  "Upload a cover image (PNG or JPEG)",
+					"security": auth,
+					"requestBody": object{"required": true, "content": object{"multipart/form-data": object{"schema": object{
+						"type": "object", "properties": object{"cover": object{"type": "string", "format": "binary"}},
+					}}}},
+					"responses": object{"201": object{"description": "Uploaded"}},
+				},
+			},
+			apiPrefix + "/authors": object{
+				"get": object{
+					"summary": "List the authors of the books, alphabetically",
+					"parameters": []object{
+						queryParam("after", "string", "Cursor: only authors after this name (switches on cursor pagination)"),
+						queryParam("page_size", "integer", "Authors per page in cursor mode"),
+					},
+					"responses": object{
+						"200": jsonResponse("The authors", object{
+							"type": "object",
+							"properties": object{
+								"authors":  object{"type": "array", "items": object{"type": "string"}},
+								"metadata": schemaFor(reflect.TypeOf(authorsMetadata{})),
+							},
+						}),
+						"422": errorResponseRef("Invalid query parameters"),
+					},
+				},
+			},
+			"/healthz": object{
+				"get": object{"summary": "Combined health check", "responses": object{"200": object{"description": "Healthy"}, "503": object{"description": "Degraded"}}},
+			},
+			"/healthz/live": object{
+				"get": object{"summary": "Liveness probe", "responses": object{"200": object{"description": "Alive"}}},
+			},
+			"/healthz/ready": object{
+				"get": object{"summary": "Readiness probe", "responses": object{"200": object{"description": "Ready"}, "503": object{"description": "Not ready"}}},
+			},
+		},
+		"components": object{
+			"schemas": object{
+				"Book":   schemaFor(reflect.TypeOf(data.Book{})),
+				"Review": schemaFor(reflect.TypeOf(data.Review{})),
+				"BookRequest": object{
+					"type": "object",
+					"properties": object{
+						"title":  object{"type": "string"},
+						"author": object{"type": "string"},
+						"year":   object{"type": "integer", "minimum": 1},
+						"genre":  object{"type": "string"},
+					},
+					"required": []string{"title", "author"},
+				},
+				"CursorMetadata": schemaFor(reflect.TypeOf(cursorMetadata{})),
+				"Error": object{
+					"type": "object",
+					"properties": object{
+						"error":  object{},
+						"fields": object{"type": "object", "additionalProperties": object{"type": "string"}},
+					},
+				},
+			},
+			"securitySchemes": object{
+				"bearerAuth": object{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// queryParam describes an optional query string parameter.
+func queryParam(name, typ, description string) object {
+	return object{"name": name, "in": "query", "description": description, "schema": object{"type": typ}}
+}
+
+// jsonResponse describes a JSON response with the given schema.
+func jsonResponse(description string, schema object) object {
+	return object{
+		"description": description,
+		"content":     object{"application/json": object{"schema": schema}},
+	}
+}
+
+// errorResponseRef describes one of our {"error": ...} responses.
+func errorResponseRef(description string) object {
+	return jsonResponse(description, object{"$ref": "#/components/schemas/Error"})
+}
+
+// schemaFor builds an OpenAPI schema for a struct from its fields and their
+// `json` tags, so the spec matches exactly what encoding/json sends.
+// Fields tagged json:"-" (like XMLName) are left out.
+func schemaFor(t reflect.Type) object {
+	properties := object{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = typeSchema(field.Type)
+	}
+
+	return object{"type": "object", "properties": properties}
+}
+
+// typeSchema maps a Go type to the matching OpenAPI type.
+func typeSchema(t reflect.Type) object {
+	nullable := false
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		nullable = true
+	}
+
+	var schema object
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		schema = object{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.String:
+		schema = object{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		schema = object{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		schema = object{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		schema = object{"type": "number"}
+	case t.Kind() == reflect.Slice:
+		schema = object{"type": "array", "items": typeSchema(t.Elem())}
+	case t.Kind() == reflect.Struct:
+		schema = schemaFor(t)
+	default:
+		schema = object{}
+	}
+
+	if nullable {
+		schema["nullable"] = true
+	}
+	return schema
+}
@@ -0,0 +1,80 @@
+// File: cmd/api/pagination.go
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// The page sizes used when -page-size-default and -page-size-max aren't set.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// cursorMetadata is added to a list response in cursor mode.
+// NextCursor is the after_id to send for the next page, or 0 when there
+// are no more pages.
+type cursorMetadata struct {
+	NextCursor int64 `json:"next_cursor" xml:"next_cursor"`
+	PageSize   int   `json:"page_size" xml:"page_size"`
+}
+
+// parseCursor reads the cursor pagination parameters, e.g.
+//
+//	GET /books?after_id=20&page_size=10
+//
+// Cursor mode is opt-in: it's only switched on (ok=true) when after_id is
+// present. Start from the beginning with after_id=0.
+//
+// page_size is read by readPageSize.
+func (app *App) parseCursor(r *http.Request) (afterID int64, pageSize int, ok bool, errs map[string]string) {
+	qs := r.URL.Query()
+	if !qs.Has("after_id") {
+		return 0, 0, false, nil
+	}
+
+	errs = make(map[string]string)
+
+	afterID = int64(readInt(qs, "after_id", 0, errs))
+	if _, bad := errs["after_id"]; bad || afterID < 0 {
+		errs["after_id"] = "after_id must be a non-negative integer"
+	}
+
+	pageSize = app.readPageSize(qs, errs)
+
+	if len(errs) > 0 {
+		return 0, 0, true, errs
+	}
+	return afterID, pageSize, true, nil
+}
+
+// readPageSize reads ?page_size for any list in cursor mode. It defaults to
+// the configured default. A page_size above the configured maximum is
+// rejected in strict mode, and otherwise quietly cut down to the maximum.
+func (app *App) readPageSize(qs url.Values, errs map[string]string) int {
+	defaultSize, maxSize := app.pageSizes()
+	pageSize := readInt(qs, "page_size", defaultSize, errs)
+	if pageSize > maxSize && !app.Config.pagination.strict {
+		pageSize = maxSize
+	}
+	if _, bad := errs["page_size"]; bad || pageSize < 1 || pageSize > maxSize {
+		errs["page_size"] = "page_size must be between 1 and " + strconv.Itoa(maxSize)
+	}
+	return pageSize
+}
+
+// pageSizes returns the configured default and maximum page sizes, falling
+// back to defaultPageSize and maxPageSize when they haven't been set.
+// The default is never allowed to be bigger than the maximum.
+func (app *App) pageSizes() (defaultSize, maxSize int) {
+	defaultSize, maxSize = app.Config.pagination.defaultSize, app.Config.pagination.maxSize
+	if maxSize < 1 {
+		maxSize = maxPageSize
+	}
+	if defaultSize < 1 {
+		defaultSize = defaultPageSize
+	}
+	return min(defaultSize, maxSize), maxSize
+}
@@ -0,0 +1,57 @@
+// File: cmd/api/prefer.go
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/garyclarke/first-go-app/internal/data"
+)
+
+// preferReturn reads the "return" preference from the Prefer header
+// (RFC 7240), e.g.
+//
+//	Prefer: return=minimal
+//
+// The header is a comma-separated list of preferences, each of which may
+// have parameters after a ";". It returns "minimal", "representation", or
+// "" when the client didn't say (or said something we don't know).
+func preferReturn(r *http.Request) string {
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			pref, _, _ = strings.Cut(pref, ";")
+			name, value, _ := strings.Cut(strings.TrimSpace(pref), "=")
+			if !strings.EqualFold(strings.TrimSpace(name), "return") {
+				continue
+			}
+			switch value = strings.ToLower(strings.Trim(strings.TrimSpace(value), `"`)); value {
+			case "minimal", "representation":
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// writeCreatedBook sends the response to a request that created book.
+//
+// The Location header always points at the new book. By default the book is
+// in the body too, so the client doesn't need to fetch it. A client that
+// sends Prefer: return=minimal gets just the status and Location, which
+// saves sending a body it would throw away. Whenever we act on a Prefer
+// header we say so in Preference-Applied.
+func (app *App) writeCreatedBook(w http.ResponseWriter, r *http.Request, status int, book *data.Book) error {
+	w.Header().Set("Location", fmt.Sprintf("%s/books/%d", apiPrefix, book.ID))
+
+	switch preferReturn(r) {
+	case "minimal":
+		w.Header().Set("Preference-Applied", "return=minimal")
+		w.WriteHeader(status)
+		return nil
+	case "representation":
+		w.Header().Set("Preference-Applied", "return=representation")
+	}
+
+	return app.writeResponse(w, r, status, book)
+}
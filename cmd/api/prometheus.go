@@ -0,0 +1,165 @@
+// File: cmd/api/prometheus.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prometheus metrics, served at GET /metrics.
+//
+// Prometheus scrapes a plain-text page listing each metric, one line per
+// combination of labels, e.g.
+//
+//	http_requests_total{method="GET",route="/v1/books/{id}",status="200"} 42
+//
+// The format is simple enough that we write it ourselves rather than pull
+// in the Prometheus client library. We record two metrics:
+//
+//   - http_requests_total, a counter of requests handled
+//   - http_request_duration_seconds, a histogram of how long they took
+//
+// The route label is the pattern the request matched (such as /v1/books/{id}),
+// not the raw path. Otherwise every book ID would create a new set of time
+// series, and Prometheus would soon run out of memory ("cardinality blowup").
+
+// durationBuckets are the histogram's upper bounds, in seconds.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// promLabels identifies one time series.
+type promLabels struct {
+	method string
+	route  string
+	status int
+}
+
+// promHistogram counts observations into cumulative buckets, as Prometheus expects.
+type promHistogram struct {
+	buckets []uint64 // buckets[i] counts observations <= durationBuckets[i]
+	count   uint64
+	sum     float64
+}
+
+// promRegistry holds every series we've recorded. Like the expvar metrics,
+// there's one per process, shared by every request, so a mutex guards it.
+type promRegistry struct {
+	mu        sync.Mutex
+	requests  map[promLabels]uint64
+	durations map[promLabels]*promHistogram
+}
+
+var promMetrics = &promRegistry{
+	requests:  make(map[promLabels]uint64),
+	durations: make(map[promLabels]*promHistogram),
+}
+
+// observe records one handled request.
+func (reg *promRegistry) observe(labels promLabels, d time.Duration) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.requests[labels]++
+
+	h, ok := reg.durations[labels]
+	if !ok {
+		h = &promHistogram{buckets: make([]uint64, len(durationBuckets))}
+		reg.durations[labels] = h
+	}
+	seconds := d.Seconds()
+	for i, upper := range durationBuckets {
+		if seconds <= upper {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// writeTo writes every metric in the Prometheus text format. Series are
+// sorted so the output is stable from one scrape to the next.
+func (reg *promRegistry) writeTo(w io.Writer) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	keys := make([]promLabels, 0, len(reg.requests))
+	for k := range reg.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+
+	fmt.Fprintln(w, "# HELP http_requests_total Total number of HTTP requests handled.")
+	fmt.Fprintln(w, "# TYPE http_requests_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "http_requests_total{%s} %d\n", k.format(), reg.requests[k])
+	}
+
+	fmt.Fprintln(w, "# HELP http_request_duration_seconds How long HTTP requests took to handle.")
+	fmt.Fprintln(w, "# TYPE http_request_duration_seconds histogram")
+	for _, k := range keys {
+		h := reg.durations[k]
+		labels := k.format()
+		for i, upper := range durationBuckets {
+			le := strconv.FormatFloat(upper, 'g', -1, 64)
+			fmt.Fprintf(w, "http_request_duration_seconds_bucket{%s,le=%q} %d\n", labels, le, h.buckets[i])
+		}
+		fmt.Fprintf(w, "http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "http_request_duration_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(w, "http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+}
+
+// format renders the labels as method="GET",route="/v1/books",status="200".
+// %q escapes quotes and backslashes the same way Prometheus expects.
+func (l promLabels) format() string {
+	return fmt.Sprintf("method=%q,route=%q,status=\"%d\"", l.method, l.route, l.status)
+}
+
+// prometheusMetrics records the count and duration of every request.
+//
+// It has to sit directly around the mux (fallback passes the same request
+// straight through): the mux sets r.Pattern on the request it's given, and
+// middleware further out only sees an earlier copy of the request
+// (requestID, for one, makes a new copy to add the ID).
+func (app *App) prometheusMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// The pattern includes the method ("GET /books/{id}"); we only want
+		// the path part, since the method has its own label. Requests that
+		// matched no route at all have no pattern.
+		route := "unmatched"
+		if r.Pattern != "" {
+			_, path, found := strings.Cut(r.Pattern, " ")
+			if !found {
+				path = r.Pattern
+			}
+			route = path
+		}
+
+		promMetrics.observe(promLabels{method: r.Method, route: route, status: rec.Status()}, time.Since(start))
+	})
+}
+
+// prometheusHandler serves the metrics in Prometheus' text format.
+func (app *App) prometheusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	promMetrics.writeTo(w)
+}
@@ -0,0 +1,57 @@
+// File: cmd/api/query.go
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// These helpers read a single query string parameter, falling back to a
+// default when it's missing. Problems are recorded in errs (keyed by the
+// parameter name) rather than returned, so a handler can read every
+// parameter first and then report all the mistakes in one 422 response.
+
+// readString returns the value of key, or defaultValue if it's missing or empty.
+func readString(qs url.Values, key, defaultValue string) string {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	return s
+}
+
+// readCSV splits a comma-separated value like ?fields=id,title into its
+// parts, trimming spaces and dropping empty entries. It returns defaultValue
+// if key is missing or empty.
+func readCSV(qs url.Values, key string, defaultValue []string) []string {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	var values []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
+
+// readInt parses key as an integer, returning defaultValue if it's missing.
+// A value that isn't a whole number adds an error to errs and also gives
+// defaultValue. Range checks (positive, at most 100, ...) are up to the caller.
+func readInt(qs url.Values, key string, defaultValue int, errs map[string]string) int {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		errs[key] = key + " must be an integer"
+		return defaultValue
+	}
+	return i
+}
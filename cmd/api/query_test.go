@@ -0,0 +1,91 @@
+// File: cmd/api/query_test.go
+package main
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestReadInt(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    int
+		wantErr bool
+	}{
+		{name: "missing", query: "", want: 20},
+		{name: "empty", query: "page_size=", want: 20},
+		{name: "valid", query: "page_size=5", want: 5},
+		{name: "negative", query: "page_size=-5", want: -5}, // range checks are the caller's job
+		{name: "non-numeric", query: "page_size=ten", want: 20, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			qs, err := url.ParseQuery(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			errs := make(map[string]string)
+
+			got := readInt(qs, "page_size", 20, errs)
+			if got != tc.want {
+				t.Errorf("want %d; got %d", tc.want, got)
+			}
+			if _, ok := errs["page_size"]; ok != tc.wantErr {
+				t.Errorf("want error: %t; got errors %v", tc.wantErr, errs)
+			}
+		})
+	}
+}
+
+func TestReadString(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{name: "missing", query: "", want: "json"},
+		{name: "empty", query: "format=", want: "json"},
+		{name: "valid", query: "format=ndjson", want: "ndjson"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			qs, err := url.ParseQuery(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := readString(qs, "format", "json"); got != tc.want {
+				t.Errorf("want %q; got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "missing", query: "", want: []string{"id"}},
+		{name: "valid", query: "fields=id,title", want: []string{"id", "title"}},
+		{name: "spaces and blanks", query: "fields= id , ,title,", want: []string{"id", "title"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			qs, err := url.ParseQuery(tc.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := readCSV(qs, "fields", []string{"id"}); !slices.Equal(got, tc.want) {
+				t.Errorf("want %q; got %q", tc.want, got)
+			}
+		})
+	}
+}
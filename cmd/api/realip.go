@@ -0,0 +1,71 @@
+// File: cmd/api/realip.go
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// realIP works out the IP address of the client that made the request.
+//
+// Behind a proxy or load balancer, r.RemoteAddr is the proxy's address, not
+// the client's. Proxies add the address they received the request from to
+// the X-Forwarded-For header, building a list like:
+//
+//	X-Forwarded-For: <client>, <proxy 1>, <proxy 2>
+//
+// Anyone can send that header, though, so we only believe it when the
+// request came to us directly from one of the trusted proxies. We then read
+// the list from the right (the entries nearest to us), skipping our own
+// proxies, and the first address we don't trust is the client.
+//
+// trusted holds IP addresses ("10.0.0.1") or CIDR ranges ("10.0.0.0/8").
+func realIP(r *http.Request, trusted []string) string {
+	// RemoteAddr is "ip:port"; we only want the IP.
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+
+	if !isTrustedProxy(peer, trusted) {
+		return peer
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if ip == "" {
+			continue
+		}
+		if !isTrustedProxy(ip, trusted) {
+			return ip
+		}
+	}
+
+	// No header, or every address in it is one of our proxies
+	return peer
+}
+
+// isTrustedProxy reports whether ip matches an address or range in trusted.
+func isTrustedProxy(ip string, trusted []string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap() // treat ::ffff:10.0.0.1 the same as 10.0.0.1
+
+	for _, t := range trusted {
+		if prefix, err := netip.ParsePrefix(t); err == nil {
+			if prefix.Contains(addr) {
+				return true
+			}
+			continue
+		}
+		if trustedAddr, err := netip.ParseAddr(t); err == nil && trustedAddr.Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+// File: cmd/api/realip_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted := []string{"10.0.0.1", "192.168.0.0/16"}
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		xForwardedFor string
+		want          string
+	}{
+		{name: "no proxy", remoteAddr: "203.0.113.7:5000", want: "203.0.113.7"},
+		{name: "untrusted peer can't spoof the header", remoteAddr: "203.0.113.7:5000", xForwardedFor: "1.2.3.4", want: "203.0.113.7"},
+		{name: "trusted peer", remoteAddr: "10.0.0.1:5000", xForwardedFor: "198.51.100.9", want: "198.51.100.9"},
+		{name: "trusted peer in a CIDR range", remoteAddr: "192.168.1.20:5000", xForwardedFor: "198.51.100.9", want: "198.51.100.9"},
+		{name: "chain of trusted proxies", remoteAddr: "10.0.0.1:5000", xForwardedFor: "198.51.100.9, 192.168.4.4", want: "198.51.100.9"},
+		{name: "client spoofing behind a trusted proxy", remoteAddr: "10.0.0.1:5000", xForwardedFor: "1.2.3.4, 198.51.100.9", want: "198.51.100.9"},
+		{name: "trusted peer without the header", remoteAddr: "10.0.0.1:5000", want: "10.0.0.1"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/books", http.NoBody)
+			r.RemoteAddr = tc.remoteAddr
+			if tc.xForwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tc.xForwardedFor)
+			}
+
+			if got := realIP(r, trusted); got != tc.want {
+				t.Errorf("want %q; got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+// TestRateLimit_TrustedProxy checks that clients behind a trusted proxy get
+// a rate limit each, rather than sharing the proxy's.
+func TestRateLimit_TrustedProxy(t *testing.T) {
+	app := setupTestApp(t)
+	app.Config.limiter.enabled = true
+	app.Config.limiter.rps = 1
+	app.Config.limiter.burst = 1
+	app.Config.proxy.trusted = []string{"10.0.0.1"}
+	routes := app.routes()
+
+	get := func(client string) int {
+		r := httptest.NewRequest(http.MethodGet, "/healthz/live", http.NoBody)
+		r.RemoteAddr = "10.0.0.1:5000"
+		r.Header.Set("X-Forwarded-For", client)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, r)
+		return rr.Code
+	}
+
+	// Each client uses up its own single-request burst...
+	if code := get("198.51.100.1"); code != http.StatusOK {
+		t.Fatalf("first client: want status code %d; got %d", http.StatusOK, code)
+	}
+	if code := get("198.51.100.2"); code != http.StatusOK {
+		t.Fatalf("second client: want status code %d; got %d", http.StatusOK, code)
+	}
+	// ...so the first client's next request is the one that's limited
+	if code := get("198.51.100.1"); code != http.StatusTooManyRequests {
+		t.Errorf("first client again: want status code %d; got %d", http.StatusTooManyRequests, code)
+	}
+}
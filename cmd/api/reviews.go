@@ -0,0 +1,103 @@
+// File: cmd/api/reviews.go
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+
+	"github.com/garyclarke/first-go-app/internal/data"
+	"github.com/garyclarke/first-go-app/internal/request"
+)
+
+// reviewResponse is the envelope for a book's reviews.
+// In XML it becomes <reviews><review>...</review></reviews>.
+type reviewResponse struct {
+	XMLName xml.Name      `json:"-" xml:"reviews"`
+	Reviews []data.Review `json:"reviews" xml:"review"`
+}
+
+// bookIDFromPath reads the {id} path value and checks that book exists.
+// It writes a 404 (or 500) itself and returns ok=false if not, so callers
+// can simply return.
+//
+// We only need the ID here, not the book itself, so Exists saves loading
+// the whole row.
+func (app *App) bookIDFromPath(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return 0, false
+	}
+
+	exists, err := app.Stores.Books.Exists(r.Context(), id)
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return 0, false
+	}
+	if !exists {
+		app.notFoundResponse(w, r)
+		return 0, false
+	}
+
+	return id, true
+}
+
+func (app *App) listReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Make sure the book exists, so an unknown book is a 404
+	// rather than an empty list
+	bookID, ok := app.bookIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	// Step 2: Fetch its reviews
+	reviews, err := app.Stores.Reviews.GetByBook(r.Context(), bookID)
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	// Step 3: Write the reviews (JSON, or XML if the client asked for it)
+	if err := app.writeResponse(w, r, http.StatusOK, reviewResponse{Reviews: reviews}); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+func (app *App) createReviewHandler(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Make sure the book exists before we try to review it
+	bookID, ok := app.bookIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	// Step 2: Decode the request body into a ReviewRequest
+	var rr request.ReviewRequest
+	if err := app.readJSON(w, r, &rr); err != nil {
+		app.readJSONErrorResponse(w, r, err)
+		return
+	}
+
+	// Step 3: Validate the input
+	validationErrors := request.ValidateReviewRequest(&rr)
+	if len(validationErrors) > 0 {
+		app.failedValidationResponse(w, r, validationErrors)
+		return
+	}
+
+	// Step 4: Save the review against the book
+	review, err := app.Stores.Reviews.Insert(r.Context(), &data.Review{
+		BookID: bookID,
+		Rating: rr.Rating,
+		Body:   rr.Body,
+	})
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	// Step 5: Return the created review with a 201 Created status.
+	if err := app.writeResponse(w, r, http.StatusCreated, review); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -2,75 +2,388 @@
 package main
 
 import (
-	"database/sql"
-	"encoding/json"
+	"context"
+	"encoding/xml"
 	"errors"
+	"expvar"
 	"github.com/garyclarke/first-go-app/internal/request"
-	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/garyclarke/first-go-app/internal/data"
 )
 
+// bookResponse is the envelope for a list of books. It's a struct rather
+// than an envelope so it can be sent as XML too:
+// <books><book>...</book><book>...</book></books>.
+//
+// Metadata is only filled in when the client is paging with a cursor.
 type bookResponse struct {
-	Books []data.Book `json:"books"`
+	XMLName  xml.Name        `json:"-" xml:"books"`
+	Books    []data.Book     `json:"books" xml:"book"`
+	Metadata *cursorMetadata `json:"metadata,omitempty" xml:"metadata,omitempty"`
 }
 
 // healthResponse is a struct that represents our JSON response.
 // The struct tags (e.g. `json:"status"`) tell the encoder to use lowercase keys in the JSON output.
+// The `xml` tags do the same job for XML responses.
 type healthResponse struct {
-	Status  string `json:"status"`
-	Version string `json:"version"`
+	XMLName xml.Name `json:"-" xml:"health"`
+	Status  string   `json:"status" xml:"status"`
+	Version string   `json:"version,omitempty" xml:"version,omitempty"`
 }
 
+// apiPrefix is the version prefix for the API routes, e.g. GET /v1/books.
+// When we need to make breaking changes, a /v2 can live alongside /v1 so
+// existing clients keep working while they move over.
+const apiPrefix = "/v1"
+
 // routes defines the HTTP routes and returns an http.Handler.
 //
 // In Go, an http.Handler is any type that has a ServeHTTP() method.
 // It’s the interface used by the HTTP server to process requests.
 //
 // Our ServeMux (multiplexer) is a built-in implementation of http.Handler
-// that routes requests based on method + path (like "GET /books").
+// that routes requests based on method + path (like "GET /v1/books").
 //
 // By returning it here, we let main() pass it to http.ListenAndServe,
 // which takes over from there and starts handling traffic.
+//
+// Before returning the mux we wrap it in middleware with chain, so every
+// request passes through metrics, serverHeader, requestID, logRequest,
+// responseTime, recoverPanic, enableCORS, rateLimit, compressResponse and
+// prometheusMetrics (in that order) on its way to the matching handler.
+// recoverPanic sits inside logRequest and metrics, so a panic is still
+// logged and counted as the 500 it turns into. enableCORS comes before
+// rateLimit so that even a 429 tells the browser it may read it.
+//
+// Middleware that only some routes need, like Cache-Control and
+// authenticate, is chained onto each route as it's registered. So is the
+// -request-timeout: every route gets it except the ones that stream their
+// response, which it would hold back until the end (see timeout). Requests
+// with no matching route get a JSON error from fallback.
 func (app *App) routes() http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /healthz", app.healthcheckHandler)
-	mux.HandleFunc("GET /books", app.listBooksHandler)
-	mux.HandleFunc("GET /books/{id}", app.showBookHandler)
-	mux.HandleFunc("POST /books", app.createBookHandler)
-	mux.HandleFunc("PUT /books/{id}", app.putBookHandler)
-	return mux
+
+	// limit gives up on a request that takes longer than -request-timeout.
+	limit := timeout(app.Config.server.requestTimeout, app.jsonContentType())
+
+	// Operational endpoints for load balancers and monitoring. They aren't
+	// part of the versioned API, so they have no prefix.
+	// Their answers can change at any moment, so they're never cached.
+	mux.Handle("GET /healthz", chain(app.healthcheckHandler, limit, cacheControl(cacheNoStore)))
+	mux.Handle("GET /healthz/live", chain(app.livenessHandler, limit, cacheControl(cacheNoStore)))
+	mux.Handle("GET /healthz/ready", chain(app.readinessHandler, limit, cacheControl(cacheNoStore)))
+	mux.Handle("GET /debug/vars", chain(expvar.Handler().ServeHTTP, limit, cacheControl(cacheNoStore)))
+	mux.Handle("GET /metrics", chain(app.prometheusHandler, limit, cacheControl(cacheNoStore)))
+	mux.Handle("GET /openapi.json", chain(app.openAPIHandler, limit, cacheControl(app.readCachePolicy())))
+
+	// apiOn registers an API route on m under apiPrefix. When legacy routes
+	// are switched on, it's also registered at its old unversioned path, so
+	// links from before we added versioning (e.g. GET /books) still work.
+	// Almost every route goes on mux with the request timeout, so api is
+	// the short way to do that. stream is for routes that stream their
+	// response, which go without it.
+	apiOn := func(m *http.ServeMux, method, path string, h http.Handler) {
+		m.Handle(method+" "+apiPrefix+path, h)
+		if app.Config.legacyRoutes {
+			m.Handle(method+" "+path, h)
+		}
+	}
+	api := func(method, path string, h http.Handler) {
+		apiOn(mux, method, path, limit(h))
+	}
+	stream := func(method, path string, h http.Handler) {
+		apiOn(mux, method, path, h)
+	}
+
+	// Reads may be cached briefly (see -cache-max-age). The random book is
+	// different every time, and the event stream is live, so they must never
+	// be cached.
+	read := app.readCachePolicy()
+	// The list is only streamed when it's asked for as NDJSON.
+	stream("GET", "/books", chain(app.listBooksHandler, unless(wantsNDJSON, limit), cacheControl(read)))
+	api("GET", "/books/count", chain(app.countBooksHandler, cacheControl(read)))
+	api("GET", "/books/stats", chain(app.bookStatsHandler, cacheControl(read)))
+	api("GET", "/books/facets", chain(app.bookFacetsHandler, cacheControl(read)))
+	api("GET", "/books/by-decade", chain(app.booksByDecadeHandler, cacheControl(read)))
+	stream("GET", "/books/export", chain(app.exportBooksHandler, cacheControl(read)))
+	api("GET", "/books/random", chain(app.randomBookHandler, cacheControl(cacheNoStore)))
+	stream("GET", "/books/events", chain(app.bookEventsHandler, cacheControl(cacheNoStore)))
+	api("GET", "/books/{id}", chain(app.showBookHandler, cacheControl(read)))
+	// (A GET pattern also matches HEAD requests, so HEAD /books/{id} lands in
+	// showBookHandler too. A separate "HEAD /books/{id}" pattern would clash
+	// with GET /books/count and friends, which the mux refuses to register.)
+	api("GET", "/books/{id}/related", chain(app.relatedBooksHandler, cacheControl(read)))
+	api("GET", "/books/{id}/reviews", chain(app.listReviewsHandler, cacheControl(read)))
+	api("GET", "/books/{id}/cover", chain(app.showCoverHandler, cacheControl(read)))
+	api("GET", "/authors", chain(app.listAuthorsHandler, cacheControl(read)))
+
+	// GET /books/isbn/{isbn} can't go on mux: it clashes with GET
+	// /books/{id}/reviews and friends (both match /books/isbn/reviews, and
+	// neither is more specific), so the mux would refuse to register it.
+	// It gets a mux of its own instead, which fallback tries when mux has no
+	// route. /books/isbn/reviews itself still goes to the reviews route, and
+	// gets a 404 there, but "reviews" isn't an ISBN anyway.
+	isbnMux := http.NewServeMux()
+	apiOn(isbnMux, "GET", "/books/isbn/{isbn}", chain(app.showBookByISBNHandler, limit, cacheControl(read)))
+
+	// Routes that change data need the API token (see authenticate)
+	api("POST", "/books", chain(app.createBookHandler, app.authenticate))
+	api("POST", "/books/import", chain(app.importBooksHandler, app.authenticate))
+	api("POST", "/books/batch", chain(app.createBooksBatchHandler, app.authenticate))
+	api("PUT", "/books/{id}", chain(app.putBookHandler, app.authenticate))
+	api("PUT", "/books/by-isbn/{isbn}", chain(app.upsertBookByISBNHandler, app.authenticate))
+	api("PATCH", "/books/{id}/year", chain(app.patchBookYearHandler, app.authenticate))
+	api("DELETE", "/books", chain(app.deleteBooksHandler, app.authenticate))
+	api("DELETE", "/books/{id}", chain(app.deleteBookHandler, app.authenticate))
+	api("POST", "/books/{id}/reviews", chain(app.createReviewHandler, app.authenticate))
+	api("POST", "/books/{id}/cover", chain(app.uploadCoverHandler, app.authenticate))
+
+	return chain(app.fallback(mux, isbnMux).ServeHTTP,
+		app.metrics,
+		app.serverHeader,
+		app.requestID,
+		app.logRequest,
+		app.responseTime,
+		app.recoverPanic,
+		app.enableCORS,
+		app.rateLimit,
+		app.compressResponse,
+		app.prometheusMetrics,
+	)
 }
 
+// healthcheckHandler is the combined health check. It reports whether the
+// database is reachable: "ok" (200) when it is, or "degraded" (503) when it
+// isn't.
+//
+// It only includes the app version in development, or with -show-version.
+// Anyone can call it, and knowing the exact version makes it easier for an
+// attacker to look up which known bugs to try.
 func (app *App) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+	status, code := "ok", http.StatusOK
+	if !app.databaseReady(r) {
+		status, code = "degraded", http.StatusServiceUnavailable
+	}
+
 	response := healthResponse{
-		Status:  "ok",
-		Version: version,
+		Status: status,
+	}
+	if app.Config.env == "development" || app.Config.showVersion {
+		response.Version = version
+	}
+
+	if err := app.writeResponse(w, r, code, response); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// livenessHandler answers "is the process up?". If we can respond at all,
+// the answer is yes, so it always returns 200. In Kubernetes this maps to a
+// liveness probe: failing it means the container gets restarted.
+func (app *App) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	if err := app.writeResponse(w, r, http.StatusOK, healthResponse{Status: "ok"}); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// readinessHandler answers "can we serve traffic right now?". We can't do much
+// without the database, so it returns 503 when the database can't be reached.
+// In Kubernetes this maps to a readiness probe: failing it takes the pod out
+// of the load balancer until it recovers, without restarting it.
+func (app *App) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	status, code := "ok", http.StatusOK
+	if !app.databaseReady(r) {
+		status, code = "unavailable", http.StatusServiceUnavailable
 	}
 
-	if err := writeJSON(w, http.StatusOK, response); err != nil {
+	if err := app.writeResponse(w, r, code, healthResponse{Status: status}); err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
 
+// databaseReady reports whether the database is reachable (see
+// BookStore.Healthy), logging a warning when it isn't.
+func (app *App) databaseReady(r *http.Request) bool {
+	if !app.Stores.Books.Healthy() {
+		app.requestLogger(r).Warn("database is unreachable")
+		return false
+	}
+	return true
+}
+
 func (app *App) listBooksHandler(w http.ResponseWriter, r *http.Request) {
-	books, err := app.Stores.Books.GetAll()
+	// Read the filters (?include_deleted, ?year_from, ?year_to, ?sort) from the query string
+	filters, filterErrors := parseBookFilters(r)
+	if filterErrors != nil {
+		app.failedValidationResponse(w, r, filterErrors)
+		return
+	}
+
+	// Clients can ask for just some fields with ?fields=id,title
+	fields, fieldErrors := parseFields(r)
+	if fieldErrors != nil {
+		app.failedValidationResponse(w, r, fieldErrors)
+		return
+	}
+
+	// Clients can fetch several books at once with ?ids=1,2,3
+	ids, idErrors := parseBookIDs(r)
+	if idErrors != nil {
+		app.failedValidationResponse(w, r, idErrors)
+		return
+	}
+	if ids != nil {
+		books, err := app.Stores.Books.GetByIDs(r.Context(), ids)
+		if err != nil {
+			app.handleStoreError(w, r, err)
+			return
+		}
+		app.writeBookList(w, r, books, fields, nil)
+		return
+	}
+
+	// Clients can page through the books with ?after_id=<last id seen>
+	afterID, pageSize, cursorMode, cursorErrors := app.parseCursor(r)
+	if cursorErrors != nil {
+		app.failedValidationResponse(w, r, cursorErrors)
+		return
+	}
+	// The cursor is the last ID seen, which only works when the books are in ID order
+	if cursorMode && filters.SortByViews {
+		app.failedValidationResponse(w, r, map[string]string{"sort": "sort=views can't be combined with after_id"})
+		return
+	}
+	filters.AfterID = afterID
+
+	// Tell caches when the catalog last changed, and answer 304 Not Modified
+	// if it hasn't changed since the If-Modified-Since the client sent.
+	//
+	// Not when sorting by views, though: counting a view doesn't touch
+	// updated_at, so the order can change while Last-Modified stays put.
+	if !filters.SortByViews {
+		if notModified, err := app.checkLastModified(w, r); err != nil {
+			app.handleStoreError(w, r, err)
+			return
+		} else if notModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	// ?format=ndjson streams one book per line instead of building one big array
+	if wantsNDJSON(r) {
+		app.streamBooksNDJSON(w, r, filters, fields)
+		return
+	}
+
+	// In cursor mode we ask for one more book than the page holds. If it
+	// comes back, we know there's another page after this one.
+	if cursorMode {
+		filters.Limit = pageSize + 1
+	}
+
+	books, err := app.Stores.Books.GetAll(r.Context(), filters)
 	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		app.handleStoreError(w, r, err)
 		return
 	}
 
-	resp := bookResponse{Books: books}
+	var metadata *cursorMetadata
+	if cursorMode {
+		metadata = &cursorMetadata{PageSize: pageSize}
+		if len(books) > pageSize {
+			books = books[:pageSize]
+			metadata.NextCursor = books[pageSize-1].ID
+		}
+	}
+
+	app.writeBookList(w, r, books, fields, metadata)
+}
 
-	// Write the books to the json response
-	if err := writeJSON(w, http.StatusOK, resp); err != nil {
+// writeBookList sends a list of books, trimmed down to fields when the client
+// asked for only some of them. metadata is only included in cursor mode.
+func (app *App) writeBookList(w http.ResponseWriter, r *http.Request, books []data.Book, fields []string, metadata *cursorMetadata) {
+	// A trimmed-down book is a map rather than a struct, and encoding/xml can't
+	// encode maps, so field selection always responds with JSON.
+	if fields != nil {
+		selected := make([]map[string]any, len(books))
+		for i := range books {
+			selected[i] = selectFields(&books[i], fields)
+		}
+		resp := envelope{"books": selected}
+		if metadata != nil {
+			resp["metadata"] = metadata
+		}
+		if err := app.writeJSON(w, http.StatusOK, resp); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := bookResponse{Books: books, Metadata: metadata}
+
+	// Write the books to the response (JSON, or XML if the client asked for it)
+	if err := app.writeResponse(w, r, http.StatusOK, resp); err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
 
 func (app *App) showBookHandler(w http.ResponseWriter, r *http.Request) {
+	// Clients can ask for just some fields with ?fields=id,title
+	fields, fieldErrors := parseFields(r)
+	if fieldErrors != nil {
+		app.failedValidationResponse(w, r, fieldErrors)
+		return
+	}
+
+	book, ok := app.findBook(w, r)
+	if !ok {
+		return
+	}
+
+	// HEAD /books/{id} gets the same status and headers as GET, but no body.
+	// It's a cheap way to check a book exists, or that a cached copy is still
+	// current, so we don't bother encoding a body that would be thrown away.
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Type", app.jsonContentType())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Count the view. It runs in the background so the client doesn't wait
+	// for the write, which is also why it uses its own context: the
+	// request's is cancelled as soon as the response has been sent. (A HEAD,
+	// or a 304 from findBook, doesn't count as a view.)
+	logger := app.requestLogger(r)
+	app.background(func() {
+		if err := app.Stores.Books.IncrementViews(context.Background(), book.ID); err != nil {
+			logger.Warn("failed to count book view", "id", book.ID, "error", err)
+		}
+	})
+
+	// Only the requested fields (always JSON, like the list handler)
+	if fields != nil {
+		if err := app.writeJSON(w, http.StatusOK, selectFields(book, fields)); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Write the response (JSON, or XML if the client asked for it)
+	if err := app.writeResponse(w, r, http.StatusOK, book); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// findBook does the part of GET and HEAD /books/{id} they have in common:
+// it looks up the book named in the path and sets its ETag header.
+//
+// If there's nothing more to do — the ID is invalid, the book doesn't exist,
+// or the client's cached copy (If-None-Match) is still current — findBook
+// writes the response itself and returns false.
+func (app *App) findBook(w http.ResponseWriter, r *http.Request) (*data.Book, bool) {
 	// Get the value of id
 	idString := r.PathValue("id")
 	// Convert to an int for the db lookup
@@ -78,23 +391,139 @@ func (app *App) showBookHandler(w http.ResponseWriter, r *http.Request) {
 	// Validate the id
 	if err != nil || id < 1 {
 		// Return not found if can't be validated
-		http.NotFound(w, r)
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	book, err := app.Stores.Books.Get(r.Context(), id)
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return nil, false
+	}
+
+	// Fingerprint the book so clients can cache it
+	etag, err := bookETag(book)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil, false
+	}
+	w.Header().Set("ETag", etag)
+
+	// If the client already has this exact version, tell it so without resending the body
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified) // 304
+		return nil, false
+	}
+
+	return book, true
+}
+
+// countBooksHandler returns how many books there are, as {"count": n}.
+// It accepts the same filters as listBooksHandler, so the count matches
+// what the equivalent list request would return.
+func (app *App) countBooksHandler(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Read the filters from the query string
+	filters, filterErrors := parseBookFilters(r)
+	if filterErrors != nil {
+		app.failedValidationResponse(w, r, filterErrors)
 		return
 	}
 
-	book, err := app.Stores.Books.Get(id)
+	// Step 2: Count the matching books
+	count, err := app.Stores.Books.Count(r.Context(), filters)
 	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			http.NotFound(w, r) // 404
-		default:
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		}
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	// Step 3: Return the total
+	if err := app.writeJSON(w, http.StatusOK, envelope{"count": count}); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// bookStatsHandler returns aggregate numbers about the catalog: how many
+// books there are, their earliest, latest and average year, and how many
+// different authors wrote them.
+func (app *App) bookStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := app.Stores.Books.Stats(r.Context())
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, stats); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// bookFacetsHandler returns how many books there are for each year and
+// each genre, e.g. {"by_year": {"2015": 1}, "by_genre": {"Programming": 1}}.
+func (app *App) bookFacetsHandler(w http.ResponseWriter, r *http.Request) {
+	facets, err := app.Stores.Books.Facets(r.Context())
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, facets); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// booksByDecadeHandler returns the books grouped by decade, for drawing a
+// timeline, e.g. {"2010s": [...], "unknown": [...]}. Books without a year
+// are in "unknown".
+func (app *App) booksByDecadeHandler(w http.ResponseWriter, r *http.Request) {
+	decades, err := app.Stores.Books.GroupByDecade(r.Context())
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, decades); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// relatedBooksLimit is how many books relatedBooksHandler suggests.
+const relatedBooksLimit = 5
+
+// relatedBooksHandler suggests other books for a "readers also liked"
+// list: books by the same author first, then the same genre or decade (see
+// BookStore.Related). An unknown book is a 404, but a book with nothing
+// related gets an empty list.
+func (app *App) relatedBooksHandler(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Parse the book ID from the route
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Step 2: Find the related books
+	books, err := app.Stores.Books.Related(r.Context(), id, relatedBooksLimit)
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	// Step 3: Write them (JSON, or XML if the client asked for it)
+	if err := app.writeResponse(w, r, http.StatusOK, bookResponse{Books: books}); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// randomBookHandler returns a random book, for a "surprise me" feature.
+// It's a 404 when there are no books to choose from.
+func (app *App) randomBookHandler(w http.ResponseWriter, r *http.Request) {
+	book, err := app.Stores.Books.GetRandom(r.Context())
+	if err != nil {
+		app.handleStoreError(w, r, err)
 		return
 	}
 
-	// Write the json response
-	if err := writeJSON(w, http.StatusOK, book); err != nil {
+	if err := app.writeResponse(w, r, http.StatusOK, book); err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
@@ -104,71 +533,106 @@ func (app *App) createBookHandler(w http.ResponseWriter, r *http.Request) {
 	var br request.FullBookRequest
 
 	// Step 2: Decode the request body into the br struct.
-	if err := json.NewDecoder(r.Body).Decode(&br); err != nil {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	if err := app.readJSON(w, r, &br); err != nil {
+		app.readJSONErrorResponse(w, r, err)
 		return
 	}
 
 	// Step 3: Validate the input data
 	validationErrors := request.ValidateFullBookRequest(&br)
 	if len(validationErrors) > 0 {
-		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"errors": validationErrors})
+		app.failedValidationResponse(w, r, validationErrors)
 		return
 	}
 
-	// Step 4: Create a Book struct with the validated data.
-	book := &data.Book{
-		Title:  br.Title,
-		Author: br.Author,
-		Year:   br.Year,
+	// Step 4: If this is a retry of a request we've already handled (the
+	// same Idempotency-Key), answer it again instead of creating another book.
+	// See idempotency.go.
+	key := r.Header.Get("Idempotency-Key")
+	var hash string
+	if key != "" {
+		var done bool
+		if hash, done = app.checkIdempotencyKey(w, r, key, br); done {
+			return
+		}
 	}
 
-	// Step 5: Save the book to the DB
-	savedBook, err := app.Stores.Books.Insert(book)
+	// Step 5: Create a Book struct with the validated data.
+	book := br.Book()
+
+	// Step 6: Save the book to the DB
+	savedBook, err := app.Stores.Books.Insert(r.Context(), &book)
 	if err != nil {
-		log.Printf("failed to insert book: %v", err)
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		app.handleStoreError(w, r, err)
 		return
 	}
 
-	// Step 6: Return the created book as JSON with a 201 Created status.
-	if err := writeJSON(w, http.StatusCreated, savedBook); err != nil {
+	// Step 7: Remember the response, so a retry with the same key gets it too.
+	if key != "" {
+		app.saveIdempotentBook(r, key, hash, http.StatusCreated, savedBook)
+	}
+
+	// Step 8: Let anyone watching GET /books/events know about it.
+	app.events.publish(*savedBook)
+
+	// Step 9: Return the created book with a 201 Created status (or just its
+	// Location, if the client prefers).
+	if err := app.writeCreatedBook(w, r, http.StatusCreated, savedBook); err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
 
+// putBookHandler replaces a book.
+//
+// The client must send the ETag it got from GET /books/{id} in an If-Match
+// header. If someone else has changed the book since then, the ETags no
+// longer match and we answer 412 Precondition Failed instead of quietly
+// overwriting their change (a "lost update"). Without If-Match it's a 428.
 func (app *App) putBookHandler(w http.ResponseWriter, r *http.Request) {
 	// Step 1: Parse the book ID from the route
 	idPath := r.PathValue("id")
 	id, err := strconv.ParseInt(idPath, 10, 64)
 	if err != nil || id < 1 {
-		http.NotFound(w, r)
+		app.notFoundResponse(w, r)
 		return
 	}
 
-	// Step 2: Decode the request body into a FullBookRequest
-	var br request.FullBookRequest
-	if err := json.NewDecoder(r.Body).Decode(&br); err != nil {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	// The client has to tell us which version of the book it's replacing
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		app.preconditionRequiredResponse(w, r)
 		return
 	}
 
-	// Step 3: Validate the input
-	validationErrors := request.ValidateFullBookRequest(&br)
+	// Step 2: Decode the request body into a PutBookRequest
+	var br request.PutBookRequest
+	if err := app.readJSON(w, r, &br); err != nil {
+		app.readJSONErrorResponse(w, r, err)
+		return
+	}
+
+	// Step 3: Validate the input, including that any id in the body is this book's
+	validationErrors := request.ValidatePutBookRequest(&br, id)
 	if len(validationErrors) > 0 {
-		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"errors": validationErrors})
+		app.failedValidationResponse(w, r, validationErrors)
 		return
 	}
 
 	// Step 4: Retrieve the existing book
-	book, err := app.Stores.Books.Get(id)
+	book, err := app.Stores.Books.Get(r.Context(), id)
 	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			http.NotFound(w, r)
-		default:
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		}
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	// ...and check it's still the version the client fetched
+	etag, err := bookETag(book)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if !etagMatches(ifMatch, etag) {
+		app.preconditionFailedResponse(w, r)
 		return
 	}
 
@@ -176,22 +640,182 @@ func (app *App) putBookHandler(w http.ResponseWriter, r *http.Request) {
 	book.Title = br.Title
 	book.Author = br.Author
 	book.Year = br.Year
+	book.Genre = br.Genre
 
 	// Step 6: Save the updated book to the DB
-	updatedBook, err := app.Stores.Books.Update(book)
+	updatedBook, err := app.Stores.Books.Update(r.Context(), book)
 	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			http.NotFound(w, r)
-		default:
-			log.Printf("failed to update book: %v", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		}
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	// Step 7: Return the updated book with a 200 OK status, and its new
+	// ETag, ready for the next update.
+	if etag, err := bookETag(updatedBook); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+	if err := app.writeResponse(w, r, http.StatusOK, updatedBook); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// patchBookYearHandler corrects just a book's year, e.g.
+//
+//	PATCH /books/1/year
+//	{"year": 2020}
+//
+// It's a narrow update for quick fixes, so unlike PUT it doesn't need the
+// whole book, or an If-Match.
+func (app *App) patchBookYearHandler(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Parse the book ID from the route
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Step 2: Decode and validate the new year
+	var yr request.YearRequest
+	if err := app.readJSON(w, r, &yr); err != nil {
+		app.readJSONErrorResponse(w, r, err)
+		return
+	}
+	if validationErrors := request.ValidateYearRequest(&yr); len(validationErrors) > 0 {
+		app.failedValidationResponse(w, r, validationErrors)
+		return
+	}
+
+	// Step 3: Save it
+	if err := app.Stores.Books.UpdateYear(r.Context(), id, *yr.Year); err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	// Step 4: Return the corrected book
+	book, err := app.Stores.Books.Get(r.Context(), id)
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+	if err := app.writeResponse(w, r, http.StatusOK, book); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+func (app *App) deleteBookHandler(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Parse the book ID from the route
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Step 2: Note where its cover is, if it has one, before it's deleted
+	coverPath, err := app.Stores.Books.GetCoverPath(r.Context(), id)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.requestLogger(r).Error("failed to look up cover", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	// Step 3: Soft-delete the book (it can be restored later)
+	if err := app.Stores.Books.Delete(r.Context(), id); err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	// Step 4: Remove the cover image. The book is already deleted, so if
+	// this fails we just log it rather than failing the request.
+	if coverPath != "" {
+		app.removeCover(r, id, coverPath)
+	}
+
+	// Step 5: Confirm the deletion with a 200 OK status.
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "book successfully deleted"}); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// deleteBooksHandler deletes several books at once, named with ?ids=, e.g.
+//
+//	DELETE /books?ids=1,2,3
+//
+// and responds with how many were deleted: {"deleted": 2}. IDs that don't
+// match a book are skipped, so the count can be lower than the number sent.
+// Like DELETE /books/{id} it's a soft delete, and each book's cover image is
+// removed.
+//
+// Without ?ids it's a request to clear the whole catalog, which is left to
+// deleteAllBooksHandler.
+func (app *App) deleteBooksHandler(w http.ResponseWriter, r *http.Request) {
+	if !r.URL.Query().Has("ids") {
+		app.deleteAllBooksHandler(w, r)
+		return
+	}
+
+	// Step 1: Read the IDs, at most maxBookIDs of them. An empty ?ids= is an
+	// error, not a request to delete nothing (or everything).
+	ids, idErrors := parseBookIDs(r)
+	if idErrors == nil && len(ids) == 0 {
+		idErrors = map[string]string{"ids": "ids must list at least one book ID"}
+	}
+	if idErrors != nil {
+		app.failedValidationResponse(w, r, idErrors)
+		return
+	}
+
+	// Step 2: Note where their covers are before they're deleted
+	coverPaths, err := app.Stores.Books.GetCoverPaths(r.Context(), ids)
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	// Step 3: Soft-delete the books, all together
+	deleted, err := app.Stores.Books.DeleteByIDs(r.Context(), ids)
+	if err != nil {
+		app.handleStoreError(w, r, err)
+		return
+	}
+
+	// Step 4: Remove the covers. As with a single delete, the books are gone
+	// by now, so a failure here is only logged.
+	for id, coverPath := range coverPaths {
+		app.removeCover(r, id, coverPath)
+	}
+
+	// Step 5: Report how many books were deleted
+	if err := app.writeJSON(w, http.StatusOK, envelope{"deleted": deleted}); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// deleteAllBooksHandler wipes the whole catalog, which is handy for resetting
+// a demo or test database. Because it can't be undone it's doubly guarded:
+// it only works in the development environment, and the client must confirm
+// with ?confirm=true.
+func (app *App) deleteAllBooksHandler(w http.ResponseWriter, r *http.Request) {
+	// Step 1: Refuse outright anywhere but development
+	if app.Config.env != "development" {
+		app.notPermittedResponse(w, r, "clearing the catalog is only allowed in development")
+		return
+	}
+
+	// Step 2: Make sure the client really meant it
+	if confirm, _ := strconv.ParseBool(r.URL.Query().Get("confirm")); !confirm {
+		app.errorResponse(w, r, http.StatusBadRequest, "add ?confirm=true to delete every book")
+		return
+	}
+
+	// Step 3: Delete everything
+	deleted, err := app.Stores.Books.DeleteAll(r.Context())
+	if err != nil {
+		app.handleStoreError(w, r, err)
 		return
 	}
 
-	// Step 7: Return the updated book as JSON with a 200 OK status.
-	if err := writeJSON(w, http.StatusOK, updatedBook); err != nil {
+	// Step 4: Report how many books were removed
+	if err := app.writeJSON(w, http.StatusOK, envelope{"deleted": deleted}); err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
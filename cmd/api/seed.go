@@ -0,0 +1,45 @@
+// File: cmd/api/seed.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/garyclarke/first-go-app/internal/data"
+	"github.com/garyclarke/first-go-app/internal/request"
+)
+
+// loadSeedFile reads demo books from a JSON file given with -seed-file, in
+// the same shape as POST /books takes:
+//
+//	[
+//	  {"title": "Dune", "author": "Frank Herbert", "year": 1965},
+//	  ...
+//	]
+//
+// Each entry goes through the same validation as an API request. Invalid
+// entries are logged and skipped, so one typo doesn't stop the app starting.
+// A file we can't read or parse at all is an error, though.
+func loadSeedFile(path string, logger *slog.Logger) ([]data.Book, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []request.FullBookRequest
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, fmt.Errorf("seed file %s: %w", path, err)
+	}
+
+	books := make([]data.Book, 0, len(entries))
+	for i := range entries {
+		if errs := request.ValidateFullBookRequest(&entries[i]); len(errs) > 0 {
+			logger.Warn("skipping invalid seed book", "file", path, "index", i, "errors", errs)
+			continue
+		}
+		books = append(books, entries[i].Book())
+	}
+	return books, nil
+}
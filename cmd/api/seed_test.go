@@ -0,0 +1,118 @@
+// File: cmd/api/seed_test.go
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/garyclarke/first-go-app/internal/data"
+)
+
+func TestLoadSeedFile(t *testing.T) {
+	// Two good books and two the validator should reject.
+	path := filepath.Join(t.TempDir(), "seed.json")
+	seed := `[
+  {"title": "Dune", "author": "Frank Herbert", "year": 1965},
+  {"title": "", "author": "Nobody"},
+  {"title": "Neuromancer", "author": "William Gibson"},
+  {"title": "Time Travel", "author": "H. G. Wells", "year": -5}
+]`
+	if err := os.WriteFile(path, []byte(seed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	books, err := loadSeedFile(path, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 2 {
+		t.Fatalf("want 2 valid books; got %d: %+v", len(books), books)
+	}
+	if books[0].Title != "Dune" || books[1].Title != "Neuromancer" {
+		t.Errorf("want Dune and Neuromancer; got %q and %q", books[0].Title, books[1].Title)
+	}
+
+	// Seeding them adds them alongside the built-in demo books, and seeding
+	// again doesn't duplicate anything.
+	app := setupTestApp(t)
+	db := app.Stores.Books.DB
+	for range 2 {
+		if err := data.SeedBooks(db, data.DriverSQLite, books); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := app.Stores.Books.GetAll(t.Context(), data.BookFilters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 {
+		t.Errorf("want 4 books after seeding; got %d", len(all))
+	}
+}
+
+func TestLoadSeedFile_Errors(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	if _, err := loadSeedFile(filepath.Join(t.TempDir(), "missing.json"), logger); err == nil {
+		t.Error("want an error for a missing file")
+	}
+
+	path := filepath.Join(t.TempDir(), "bad.json")
+	if err := os.WriteFile(path, []byte(`{"title": "not a list"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadSeedFile(path, logger); err == nil {
+		t.Error("want an error for a file that isn't a JSON array")
+	}
+}
+
+func TestSeedOnStartup(t *testing.T) {
+	for _, seed := range []bool{false, true} {
+		db, err := sql.Open("sqlite", ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			db.Close()
+		})
+		if err := data.Migrate(db, data.DriverSQLite); err != nil {
+			t.Fatal(err)
+		}
+
+		var logs bytes.Buffer
+		cfg := config{env: "production", seed: seed}
+		cfg.db.driver = data.DriverSQLite
+		if err := seedOnStartup(db, cfg, slog.New(slog.NewTextHandler(&logs, nil))); err != nil {
+			t.Fatal(err)
+		}
+
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM books`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+
+		// Without -seed the database stays empty, and the log says why
+		if !seed {
+			if n != 0 {
+				t.Errorf("-seed=false: want no books; got %d", n)
+			}
+			if !strings.Contains(logs.String(), "skipped seeding") {
+				t.Errorf("-seed=false: want the skip logged; got:\n%s", logs.String())
+			}
+			continue
+		}
+		if n == 0 {
+			t.Error("-seed=true: want the demo books")
+		}
+		if !strings.Contains(logs.String(), "seeded the database") {
+			t.Errorf("-seed=true: want the seeding logged; got:\n%s", logs.String())
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// File: cmd/api/server.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// serve starts srv and blocks until the server has shut down.
+//
+// When the process receives SIGINT (Ctrl+C) or SIGTERM (what Docker and
+// Kubernetes send), we shut down gracefully instead of just exiting:
+//
+//  1. srv.Shutdown stops accepting new connections and waits for the
+//     requests already in progress to finish.
+//  2. We tell background tasks to stop, by closing app.quit.
+//  3. We wait for the background tasks (see background) to return.
+//
+// Both waits share one deadline (-shutdown-timeout), so a stuck request or
+// task can't keep the process alive forever.
+func (app *App) serve(srv *http.Server) error {
+	shutdownError := make(chan error)
+
+	go func() {
+		// Block until one of the signals arrives.
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		app.Logger.Info("shutting down server", "signal", s.String())
+		shutdownError <- app.shutdown(srv)
+	}()
+
+	// Shutdown waits for open requests, but event streams (GET /books/events)
+	// never finish by themselves, so end them when shutdown starts.
+	srv.RegisterOnShutdown(app.events.close)
+
+	app.Logger.Info("starting server", "addr", srv.Addr, "env", app.Config.env)
+
+	// Once Shutdown is called, ListenAndServe returns http.ErrServerClosed
+	// straight away. That's expected, so it's only an error if it's anything else.
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	// Wait for the shutdown to finish (or fail) before returning.
+	if err := <-shutdownError; err != nil {
+		return err
+	}
+
+	app.Logger.Info("stopped server", "addr", srv.Addr)
+	return nil
+}
+
+// shutdown drains srv and then the background tasks, giving them
+// -shutdown-timeout between them.
+//
+// If requests are still running when the time is up, we stop waiting and
+// close their connections, so those clients see the connection drop rather
+// than a response. The error is returned either way, so the process exits
+// with a failure and the early close shows up in the logs.
+func (app *App) shutdown(srv *http.Server) error {
+	timeout := app.Config.server.shutdownTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			app.Logger.Warn("shutdown timed out, forcibly closing connections", "timeout", timeout.String())
+			srv.Close()
+		}
+		return err
+	}
+
+	close(app.quit)
+	app.Logger.Info("waiting for background tasks")
+	return app.waitForBackground(ctx)
+}
+
+// background runs fn in a new goroutine that the app keeps track of, so
+// shutdown can wait for it to finish.
+//
+// If fn panics, we recover and log the panic. A panic in a goroutine we
+// started isn't caught by net/http, and would otherwise crash the whole server.
+func (app *App) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if p := recover(); p != nil {
+				app.Logger.Error("background task panicked", "panic", fmt.Sprint(p))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// waitForBackground waits until every task started with background has
+// returned, or ctx is done, whichever comes first.
+func (app *App) waitForBackground(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for background tasks: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,87 @@
+// File: cmd/api/server_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdown_TimesOut(t *testing.T) {
+	var logs bytes.Buffer
+	app := &App{
+		Logger: slog.New(slog.NewTextHandler(&logs, nil)),
+		quit:   make(chan struct{}),
+	}
+	app.Config.server.shutdownTimeout = 50 * time.Millisecond
+
+	// Step 1: Start a server whose only request never finishes by itself
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	// Step 2: Shutdown gives up after -shutdown-timeout, not before
+	begin := time.Now()
+	err = app.shutdown(srv)
+	elapsed := time.Since(begin)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want a deadline exceeded error; got %v", err)
+	}
+	if elapsed < 50*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("want shutdown to wait about 50ms; took %s", elapsed)
+	}
+	if !strings.Contains(logs.String(), "forcibly closing connections") || !strings.Contains(logs.String(), "timeout=50ms") {
+		t.Errorf("want the forced close logged with its timeout; got %q", logs.String())
+	}
+}
+
+func TestShutdown_Graceful(t *testing.T) {
+	app := &App{
+		Logger: slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)),
+		quit:   make(chan struct{}),
+	}
+	app.Config.server.shutdownTimeout = time.Second
+
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+
+	if err := app.shutdown(srv); err != nil {
+		t.Fatalf("want a clean shutdown with nothing in flight; got %v", err)
+	}
+
+	// Background tasks are told to stop once the requests have drained
+	select {
+	case <-app.quit:
+	default:
+		t.Error("want app.quit closed after a clean shutdown")
+	}
+}
@@ -0,0 +1,60 @@
+// File: internal/data/authors.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// AuthorStore provides methods for working with the authors table.
+//
+// Each author's name is stored once, and books link to it with author_id.
+// That stops the same author turning up under several slightly different
+// spellings, and means a typo only has to be fixed in one place.
+type AuthorStore struct {
+	DB     *sql.DB
+	Driver string
+}
+
+// GetOrCreate returns the ID of the author with the given name, creating
+// the author first if they don't exist yet.
+func (s *AuthorStore) GetOrCreate(ctx context.Context, name string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	id, err := getOrCreateAuthor(ctx, s.DB, s.Driver, name)
+	if err != nil {
+		return 0, err
+	}
+	return id.Int64, nil
+}
+
+// rowQuerier is satisfied by both *sql.DB and *sql.Tx, so helpers can run
+// either on their own or as part of a larger transaction.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// getOrCreateAuthor looks up (or creates) the author called name and returns
+// their ID. An empty name means "no author", which is stored as NULL.
+//
+// It's an UPSERT: if the name already exists, ON CONFLICT turns the INSERT
+// into a no-op UPDATE, and RETURNING hands back the existing row's ID.
+// Doing it in one statement avoids a race between "look up" and "create".
+func getOrCreateAuthor(ctx context.Context, q rowQuerier, driver, name string) (sql.NullInt64, error) {
+	if name == "" {
+		return sql.NullInt64{}, nil
+	}
+
+	query := rebind(driver, `
+INSERT INTO authors (name) VALUES (?)
+ON CONFLICT (name) DO UPDATE SET name = excluded.name
+RETURNING id`)
+
+	var id int64
+	if err := q.QueryRowContext(ctx, query, name).Scan(&id); err != nil {
+		return sql.NullInt64{}, err
+	}
+	return sql.NullInt64{Int64: id, Valid: true}, nil
+}
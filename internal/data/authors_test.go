@@ -0,0 +1,57 @@
+// File: internal/data/authors_test.go
+package data
+
+import "testing"
+
+func TestAuthorStore_GetOrCreate(t *testing.T) {
+	db := openTestDB(t)
+	if err := Migrate(db, DriverSQLite); err != nil {
+		t.Fatal(err)
+	}
+	store := NewStores(db, Options{Driver: DriverSQLite}).Authors
+
+	// Asking for the same name twice should give back the same author
+	first, err := store.GetOrCreate(t.Context(), "Ursula K. Le Guin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.GetOrCreate(t.Context(), "Ursula K. Le Guin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("want the same id twice, got %d and %d", first, second)
+	}
+}
+
+func TestBookStore_InsertLinksAuthor(t *testing.T) {
+	store := newTestBookStore(t)
+
+	// Step 1: Insert two books by the same author
+	for _, title := range []string{"A Wizard of Earthsea", "The Tombs of Atuan"} {
+		if _, err := store.Insert(t.Context(), &Book{Title: title, Author: "Ursula K. Le Guin", Year: intPtr(1970)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Step 2: There should only be one author row, and both books should point at it
+	var authors, linked int
+	if err := store.DB.QueryRow(`SELECT COUNT(*) FROM authors`).Scan(&authors); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DB.QueryRow(`SELECT COUNT(*) FROM books WHERE author_id IS NOT NULL`).Scan(&linked); err != nil {
+		t.Fatal(err)
+	}
+	if authors != 1 || linked != 2 {
+		t.Errorf("want 1 author and 2 linked books, got %d and %d", authors, linked)
+	}
+
+	// Step 3: Reading a book back should still give the author's name
+	book, err := store.Get(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.Author != "Ursula K. Le Guin" {
+		t.Errorf("want author %q, got %q", "Ursula K. Le Guin", book.Author)
+	}
+}
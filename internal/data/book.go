@@ -2,9 +2,202 @@
 
 package data
 
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Book is a single book in our catalog.
+// The `xml` struct tags control how it's encoded in XML responses, and
+// XMLName makes the XML element <book>. JSON responses are written by
+// MarshalJSON; the `json` tags describe the same keys, for decoding.
+//
+// CreatedAt and UpdatedAt are set by the database, never by clients.
+// DeletedAt is nil unless the book has been (soft) deleted.
+// AverageRating and ReviewCount summarise the book's reviews; both are 0
+// when it hasn't been reviewed yet. Views counts how often it's been fetched
+// with GET /books/{id} (see BookStore.IncrementViews).
+//
+// Year is a pointer so we can tell "we don't know the year" (nil, stored as
+// NULL and left out of responses) apart from an actual year.
 type Book struct {
-	ID     int64  `json:"id"`
-	Title  string `json:"title"`
-	Author string `json:"author,omitempty"`
-	Year   int    `json:"year,omitempty"`
+	XMLName   xml.Name   `json:"-" xml:"book"`
+	ID        int64      `json:"id" xml:"id"`
+	Title     string     `json:"title" xml:"title"`
+	Author    string     `json:"author,omitempty" xml:"author,omitempty"`
+	Year      *int       `json:"year,omitempty" xml:"year,omitempty"`
+	Genre     string     `json:"genre,omitempty" xml:"genre,omitempty"`
+	ISBN      string     `json:"isbn,omitempty" xml:"isbn,omitempty"`
+	CreatedAt time.Time  `json:"created_at" xml:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" xml:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`
+
+	AverageRating float64 `json:"average_rating" xml:"average_rating"`
+	ReviewCount   int     `json:"review_count" xml:"review_count"`
+	Views         int64   `json:"views" xml:"views"`
+}
+
+// MarshalJSON writes a book as JSON. It's the one place that decides what a
+// book looks like to JSON clients:
+//
+//   - every key is snake_case, in the order below;
+//   - year is left out when we don't know it (nil, or a 0 that only an old
+//     row from before validation could have);
+//   - author, genre, isbn and deleted_at are left out when empty;
+//   - timestamps are in UTC, in RFC 3339 format (e.g. "2024-05-01T09:30:00Z");
+//   - everything else is always there, even when it's zero.
+//
+// The database stores timestamps in UTC, but drivers may hand them back in
+// the server's local time zone (pgx does, for TIMESTAMPTZ columns). Without
+// the conversion, the same book could come out as "...T10:30:00+01:00" on
+// one server and "...T09:30:00Z" on another.
+//
+// It has a value receiver, so Book and *Book are written the same way.
+func (b Book) MarshalJSON() ([]byte, error) {
+	// bookJSON lists the keys in the order they're written. Being a separate
+	// type, it doesn't have a MarshalJSON method, so json.Marshal doesn't
+	// call back into this one.
+	type bookJSON struct {
+		ID            int64      `json:"id"`
+		Title         string     `json:"title"`
+		Author        string     `json:"author,omitempty"`
+		Year          *int       `json:"year,omitempty"`
+		Genre         string     `json:"genre,omitempty"`
+		ISBN          string     `json:"isbn,omitempty"`
+		CreatedAt     time.Time  `json:"created_at"`
+		UpdatedAt     time.Time  `json:"updated_at"`
+		DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+		AverageRating float64    `json:"average_rating"`
+		ReviewCount   int        `json:"review_count"`
+		Views         int64      `json:"views"`
+	}
+
+	year := b.Year
+	if year != nil && *year == 0 {
+		year = nil
+	}
+
+	b = b.UTC()
+
+	return json.Marshal(bookJSON{
+		ID:            b.ID,
+		Title:         b.Title,
+		Author:        b.Author,
+		Year:          year,
+		Genre:         b.Genre,
+		ISBN:          b.ISBN,
+		CreatedAt:     b.CreatedAt,
+		UpdatedAt:     b.UpdatedAt,
+		DeletedAt:     b.DeletedAt,
+		AverageRating: b.AverageRating,
+		ReviewCount:   b.ReviewCount,
+		Views:         b.Views,
+	})
+}
+
+// UTC returns a copy of the book with its timestamps converted to UTC (see
+// MarshalJSON for why). Anything else that writes a book's timestamps, like
+// the ?fields= responses, should go through it too.
+func (b Book) UTC() Book {
+	b.CreatedAt = b.CreatedAt.UTC()
+	b.UpdatedAt = b.UpdatedAt.UTC()
+	if b.DeletedAt != nil {
+		t := b.DeletedAt.UTC()
+		b.DeletedAt = &t
+	}
+	return b
+}
+
+// BookFilters narrows down which books GetAll returns.
+// The zero value means "every book that hasn't been deleted".
+type BookFilters struct {
+	// IncludeDeleted also returns soft-deleted books.
+	IncludeDeleted bool
+
+	// AfterID only returns books with an ID greater than it. It's the cursor
+	// for cursor-based pagination: pass the last ID of one page to get the next.
+	AfterID int64
+
+	// Limit caps how many books GetAll returns. 0 means no limit.
+	Limit int
+
+	// YearFrom and YearTo only return books published in that range
+	// (inclusive). 0 leaves that end of the range open.
+	YearFrom int
+	YearTo   int
+
+	// SortByViews orders the books most viewed first (ties in ID order),
+	// rather than just by ID. It can't be combined with AfterID, which
+	// relies on the books being in ID order.
+	SortByViews bool
+}
+
+// BookStats summarises the (undeleted) books in the catalog.
+// The year fields are nil when no book has a year, including when there are
+// no books at all; they're null in JSON rather than a misleading 0.
+type BookStats struct {
+	Total           int      `json:"total"`
+	EarliestYear    *int     `json:"earliest_year"`
+	LatestYear      *int     `json:"latest_year"`
+	AverageYear     *float64 `json:"average_year"`
+	DistinctAuthors int      `json:"distinct_authors"`
+}
+
+// BookFacets counts the (undeleted) books per year and per genre, for a
+// faceted search page ("2015 (3)", "Programming (12)", ...). Books without a
+// year or genre aren't counted in that facet. The keys are strings because
+// JSON object keys have to be.
+type BookFacets struct {
+	ByYear  map[string]int `json:"by_year"`
+	ByGenre map[string]int `json:"by_genre"`
+}
+
+// intPtr returns a pointer to n, handy for filling in Book.Year.
+func intPtr(n int) *int {
+	return &n
+}
+
+// Validate checks the book's own rules — the things that must be true of any
+// book, however it reaches us (a JSON body, a CSV import, a sync by ISBN...).
+// It returns a map of field name to message; the map is empty when the book
+// is valid.
+func (b Book) Validate() map[string]string {
+	errors := make(map[string]string)
+
+	// A book must have a title
+	if msg := checkRequiredText("title", b.Title); msg != "" {
+		errors["title"] = msg
+	}
+
+	// ...and an author
+	if msg := checkRequiredText("author", b.Author); msg != "" {
+		errors["author"] = msg
+	}
+
+	// The year is optional, but if we know it, it must be positive
+	if b.Year != nil && *b.Year < 1 {
+		errors["year"] = "year must be a positive integer"
+	}
+
+	return errors
+}
+
+// checkRequiredText checks a required text field, returning what's wrong
+// with it, or "" if nothing is.
+//
+// Whitespace alone doesn't count as a value. Control characters (tabs,
+// newlines, null bytes...) aren't allowed anywhere: they tend to sneak in
+// with copy-pasted or imported data, and only cause trouble later on.
+func checkRequiredText(field, value string) string {
+	switch {
+	case strings.TrimSpace(value) == "":
+		return field + " is required"
+	case strings.ContainsFunc(value, unicode.IsControl):
+		return field + " must not contain control characters"
+	default:
+		return ""
+	}
 }
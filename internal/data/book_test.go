@@ -0,0 +1,131 @@
+// File: internal/data/book_test.go
+package data
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBook_JSONYearKey guards the year's JSON key: clients (and
+// request.FullBookRequest) expect a lowercase "year", not Go's default "Year".
+func TestBook_JSONYearKey(t *testing.T) {
+	b, err := json.Marshal(Book{Title: "Go", Year: intPtr(2015)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(fields["year"]); got != "2015" {
+		t.Errorf(`want "year": 2015; got %s`, b)
+	}
+	if _, ok := fields["Year"]; ok {
+		t.Errorf(`want no capitalised "Year" key; got %s`, b)
+	}
+}
+
+func TestBook_MarshalJSON(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	updated := created.Add(time.Hour)
+
+	tests := []struct {
+		name string
+		book Book
+		want string
+	}{
+		{
+			name: "complete book",
+			book: Book{
+				ID:            1,
+				Title:         "The Go Programming Language",
+				Author:        "Alan Donovan",
+				Year:          intPtr(2015),
+				Genre:         "Programming",
+				ISBN:          "9780134190440",
+				CreatedAt:     created,
+				UpdatedAt:     updated,
+				DeletedAt:     &updated,
+				AverageRating: 4.5,
+				ReviewCount:   2,
+				Views:         7,
+			},
+			want: `{"id":1,"title":"The Go Programming Language","author":"Alan Donovan","year":2015,` +
+				`"genre":"Programming","isbn":"9780134190440",` +
+				`"created_at":"2024-01-02T03:04:05Z","updated_at":"2024-01-02T04:04:05Z",` +
+				`"deleted_at":"2024-01-02T04:04:05Z","average_rating":4.5,"review_count":2,"views":7}`,
+		},
+		{
+			name: "minimal book",
+			book: Book{ID: 2, Title: "Untitled Draft", CreatedAt: created, UpdatedAt: created},
+			want: `{"id":2,"title":"Untitled Draft",` +
+				`"created_at":"2024-01-02T03:04:05Z","updated_at":"2024-01-02T03:04:05Z",` +
+				`"average_rating":0,"review_count":0,"views":0}`,
+		},
+		{
+			name: "zero year",
+			book: Book{ID: 3, Title: "Old Row", Year: intPtr(0), CreatedAt: created, UpdatedAt: created},
+			want: `{"id":3,"title":"Old Row",` +
+				`"created_at":"2024-01-02T03:04:05Z","updated_at":"2024-01-02T03:04:05Z",` +
+				`"average_rating":0,"review_count":0,"views":0}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Both a Book and a *Book should go through MarshalJSON
+			for _, v := range []any{tt.book, &tt.book} {
+				got, err := json.Marshal(v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != tt.want {
+					t.Errorf("want %s\n got %s", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+// TestMarshalJSON_TimestampsInUTC checks timestamps come out in UTC whatever
+// time zone the driver gave them to us in.
+func TestMarshalJSON_TimestampsInUTC(t *testing.T) {
+	// 10:30 in UTC+1 is 09:30 UTC
+	local := time.Date(2024, 5, 1, 10, 30, 0, 0, time.FixedZone("UTC+1", 60*60))
+
+	for name, v := range map[string]any{
+		"book":   Book{ID: 1, Title: "Local Time", CreatedAt: local, UpdatedAt: local, DeletedAt: &local},
+		"review": Review{ID: 1, BookID: 1, Rating: 5, Body: "Great", CreatedAt: local},
+	} {
+		t.Run(name, func(t *testing.T) {
+			b, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, key := range []string{"created_at", "updated_at", "deleted_at"} {
+				if _, ok := got[key]; !ok && name == "review" {
+					continue
+				}
+				s, _ := got[key].(string)
+				if !strings.HasSuffix(s, "Z") {
+					t.Errorf("%s: want a UTC timestamp ending in Z; got %q", key, s)
+				}
+				parsed, err := time.Parse(time.RFC3339, s)
+				if err != nil {
+					t.Errorf("%s: want RFC 3339; got %q (%v)", key, s, err)
+				}
+				if !parsed.Equal(local) {
+					t.Errorf("%s: want %v; got %v", key, local, parsed)
+				}
+			}
+		})
+	}
+}
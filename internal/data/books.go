@@ -4,28 +4,249 @@ package data
 import (
 	"context"
 	"database/sql"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
 	"time"
 )
 
 // BookStore wraps a sql.DB connection pool.
 // It provides methods for working with books in the database
 // (for example, fetching all books or looking up a book by ID).
+//
+// Driver records which database we're talking to (DriverSQLite or
+// DriverPostgres) so queries can be translated into the right dialect.
+//
+// MaxRetries and RetryDelay control how write methods retry when SQLite
+// reports the database is busy (see withRetry in retry.go).
+//
+// SQLLogger, when set, logs every statement the store runs (see logSQL).
+// It's nil unless the app was started with -debug-sql.
+//
+// SlowQueryThreshold, when above zero, makes any statement that takes at
+// least that long log a warning to Logger (or slog's default logger if
+// Logger is nil), whether or not SQLLogger is set. Insert also notes each
+// new book there, at debug level.
+//
+// Cache, when set, holds on to GetAll's results (see BookCache). It's nil
+// unless the app was started with -cache-enabled.
 type BookStore struct {
-	DB *sql.DB
+	DB         *sql.DB
+	Driver     string
+	MaxRetries int
+	RetryDelay time.Duration
+	SQLLogger  *slog.Logger
+
+	SlowQueryThreshold time.Duration
+	Logger             *slog.Logger
+
+	Cache *BookCache
+}
+
+// query, queryRow and exec are thin wrappers around the matching *sql.DB
+// methods. They translate our ? placeholders for the current driver, so the
+// rest of the store can be written once for every database, and log the
+// statement when SQL logging is switched on.
+func (s *BookStore) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query = rebind(s.Driver, query)
+	defer s.logSQL(query, args, time.Now())
+	return s.DB.QueryContext(ctx, query, args...)
+}
+
+func (s *BookStore) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	query = rebind(s.Driver, query)
+	defer s.logSQL(query, args, time.Now())
+	return s.DB.QueryRowContext(ctx, query, args...)
+}
+
+func (s *BookStore) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query = rebind(s.Driver, query)
+	defer s.logSQL(query, args, time.Now())
+	return s.DB.ExecContext(ctx, query, args...)
+}
+
+// txQueryRow and txExec do the same inside a transaction (see inTx).
+// Their queries are expected to be rebound already.
+func (s *BookStore) txQueryRow(ctx context.Context, tx *sql.Tx, query string, args ...any) *sql.Row {
+	defer s.logSQL(query, args, time.Now())
+	return tx.QueryRowContext(ctx, query, args...)
+}
+
+func (s *BookStore) txExec(ctx context.Context, tx *sql.Tx, query string, args ...any) (sql.Result, error) {
+	defer s.logSQL(query, args, time.Now())
+	return tx.ExecContext(ctx, query, args...)
 }
 
-func (s *BookStore) GetAll() ([]Book, error) {
-	// Define the SQL query to fetch all books, ordered by ID
-	query := `SELECT id, title, author, year FROM books ORDER BY id`
+// logSQL logs a statement, its arguments and how long it took since start.
+// It does nothing when SQLLogger is nil, which keeps production logs quiet.
+//
+// Slow statements are the exception: one that took SlowQueryThreshold or
+// longer is always logged as a warning (without its arguments, which may
+// hold user data). A statement that's slow every time usually means a
+// missing index, or a loop running one query per row (an "N+1").
+//
+// It's meant to be deferred, so time.Now() is evaluated when the statement
+// starts and the duration covers running it.
+func (s *BookStore) logSQL(query string, args []any, start time.Time) {
+	duration := time.Since(start)
+	// One line, however the query was laid out.
+	query = strings.Join(strings.Fields(query), " ")
+
+	if s.SlowQueryThreshold > 0 && duration >= s.SlowQueryThreshold {
+		s.logger().Warn("slow query", "query", query, "duration", duration, "threshold", s.SlowQueryThreshold)
+	}
+
+	if s.SQLLogger == nil {
+		return
+	}
+	s.SQLLogger.Info("sql",
+		"query", query,
+		"args", args,
+		"duration", duration,
+	)
+}
+
+// logger returns Logger, or slog's default logger if it's nil.
+func (s *BookStore) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
+
+// bookColumns lists the columns every book query selects, in the order
+// scanBook reads them. Keeping them in one place means adding a column
+// only needs changing here and in scanBook.
+//
+// The author's name comes from the authors table (a), and the rating summary
+// from the reviews aggregate (r), both joined in by bookTables. Books without
+// an author or any reviews have no matching row, so COALESCE turns those
+// NULLs into an empty string or 0.
+const bookColumns = `b.id, b.title, COALESCE(a.name, ''), b.year, COALESCE(b.genre, ''), COALESCE(b.isbn, ''), b.created_at, b.updated_at, b.deleted_at,
+COALESCE(r.average_rating, 0), COALESCE(r.review_count, 0), b.views`
+
+// bookTables is the FROM clause for reading books along with their author
+// and review summary. They're LEFT JOINs so that books without an author or
+// reviews are still returned.
+//
+// The reviews are summarised per book in a subquery, so the outer query
+// stays one row per book and doesn't need a GROUP BY of its own. AVG is cast
+// because PostgreSQL would otherwise return a NUMERIC, not a float.
+const bookTables = `books b
+LEFT JOIN authors a ON a.id = b.author_id
+LEFT JOIN (
+  SELECT book_id, CAST(AVG(rating) AS DOUBLE PRECISION) AS average_rating, COUNT(rating) AS review_count
+  FROM reviews
+  GROUP BY book_id
+) r ON r.book_id = b.id`
 
-	// Create a context with a 3-second timeout to prevent long-running queries
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// bookWhere is the WHERE clause shared by GetAll and StreamAll, and
+// bookWhereArgs returns its arguments for a set of filters.
+//
+// Each condition is written so that the filter's zero value switches it off,
+// e.g. (b.year >= ? OR ? = 0) is always true when YearFrom is 0. That keeps
+// it one fixed query, with no SQL built up from strings at runtime.
+const bookWhere = `(? OR b.deleted_at IS NULL)
+  AND b.id > ?
+  AND (b.year >= ? OR ? = 0)
+  AND (b.year <= ? OR ? = 0)`
+
+func bookWhereArgs(filters BookFilters) []any {
+	return []any{
+		filters.IncludeDeleted,
+		filters.AfterID,
+		filters.YearFrom, filters.YearFrom,
+		filters.YearTo, filters.YearTo,
+	}
+}
+
+// scanner is anything with a Scan method — both *sql.Row and *sql.Rows qualify.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanBook copies the columns listed in bookColumns into b.
+//
+// year can be NULL (we don't know when the book was published), so we scan
+// it into a sql.NullInt64 first and only set b.Year when there's a value.
+func scanBook(sc scanner, b *Book) error {
+	var year sql.NullInt64
+	err := sc.Scan(&b.ID, &b.Title, &b.Author, &year, &b.Genre, &b.ISBN, &b.CreatedAt, &b.UpdatedAt, &b.DeletedAt,
+		&b.AverageRating, &b.ReviewCount, &b.Views)
+	if err != nil {
+		return err
+	}
+
+	b.Year = nil
+	if year.Valid {
+		y := int(year.Int64)
+		b.Year = &y
+	}
+	return nil
+}
+
+// nullYear converts a book's year into the value we store: NULL when the
+// year is unknown.
+func nullYear(year *int) sql.NullInt64 {
+	if year == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*year), Valid: true}
+}
+
+// GetAll returns the books matching filters, ordered by ID.
+// Soft-deleted books are left out unless filters.IncludeDeleted is set.
+//
+// With a Cache, a recent result for the same filters is returned without
+// asking the database. Errors are never cached, so the next call tries again.
+func (s *BookStore) GetAll(ctx context.Context, filters BookFilters) ([]Book, error) {
+	books, ok, gen := s.Cache.get(filters)
+	if ok {
+		return books, nil
+	}
+
+	books, err := s.getAll(ctx, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Cache.put(filters, books, gen)
+	return books, nil
+}
+
+// getAll is GetAll without the cache: it always runs the query.
+func (s *BookStore) getAll(ctx context.Context, filters BookFilters) ([]Book, error) {
+	// Define the SQL query to fetch the matching books, ordered by ID.
+	// bookWhere applies the filters: for example (? OR deleted_at IS NULL)
+	// always passes when IncludeDeleted is true, and every ID is greater
+	// than 0, so AfterID's zero value doesn't filter anything.
+	//
+	// The ORDER BY follows the same idea: with SortByViews off, the CASE is
+	// 0 for every book, which leaves them in ID order.
+	query := `SELECT ` + bookColumns + ` FROM ` + bookTables + `
+WHERE ` + bookWhere + `
+ORDER BY CASE WHEN ? THEN b.views ELSE 0 END DESC, b.id`
+	args := append(bookWhereArgs(filters), filters.SortByViews)
+
+	// Because we order by ID and filter with id > AfterID, the database can
+	// jump straight to the right place in the primary key index. Unlike
+	// OFFSET, it doesn't have to read and throw away every earlier row, and
+	// rows added or removed on earlier pages don't shift the results.
+	if filters.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, filters.Limit)
+	}
+
+	// Give the query at most 3 seconds. The timeout is added to the caller's
+	// context, so the query is also cancelled if that ends first (say, the
+	// client hung up, or the request timed out).
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	// Ensure the context is cleaned up when this function exits (defer)
 	defer cancel()
 
 	// Execute the query using the context (will timeout after 3 seconds if not done)
-	rows, err := s.DB.QueryContext(ctx, query)
+	rows, err := s.query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -40,7 +261,7 @@ func (s *BookStore) GetAll() ([]Book, error) {
 		// Create a new Book struct for this row
 		var b Book
 		// Scan the row's columns into the Book struct fields
-		if err := rows.Scan(&b.ID, &b.Title, &b.Author, &b.Year); err != nil {
+		if err := scanBook(rows, &b); err != nil {
 			return nil, err
 		}
 		// Add this book to our books slice
@@ -56,75 +277,924 @@ func (s *BookStore) GetAll() ([]Book, error) {
 	return books, nil
 }
 
-func (s *BookStore) Get(id int64) (*Book, error) {
+// GetByIDs returns the (undeleted) books with the given IDs, ordered by ID.
+// IDs that don't match a book are simply left out of the result.
+//
+// The IN list needs one placeholder per ID, so we build "?, ?, ?" from the
+// length of ids. Only the placeholders go into the SQL string; the IDs
+// themselves are still passed as arguments, so there's no risk of injection.
+func (s *BookStore) GetByIDs(ctx context.Context, ids []int64) ([]Book, error) {
+	if len(ids) == 0 {
+		return []Book{}, nil
+	}
+
+	query := `SELECT ` + bookColumns + ` FROM ` + bookTables + `
+WHERE b.deleted_at IS NULL AND b.id IN (` + placeholders(len(ids)) + `)
+ORDER BY b.id`
+
+	args := idArgs(ids)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := []Book{}
+	for rows.Next() {
+		var b Book
+		if err := scanBook(rows, &b); err != nil {
+			return nil, err
+		}
+		books = append(books, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return books, nil
+}
+
+// StreamAll calls fn once for every book matching filters, in the same
+// order as GetAll, as each row is read from the database. filters.Limit is
+// ignored.
+//
+// Unlike GetAll, it never holds the whole catalog in memory, which makes it
+// a good fit for exports. If fn returns an error we stop and return it.
+func (s *BookStore) StreamAll(ctx context.Context, filters BookFilters, fn func(*Book) error) error {
+	query := `SELECT ` + bookColumns + ` FROM ` + bookTables + `
+WHERE ` + bookWhere + `
+ORDER BY CASE WHEN ? THEN b.views ELSE 0 END DESC, b.id`
+
+	// Exports can take a while for a big catalog, so we allow longer than
+	// the usual 3 seconds.
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	rows, err := s.query(ctx, query, append(bookWhereArgs(filters), filters.SortByViews)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b Book
+		if err := scanBook(rows, &b); err != nil {
+			return err
+		}
+		if err := fn(&b); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (s *BookStore) Get(ctx context.Context, id int64) (*Book, error) {
 	// In SQLite, auto-incremented IDs start at 1.
 	// To avoid making a pointless database query,
-	// we immediately return sql.ErrNoRows if the ID is less than 1.
+	// we immediately return ErrRecordNotFound if the ID is less than 1.
 	if id < 1 {
-		// we reuse sql.ErrNoRows, which is exactly what the database driver gives us when a query finds nothing.
-		// That way our handler only needs one simple check: was the error sql.ErrNoRows? If yes, return 404
-		return nil, sql.ErrNoRows
+		// This is the same error we return when the query finds nothing,
+		// so our handler only needs one simple check: was the error ErrRecordNotFound? If yes, return 404
+		return nil, ErrRecordNotFound
 	}
 
-	query := `SELECT id, title, author, year FROM books WHERE id = ?`
+	// Soft-deleted books are treated as if they don't exist.
+	query := `SELECT ` + bookColumns + ` FROM ` + bookTables + ` WHERE b.id = ? AND b.deleted_at IS NULL`
 
 	// timeout context
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// Declare a Book struct to hold the data returned by the query.
 	var book Book
 
-	// Query and scan into book
-	err := s.DB.QueryRowContext(ctx, query, id).Scan(
-		&book.ID,
-		&book.Title,
-		&book.Author,
-		&book.Year,
-	)
+	// Query and scan into book. No row means there's no such book,
+	// which we report with our own ErrRecordNotFound.
+	err := scanBook(s.queryRow(ctx, query, id), &book)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
 		return nil, err
 	}
 
 	return &book, nil
 }
 
-func (s *BookStore) Insert(book *Book) (*Book, error) {
-	// query
-	query := `INSERT INTO books (title, author, year) VALUES (?, ?, ?)`
-	// timeout context
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// GetByISBN returns the (undeleted) book with the given ISBN, or
+// ErrRecordNotFound if there isn't one. The ISBN has to be in the form we
+// store it in, without hyphens or spaces (see request.NormalizeISBN).
+//
+// isbn has a unique index, so this is a single index lookup, just like Get.
+func (s *BookStore) GetByISBN(ctx context.Context, isbn string) (*Book, error) {
+	if isbn == "" {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + bookColumns + ` FROM ` + bookTables + ` WHERE b.isbn = ? AND b.deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
-	// execute query
-	res, err := s.DB.ExecContext(ctx, query, book.Title, book.Author, book.Year)
+
+	var book Book
+	err := scanBook(s.queryRow(ctx, query, isbn), &book)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
 		return nil, err
 	}
-	// get the id
-	id, err := res.LastInsertId()
+
+	return &book, nil
+}
+
+func (s *BookStore) Insert(ctx context.Context, book *Book) (*Book, error) {
+	// query
+	// RETURNING hands us the new row's ID (and the timestamps the database
+	// filled in) straight back from the INSERT. We use it instead of
+	// res.LastInsertId() because the PostgreSQL driver doesn't support LastInsertId.
+	query := rebind(s.Driver, `
+INSERT INTO books (title, author, author_id, year, genre) VALUES (?, ?, ?, ?, NULLIF(?, ''))
+RETURNING id, created_at, updated_at`)
+	// timeout context
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	// Look up (or create) the author and insert the book in one transaction,
+	// retrying if the database is temporarily locked
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		authorID, err := getOrCreateAuthor(ctx, tx, s.Driver, book.Author)
+		if err != nil {
+			return err
+		}
+		// execute query and scan the id straight onto the book
+		return s.txQueryRow(ctx, tx, query, book.Title, book.Author, authorID, nullYear(book.Year), book.Genre).
+			Scan(&book.ID, &book.CreatedAt, &book.UpdatedAt)
+	})
 	if err != nil {
+		// The unique (title, author) index rejects a book we already have
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateBook
+		}
 		return nil, err
 	}
-	// set id on book
-	book.ID = id
 
-	log.Printf("Inserted book: %+v", book)
+	s.logger().Debug("inserted book", "id", book.ID, "title", book.Title, "author", book.Author)
 	// return the book
 	return book, nil
 }
 
-func (s *BookStore) Update(book *Book) (*Book, error) {
-	query := `UPDATE books SET title = ?, author = ?, year = ? WHERE id = ?`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// InsertMany inserts several books in a single transaction, so either all
+// of the inserts are saved or none of them are.
+//
+// A book that already exists (same title and author) is skipped rather than
+// failing the whole batch: its ID is left as 0 so the caller can tell it apart.
+// It returns how many books were actually inserted.
+func (s *BookStore) InsertMany(ctx context.Context, books []*Book) (int, error) {
+	return s.insertMany(ctx, books, false)
+}
+
+// PreviewInsertMany does everything InsertMany does, but rolls the
+// transaction back at the end instead of committing it, so nothing is saved.
+// It returns how many books would have been inserted, and leaves the IDs of
+// duplicates as 0 just like InsertMany. (The other books' IDs are the ones
+// they'd have had, but no longer exist.)
+//
+// Going through the real inserts, rather than checking for duplicates with
+// separate queries, means the preview catches exactly what InsertMany would,
+// including two copies of the same book in one batch.
+func (s *BookStore) PreviewInsertMany(ctx context.Context, books []*Book) (int, error) {
+	return s.insertMany(ctx, books, true)
+}
+
+// errRollback is returned inside a transaction to roll it back on purpose.
+var errRollback = errors.New("rollback")
+
+// insertMany is InsertMany, or PreviewInsertMany when dryRun is set.
+func (s *BookStore) insertMany(ctx context.Context, books []*Book, dryRun bool) (int, error) {
+	// ON CONFLICT DO NOTHING skips duplicates; RETURNING id then returns no
+	// row for them, which Scan reports as sql.ErrNoRows.
+	query := rebind(s.Driver, `
+INSERT INTO books (title, author, author_id, year, genre) VALUES (?, ?, ?, ?, NULLIF(?, ''))
+ON CONFLICT DO NOTHING
+RETURNING id, created_at, updated_at`)
+
+	// A bulk import does more work than a single insert, so allow a bit longer.
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	inserted := 0
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		inserted = 0
+
+		for _, book := range books {
+			book.ID = 0
+
+			authorID, err := getOrCreateAuthor(ctx, tx, s.Driver, book.Author)
+			if err != nil {
+				return err
+			}
+
+			err = s.txQueryRow(ctx, tx, query, book.Title, book.Author, authorID, nullYear(book.Year), book.Genre).
+				Scan(&book.ID, &book.CreatedAt, &book.UpdatedAt)
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				// duplicate: skipped, ID stays 0
+			case err != nil:
+				return err
+			default:
+				inserted++
+			}
+		}
+
+		if dryRun {
+			return errRollback
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errRollback) {
+		return 0, err
+	}
+
+	return inserted, nil
+}
+
+// Count returns how many books match filters. It uses the same WHERE clause
+// as GetAll, so a count always agrees with the matching list.
+// (filters.Limit doesn't apply to a count.)
+func (s *BookStore) Count(ctx context.Context, filters BookFilters) (int, error) {
+	query := `SELECT COUNT(*) FROM books b WHERE ` + bookWhere
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	res, err := s.DB.ExecContext(ctx, query, book.Title, book.Author, book.Year, book.ID)
+	var count int
+	if err := s.queryRow(ctx, query, bookWhereArgs(filters)...).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// LastModified returns when the catalog last changed: the newest updated_at
+// of any book (deleted ones included, since a delete bumps updated_at too),
+// or the newest review, whichever is later. It returns the zero time when
+// there's nothing in the catalog.
+func (s *BookStore) LastModified(ctx context.Context) (time.Time, error) {
+	query := `
+SELECT MAX(t) FROM (
+  SELECT MAX(updated_at) AS t FROM books
+  UNION ALL
+  SELECT MAX(created_at) AS t FROM reviews
+) AS m`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	// PostgreSQL gives us a time.Time, but SQLite returns MAX() of a
+	// timestamp column as plain text, so we scan into an any and convert.
+	var t any
+	if err := s.queryRow(ctx, query).Scan(&t); err != nil {
+		return time.Time{}, err
+	}
+
+	switch v := t.(type) {
+	case nil:
+		return time.Time{}, nil
+	case time.Time:
+		return v.UTC(), nil
+	case string:
+		return time.ParseInLocation(time.DateTime, v, time.UTC)
+	case []byte:
+		return time.ParseInLocation(time.DateTime, string(v), time.UTC)
+	default:
+		return time.Time{}, fmt.Errorf("unexpected last modified type %T", t)
+	}
+}
+
+// Stats works out BookStats in a single aggregate query. MIN, MAX and AVG
+// ignore books without a year, and give NULL when there's nothing to go on.
+//
+// AVG returns a REAL in SQLite but a NUMERIC in PostgreSQL, so we CAST it to
+// DOUBLE PRECISION, which both databases scan into a float64.
+func (s *BookStore) Stats(ctx context.Context) (BookStats, error) {
+	query := `
+SELECT COUNT(*), MIN(year), MAX(year), CAST(AVG(year) AS DOUBLE PRECISION), COUNT(DISTINCT author)
+FROM books
+WHERE deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var (
+		stats            BookStats
+		earliest, latest sql.NullInt64
+		average          sql.NullFloat64
+	)
+	err := s.queryRow(ctx, query).Scan(&stats.Total, &earliest, &latest, &average, &stats.DistinctAuthors)
+	if err != nil {
+		return BookStats{}, err
+	}
+
+	if earliest.Valid {
+		stats.EarliestYear = intPtr(int(earliest.Int64))
+	}
+	if latest.Valid {
+		stats.LatestYear = intPtr(int(latest.Int64))
+	}
+	if average.Valid {
+		stats.AverageYear = &average.Float64
+	}
+	return stats, nil
+}
+
+// Facets works out BookFacets with one GROUP BY query per facet.
+func (s *BookStore) Facets(ctx context.Context) (BookFacets, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	facets := BookFacets{ByYear: map[string]int{}, ByGenre: map[string]int{}}
+
+	// CAST the year to text so both facets can be read the same way
+	queries := []struct {
+		query  string
+		counts map[string]int
+	}{
+		{`SELECT CAST(year AS TEXT), COUNT(*) FROM books WHERE year IS NOT NULL AND deleted_at IS NULL GROUP BY year`, facets.ByYear},
+		{`SELECT genre, COUNT(*) FROM books WHERE genre IS NOT NULL AND genre <> '' AND deleted_at IS NULL GROUP BY genre`, facets.ByGenre},
+	}
+
+	for _, q := range queries {
+		if err := s.countGroups(ctx, q.query, q.counts); err != nil {
+			return BookFacets{}, err
+		}
+	}
+	return facets, nil
+}
+
+// GroupByDecade returns the (undeleted) books grouped by the decade they
+// were published in, keyed like "1990s" and "2010s". The decade is
+// (year / 10) * 10, so 2015 and 2019 are both in "2010s". Books without a
+// year (or with a 0 from before validation) go under "unknown".
+//
+// Within a decade the books are in ID order. Decades with no books aren't
+// in the map at all, so an empty catalog gives an empty map.
+//
+// The grouping is done here rather than in SQL: it needs every book anyway,
+// and it goes through GetAll, so it benefits from the cache.
+func (s *BookStore) GroupByDecade(ctx context.Context) (map[string][]Book, error) {
+	books, err := s.GetAll(ctx, BookFilters{})
 	if err != nil {
 		return nil, err
 	}
-	rows, _ := res.RowsAffected()
-	if rows == 0 {
-		return nil, sql.ErrNoRows
+
+	decades := make(map[string][]Book)
+	for _, b := range books {
+		key := "unknown"
+		if b.Year != nil && *b.Year != 0 {
+			key = fmt.Sprintf("%ds", (*b.Year/10)*10)
+		}
+		decades[key] = append(decades[key], b)
+	}
+	return decades, nil
+}
+
+// Related returns up to limit other (undeleted) books that a reader of book
+// id might also like. Books by the same author come first; if there aren't
+// enough of those, the rest are filled from books in the same genre, then
+// from the same decade. Within each group the books are in ID order.
+//
+// An unknown book is ErrRecordNotFound. A book with nothing related gives
+// an empty slice, not an error.
+//
+// As in bookWhere, each condition is switched off by its zero value, so a
+// book without an author, genre or year simply doesn't match on it.
+func (s *BookStore) Related(ctx context.Context, id int64, limit int) ([]Book, error) {
+	book, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Integer division puts 2015 and 2019 in the same decade, 201
+	var decade int
+	if book.Year != nil {
+		decade = *book.Year / 10
+	}
+
+	query := `SELECT ` + bookColumns + ` FROM ` + bookTables + `
+WHERE b.deleted_at IS NULL AND b.id <> ?
+  AND ((a.name = ? AND ? <> '')
+    OR (b.genre = ? AND ? <> '')
+    OR (b.year / 10 = ? AND ? <> 0))
+ORDER BY CASE
+    WHEN a.name = ? AND ? <> '' THEN 0
+    WHEN b.genre = ? AND ? <> '' THEN 1
+    ELSE 2
+  END, b.id
+LIMIT ?`
+	args := []any{
+		id,
+		book.Author, book.Author,
+		book.Genre, book.Genre,
+		decade, decade,
+		book.Author, book.Author,
+		book.Genre, book.Genre,
+		limit,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := []Book{}
+	for rows.Next() {
+		var b Book
+		if err := scanBook(rows, &b); err != nil {
+			return nil, err
+		}
+		books = append(books, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return books, nil
+}
+
+// countGroups runs a "SELECT key, COUNT(*) ... GROUP BY key" query and
+// stores each row in counts.
+func (s *BookStore) countGroups(ctx context.Context, query string, counts map[string]int) error {
+	rows, err := s.query(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var count int
+		if err := rows.Scan(&key, &count); err != nil {
+			return err
+		}
+		counts[key] = count
+	}
+	return rows.Err()
+}
+
+// Authors returns the names of everyone who wrote an (undeleted) book, each
+// name once, in alphabetical order. Books without an author are ignored.
+//
+// To page through them, pass the last name of the previous page as after
+// (or "" to start at the beginning) and the page size as limit. A limit of
+// 0 returns every author.
+func (s *BookStore) Authors(ctx context.Context, after string, limit int) ([]string, error) {
+	query := `SELECT DISTINCT author FROM books
+WHERE author IS NOT NULL AND author <> '' AND author > ? AND deleted_at IS NULL
+ORDER BY author`
+	args := []any{after}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	authors := []string{}
+	for rows.Next() {
+		var author string
+		if err := rows.Scan(&author); err != nil {
+			return nil, err
+		}
+		authors = append(authors, author)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return authors, nil
+}
+
+// GetRandom returns a random (undeleted) book, or ErrRecordNotFound if
+// there aren't any.
+//
+// ORDER BY RANDOM() shuffles every row just to keep one, which is fine for a
+// catalog our size but would get slow on a very large table.
+func (s *BookStore) GetRandom(ctx context.Context) (*Book, error) {
+	query := `SELECT ` + bookColumns + ` FROM ` + bookTables + `
+WHERE b.deleted_at IS NULL
+ORDER BY RANDOM()
+LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var book Book
+	err := scanBook(s.queryRow(ctx, query), &book)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &book, nil
+}
+
+// Exists reports whether there's an (undeleted) book with the given ID.
+// It's cheaper than Get when we only need to know the book is there,
+// because the database can stop at the first matching index entry.
+func (s *BookStore) Exists(ctx context.Context, id int64) (bool, error) {
+	if id < 1 {
+		return false, nil
+	}
+
+	query := `SELECT EXISTS(SELECT 1 FROM books WHERE id = ? AND deleted_at IS NULL)`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	if err := s.queryRow(ctx, query, id).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (s *BookStore) Update(ctx context.Context, book *Book) (*Book, error) {
+	// updated_at is bumped to "now" on every update. RETURNING gives us the
+	// stored timestamps back; if no row matched the ID, there's nothing to
+	// return and Scan reports sql.ErrNoRows, which we turn into ErrRecordNotFound.
+	query := rebind(s.Driver, `
+UPDATE books SET title = ?, author = ?, author_id = ?, year = ?, genre = NULLIF(?, ''), updated_at = CURRENT_TIMESTAMP
+WHERE id = ? AND deleted_at IS NULL
+RETURNING created_at, updated_at`)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		authorID, err := getOrCreateAuthor(ctx, tx, s.Driver, book.Author)
+		if err != nil {
+			return err
+		}
+		return s.txQueryRow(ctx, tx, query, book.Title, book.Author, authorID, nullYear(book.Year), book.Genre, book.ID).
+			Scan(&book.CreatedAt, &book.UpdatedAt)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		case isUniqueViolation(err):
+			// renaming the book to match another one by the same author
+			return nil, ErrDuplicateBook
+		default:
+			return nil, err
+		}
 	}
 	return book, nil
 }
+
+// UpdateYear sets just the year of a book, leaving everything else as it is.
+// It returns ErrRecordNotFound if there's no (undeleted) book with that ID.
+func (s *BookStore) UpdateYear(ctx context.Context, id int64, year int) error {
+	query := `UPDATE books SET year = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL`
+	return s.execOne(ctx, query, year, id)
+}
+
+// UpsertByISBN creates or updates the book with b.ISBN, and reports whether
+// it was newly created. This suits syncing from another system, where the
+// ISBN is the shared key and the caller doesn't know (or care) about our IDs.
+//
+// The INSERT ... ON CONFLICT (isbn) DO UPDATE does the create-or-update in a
+// single statement. It also clears deleted_at, so syncing a book that was
+// soft-deleted brings it back.
+func (s *BookStore) UpsertByISBN(ctx context.Context, b Book) (Book, bool, error) {
+	// Look for an existing book first, purely so we can tell the caller
+	// whether this was a create or an update.
+	existsQuery := rebind(s.Driver, `SELECT EXISTS(SELECT 1 FROM books WHERE isbn = ?)`)
+	upsertQuery := rebind(s.Driver, `
+INSERT INTO books (title, author, author_id, year, genre, isbn) VALUES (?, ?, ?, ?, NULLIF(?, ''), ?)
+ON CONFLICT (isbn) DO UPDATE SET
+  title      = excluded.title,
+  author     = excluded.author,
+  author_id  = excluded.author_id,
+  year       = excluded.year,
+  genre      = excluded.genre,
+  updated_at = CURRENT_TIMESTAMP,
+  deleted_at = NULL
+RETURNING id`)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var existed bool
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if err := s.txQueryRow(ctx, tx, existsQuery, b.ISBN).Scan(&existed); err != nil {
+			return err
+		}
+
+		authorID, err := getOrCreateAuthor(ctx, tx, s.Driver, b.Author)
+		if err != nil {
+			return err
+		}
+
+		return s.txQueryRow(ctx, tx, upsertQuery, b.Title, b.Author, authorID, nullYear(b.Year), b.Genre, b.ISBN).Scan(&b.ID)
+	})
+	if err != nil {
+		// A different book (another ISBN) already has this title and author
+		if isUniqueViolation(err) {
+			return Book{}, false, ErrDuplicateBook
+		}
+		return Book{}, false, err
+	}
+
+	// Read the book back so the caller gets every field, including the
+	// timestamps and review summary.
+	saved, err := s.Get(ctx, b.ID)
+	if err != nil {
+		return Book{}, false, err
+	}
+	return *saved, !existed, nil
+}
+
+// IncrementViews adds one to a book's view count.
+//
+// The database does the adding (views = views + 1), so two requests viewing
+// the book at the same moment both count; reading the count, adding one in
+// Go and writing it back could lose one of them.
+//
+// A view isn't a change to the book: updated_at stays as it is, and the
+// cached book lists aren't cleared, so their view counts may be up to
+// -cache-ttl behind. Clearing the cache on every view would mean it was
+// hardly ever used.
+//
+// It returns ErrRecordNotFound if there's no (undeleted) book with that ID.
+func (s *BookStore) IncrementViews(ctx context.Context, id int64) error {
+	query := `UPDATE books SET views = views + 1 WHERE id = ? AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var res sql.Result
+	err := withRetry(s.MaxRetries, s.RetryDelay, func() error {
+		var err error
+		res, err = s.exec(ctx, query, id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Delete soft-deletes a book: rather than removing the row, it stamps
+// deleted_at with the current time. The book then disappears from Get and
+// GetAll, but can be brought back with Restore. Deleting counts as a change,
+// so updated_at moves on too (LastModified relies on this).
+//
+// It returns ErrRecordNotFound if there's no (undeleted) book with that ID.
+func (s *BookStore) Delete(ctx context.Context, id int64) error {
+	query := `UPDATE books SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL`
+	return s.execOne(ctx, query, id)
+}
+
+// DeleteByIDs soft-deletes every book with one of the given IDs, like
+// calling Delete for each, and returns how many books were deleted. IDs that
+// don't match an undeleted book are skipped rather than being an error, so
+// the count can be less than len(ids).
+//
+// As in GetByIDs, only the "?, ?, ?" placeholders are built from the length
+// of ids; the IDs themselves are passed as arguments. It's one statement,
+// run in a transaction so that either every book goes or none do.
+func (s *BookStore) DeleteByIDs(ctx context.Context, ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	query := deleteByIDsQuery(s.Driver, len(ids))
+	args := idArgs(ids)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var deleted int64
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := s.txExec(ctx, tx, query, args...)
+		if err != nil {
+			return err
+		}
+		deleted, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// deleteByIDsQuery builds DeleteByIDs' statement for n IDs. It runs with
+// txExec, which doesn't rebind, so we do it here for the driver.
+func deleteByIDsQuery(driver string, n int) string {
+	return rebind(driver, `UPDATE books SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+WHERE deleted_at IS NULL AND id IN (`+placeholders(n)+`)`)
+}
+
+// Restore undoes a soft delete, making the book visible again.
+//
+// It returns ErrRecordNotFound if there's no deleted book with that ID, and
+// ErrDuplicateBook if another book with the same title and author has been
+// created since it was deleted.
+func (s *BookStore) Restore(ctx context.Context, id int64) error {
+	query := `UPDATE books SET deleted_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NOT NULL`
+	err := s.execOne(ctx, query, id)
+	if isUniqueViolation(err) {
+		return ErrDuplicateBook
+	}
+	return err
+}
+
+// execOne runs a write that should affect exactly one row, retrying if the
+// database is busy. If no rows were affected it returns ErrRecordNotFound.
+func (s *BookStore) execOne(ctx context.Context, query string, args ...any) error {
+	defer s.Cache.Invalidate()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var res sql.Result
+	err := withRetry(s.MaxRetries, s.RetryDelay, func() error {
+		var err error
+		res, err = s.exec(ctx, query, args...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetCoverPath returns where the book's cover image is stored, relative to
+// the covers directory. It returns ErrRecordNotFound if there's no such
+// (undeleted) book, or if the book doesn't have a cover.
+func (s *BookStore) GetCoverPath(ctx context.Context, id int64) (string, error) {
+	query := `SELECT cover_path FROM books WHERE id = ? AND deleted_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var path sql.NullString
+	err := s.queryRow(ctx, query, id).Scan(&path)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return "", ErrRecordNotFound
+	case err != nil:
+		return "", err
+	case !path.Valid:
+		return "", ErrRecordNotFound
+	}
+
+	return path.String, nil
+}
+
+// GetCoverPaths is GetCoverPath for several books at once, in one query. It
+// returns a map from book ID to cover path, holding only the (undeleted)
+// books that have a cover; other IDs are simply left out.
+func (s *BookStore) GetCoverPaths(ctx context.Context, ids []int64) (map[int64]string, error) {
+	paths := make(map[int64]string)
+	if len(ids) == 0 {
+		return paths, nil
+	}
+
+	query := `SELECT id, cover_path FROM books
+WHERE deleted_at IS NULL AND cover_path IS NOT NULL AND id IN (` + placeholders(len(ids)) + `)`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.query(ctx, query, idArgs(ids)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var path string
+		if err := rows.Scan(&id, &path); err != nil {
+			return nil, err
+		}
+		paths[id] = path
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
+
+// SetCoverPath records where the book's cover image is stored. An empty path
+// clears it. Unlike most updates this also works on deleted books, so their
+// covers can be cleaned up after the book is deleted.
+func (s *BookStore) SetCoverPath(ctx context.Context, id int64, path string) error {
+	query := `UPDATE books SET cover_path = NULLIF(?, '') WHERE id = ?`
+	return s.execOne(ctx, query, path, id)
+}
+
+// DeleteAll permanently removes every book (deleted or not) along with their
+// reviews, and returns how many books were removed. It's meant for resetting
+// test and demo databases, not for normal use.
+//
+// The reviews are deleted explicitly rather than relying on ON DELETE CASCADE,
+// because SQLite only enforces foreign keys when they're switched on.
+func (s *BookStore) DeleteAll(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var deleted int64
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := s.txExec(ctx, tx, `DELETE FROM reviews`); err != nil {
+			return err
+		}
+
+		res, err := s.txExec(ctx, tx, `DELETE FROM books`)
+		if err != nil {
+			return err
+		}
+		deleted, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// Healthy reports whether the database can be reached right now.
+//
+// database/sql throws away connections that break and opens new ones as
+// needed, so pinging is also how we reconnect: if the SQLite file was only
+// missing for a moment (while being copied, say), the ping opens a fresh
+// connection and Healthy returns true again. We give up after a second so a
+// hung database can't hang the caller too.
+func (s *BookStore) Healthy() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	return s.DB.PingContext(ctx) == nil
+}
+
+// inTx runs fn inside a transaction, committing if it succeeds and rolling
+// back if it returns an error. If the database is busy, the whole
+// transaction is retried (see withRetry).
+//
+// Only writes use transactions, so this is also where the cached GetAll
+// results are thrown away (execOne does the same for single statements).
+func (s *BookStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	defer s.Cache.Invalidate()
+
+	return withRetry(s.MaxRetries, s.RetryDelay, func() error {
+		tx, err := s.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		// Rollback is a no-op once the transaction has been committed.
+		defer tx.Rollback()
+
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
@@ -0,0 +1,695 @@
+// File: internal/data/books_test.go
+package data
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestBookStore returns a BookStore backed by a migrated, empty in-memory database.
+func newTestBookStore(t *testing.T) *BookStore {
+	t.Helper()
+
+	db := openTestDB(t)
+	if err := Migrate(db, DriverSQLite); err != nil {
+		t.Fatal(err)
+	}
+
+	stores := NewStores(db, Options{Driver: DriverSQLite})
+	return &stores.Books
+}
+
+func TestBookStore_UpdateSetsUpdatedAt(t *testing.T) {
+	store := newTestBookStore(t)
+
+	// Step 1: Insert a book
+	book, err := store.Insert(t.Context(), &Book{Title: "Timestamps", Author: "Gary Clarke", Year: intPtr(2024)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Step 2: Pretend it was created an hour ago. CURRENT_TIMESTAMP only has
+	// one-second precision, so without this an immediate update could land in
+	// the same second as the insert.
+	_, err = store.DB.Exec(`UPDATE books SET created_at = datetime('now', '-1 hour'), updated_at = datetime('now', '-1 hour') WHERE id = ?`, book.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Step 3: Update it
+	book.Year = intPtr(2025)
+	if _, err := store.Update(t.Context(), book); err != nil {
+		t.Fatal(err)
+	}
+
+	// Step 4: Re-read it and check updated_at has moved on past created_at
+	stored, err := store.Get(t.Context(), book.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.UpdatedAt.After(stored.CreatedAt) {
+		t.Errorf("want updated_at (%v) after created_at (%v)", stored.UpdatedAt, stored.CreatedAt)
+	}
+}
+
+func TestBookStore_ExistsAndNotFound(t *testing.T) {
+	store := newTestBookStore(t)
+
+	book, err := store.Insert(t.Context(), &Book{Title: "Here", Author: "Gary Clarke", Year: intPtr(2024)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Exists should find the book we just added, but not one that isn't there
+	if exists, err := store.Exists(t.Context(), book.ID); err != nil || !exists {
+		t.Errorf("want book %d to exist; got %v (err %v)", book.ID, exists, err)
+	}
+	if exists, err := store.Exists(t.Context(), 999); err != nil || exists {
+		t.Errorf("want book 999 not to exist; got %v (err %v)", exists, err)
+	}
+
+	// Updating or deleting a missing book reports ErrRecordNotFound
+	if _, err := store.Update(t.Context(), &Book{ID: 999, Title: "Missing", Author: "Nobody", Year: intPtr(2024)}); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Update: want ErrRecordNotFound; got %v", err)
+	}
+	if err := store.Delete(t.Context(), 999); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Delete: want ErrRecordNotFound; got %v", err)
+	}
+
+	// A soft-deleted book no longer exists as far as Exists is concerned
+	if err := store.Delete(t.Context(), book.ID); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := store.Exists(t.Context(), book.ID); exists {
+		t.Errorf("want deleted book %d not to exist", book.ID)
+	}
+}
+
+func TestBook_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		book     Book
+		wantKeys []string
+	}{
+		{name: "valid", book: Book{Title: "Go", Author: "Gary Clarke", Year: intPtr(2024)}},
+		{name: "empty", book: Book{}, wantKeys: []string{"title", "author"}}, // an unknown year is fine
+		{name: "zero year", book: Book{Title: "Go", Author: "Gary Clarke", Year: intPtr(0)}, wantKeys: []string{"year"}},
+		{name: "negative year", book: Book{Title: "Go", Author: "Gary Clarke", Year: intPtr(-1)}, wantKeys: []string{"year"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := tc.book.Validate()
+			if len(errs) != len(tc.wantKeys) {
+				t.Fatalf("got %d errors (%v), want %d", len(errs), errs, len(tc.wantKeys))
+			}
+			for _, key := range tc.wantKeys {
+				if _, ok := errs[key]; !ok {
+					t.Errorf("expected an error for %s", key)
+				}
+			}
+		})
+	}
+}
+
+func TestBookStore_Year(t *testing.T) {
+	store := newTestBookStore(t)
+
+	tests := []struct {
+		name string
+		year *int
+	}{
+		{name: "known year", year: intPtr(1999)},
+		{name: "unknown year", year: nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Step 1: Insert a book with (or without) a year
+			book, err := store.Insert(t.Context(), &Book{Title: tc.name, Author: "Gary Clarke", Year: tc.year})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Step 2: Read it back and check the year survived the round trip
+			stored, err := store.Get(t.Context(), book.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tc.year == nil && stored.Year != nil:
+				t.Errorf("want an unknown (nil) year; got %d", *stored.Year)
+			case tc.year != nil && (stored.Year == nil || *stored.Year != *tc.year):
+				t.Errorf("want year %d; got %v", *tc.year, stored.Year)
+			}
+		})
+	}
+}
+
+func TestBookStore_LastModified(t *testing.T) {
+	store := newTestBookStore(t)
+
+	// Step 1: An empty catalog has no last modified time
+	got, err := store.LastModified(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsZero() {
+		t.Errorf("want the zero time for an empty catalog; got %v", got)
+	}
+
+	// Step 2: Backdate a book, and LastModified reports its updated_at
+	book, err := store.Insert(t.Context(), &Book{Title: "Old", Author: "Gary Clarke"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.DB.Exec(`UPDATE books SET updated_at = '2020-01-02 03:04:05' WHERE id = ?`, book.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err = store.LastModified(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("want %v; got %v", want, got)
+	}
+
+	// Step 3: Deleting the book counts as a change
+	if err := store.Delete(t.Context(), book.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err = store.LastModified(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.After(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("want LastModified to move on after a delete; got %v", got)
+	}
+}
+
+func TestBookStore_UpdateYear(t *testing.T) {
+	store := newTestBookStore(t)
+
+	book, err := store.Insert(t.Context(), &Book{Title: "Typo", Author: "Gary Clarke", Year: intPtr(2102)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Step 1: Correct the year, then read the book back
+	if err := store.UpdateYear(t.Context(), book.ID, 2012); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Get(t.Context(), book.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Year == nil || *got.Year != 2012 {
+		t.Errorf("want year 2012; got %v", got.Year)
+	}
+	if got.Title != "Typo" || got.Author != "Gary Clarke" {
+		t.Errorf("want the rest of the book unchanged; got %+v", got)
+	}
+
+	// Step 2: An unknown ID is reported as not found
+	if err := store.UpdateYear(t.Context(), 9999, 2012); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("want ErrRecordNotFound; got %v", err)
+	}
+}
+
+func TestBookStore_SQLLogger(t *testing.T) {
+	store := newTestBookStore(t)
+
+	// Step 1: Send the store's SQL log to a buffer
+	var buf bytes.Buffer
+	store.SQLLogger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	// Step 2: Run a write (inside a transaction) and a read
+	book, err := store.Insert(t.Context(), &Book{Title: "Logged", Author: "Gary Clarke"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(t.Context(), book.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Step 3: Both statements were logged, with their arguments and duration
+	out := buf.String()
+	for _, want := range []string{"INSERT INTO books", "SELECT b.id", "Logged", "duration="} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want %q in the SQL log; got:\n%s", want, out)
+		}
+	}
+}
+
+func TestBookStore_SlowQueryWarning(t *testing.T) {
+	store := newTestBookStore(t)
+	var buf bytes.Buffer
+	store.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	// Step 1: Under a generous threshold, nothing is slow
+	store.SlowQueryThreshold = time.Hour
+	if _, err := store.Count(t.Context(), BookFilters{}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() > 0 {
+		t.Errorf("want no warnings; got:\n%s", buf.String())
+	}
+
+	// Step 2: With a threshold no query can beat, every query is slow
+	store.SlowQueryThreshold = time.Nanosecond
+	if _, err := store.Count(t.Context(), BookFilters{}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{"level=WARN", "slow query", "SELECT COUNT(*)", "threshold=1ns"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want %q in the log; got:\n%s", want, out)
+		}
+	}
+}
+
+func TestBookStore_Healthy(t *testing.T) {
+	store := newTestBookStore(t)
+
+	if !store.Healthy() {
+		t.Fatal("want a freshly opened database to be healthy")
+	}
+
+	// A closed pool can't reach the database any more
+	store.DB.Close()
+	if store.Healthy() {
+		t.Error("want a closed database to be unhealthy")
+	}
+}
+
+func TestBookStore_Stats(t *testing.T) {
+	store := newTestBookStore(t)
+
+	// Step 1: An empty catalog gives zeros and nil years
+	stats, err := store.Stats(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stats, BookStats{}) {
+		t.Errorf("want empty stats; got %+v", stats)
+	}
+
+	// Step 2: Add known books. The one without a year still counts towards
+	// the total and authors, and the deleted one doesn't count at all.
+	books := []*Book{
+		{Title: "A", Author: "Ann", Year: intPtr(1990)},
+		{Title: "B", Author: "Ann", Year: intPtr(2000)},
+		{Title: "C", Author: "Bob", Year: intPtr(2020)},
+		{Title: "D", Author: "Cat"},
+		{Title: "E", Author: "Dan", Year: intPtr(1800)},
+	}
+	var last *Book
+	for _, b := range books {
+		if last, err = store.Insert(t.Context(), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete(t.Context(), last.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err = store.Stats(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	avg := (1990.0 + 2000 + 2020) / 3
+	want := BookStats{
+		Total:           4,
+		EarliestYear:    intPtr(1990),
+		LatestYear:      intPtr(2020),
+		AverageYear:     &avg,
+		DistinctAuthors: 3,
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("want %+v; got %+v", want, stats)
+	}
+}
+
+func TestBookStore_Authors(t *testing.T) {
+	store := newTestBookStore(t)
+
+	// Ann wrote two books, one book has no author, and Dan's only book is deleted
+	books := []*Book{
+		{Title: "A", Author: "Ann"},
+		{Title: "B", Author: "Bob"},
+		{Title: "C", Author: "Ann"},
+		{Title: "D", Author: ""},
+		{Title: "E", Author: "Dan"},
+	}
+	var last *Book
+	var err error
+	for _, b := range books {
+		if last, err = store.Insert(t.Context(), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete(t.Context(), last.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.Authors(t.Context(), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Ann", "Bob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q; got %q", want, got)
+	}
+
+	// Paging: one author after Ann
+	got, err = store.Authors(t.Context(), "Ann", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Bob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q; got %q", want, got)
+	}
+}
+
+func TestBookStore_Facets(t *testing.T) {
+	store := newTestBookStore(t)
+
+	// Step 1: An empty catalog gives empty maps (not nil, so JSON shows {})
+	facets, err := store.Facets(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (BookFacets{ByYear: map[string]int{}, ByGenre: map[string]int{}}); !reflect.DeepEqual(facets, want) {
+		t.Errorf("want empty facets; got %+v", facets)
+	}
+
+	// Step 2: Books without a year or genre are left out of that facet
+	books := []*Book{
+		{Title: "A", Author: "Ann", Year: intPtr(2015), Genre: "Programming"},
+		{Title: "B", Author: "Ann", Year: intPtr(2015), Genre: "Databases"},
+		{Title: "C", Author: "Bob", Year: intPtr(2017), Genre: "Programming"},
+		{Title: "D", Author: "Cat"},
+	}
+	for _, b := range books {
+		if _, err := store.Insert(t.Context(), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	facets, err = store.Facets(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := BookFacets{
+		ByYear:  map[string]int{"2015": 2, "2017": 1},
+		ByGenre: map[string]int{"Programming": 2, "Databases": 1},
+	}
+	if !reflect.DeepEqual(facets, want) {
+		t.Errorf("want %+v; got %+v", want, facets)
+	}
+
+	// Step 3: The genre round-trips through Get
+	got, err := store.Get(t.Context(), books[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Genre != "Programming" {
+		t.Errorf("want genre %q; got %q", "Programming", got.Genre)
+	}
+}
+
+func TestBookStore_GroupByDecade(t *testing.T) {
+	store := newTestBookStore(t)
+
+	// Step 1: An empty catalog gives an empty map
+	decades, err := store.GroupByDecade(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decades) != 0 {
+		t.Errorf("want no decades; got %v", decades)
+	}
+
+	// Step 2: Books land in (year / 10) * 10, or "unknown" without a year
+	books := []*Book{
+		{Title: "A", Author: "Ann", Year: intPtr(1990)},
+		{Title: "B", Author: "Ann", Year: intPtr(1999)},
+		{Title: "C", Author: "Bob", Year: intPtr(2015)},
+		{Title: "D", Author: "Cat"},
+		{Title: "E", Author: "Cat", Year: intPtr(2010)},
+	}
+	for _, b := range books {
+		if _, err := store.Insert(t.Context(), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A 0 year can only come from an old row, so write it straight to the table
+	if _, err := store.DB.Exec(`UPDATE books SET year = 0 WHERE id = ?`, books[3].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	decades, err = store.GroupByDecade(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	titles := make(map[string][]string)
+	for decade, books := range decades {
+		for _, b := range books {
+			titles[decade] = append(titles[decade], b.Title)
+		}
+	}
+	want := map[string][]string{
+		"1990s":   {"A", "B"},
+		"2010s":   {"C", "E"},
+		"unknown": {"D"},
+	}
+	if !reflect.DeepEqual(titles, want) {
+		t.Errorf("want %v; got %v", want, titles)
+	}
+}
+
+func TestBookStore_Related(t *testing.T) {
+	store := newTestBookStore(t)
+
+	books := []*Book{
+		{Title: "First", Author: "Ann", Genre: "Fantasy", Year: intPtr(1995)},
+		{Title: "Same Author", Author: "Ann", Year: intPtr(2020)},
+		{Title: "Same Genre", Author: "Bob", Genre: "Fantasy", Year: intPtr(2021)},
+		{Title: "Same Decade", Author: "Cat", Year: intPtr(1999)},
+		{Title: "Unrelated", Author: "Dan", Genre: "Horror", Year: intPtr(2001)},
+		{Title: "Deleted", Author: "Ann"},
+		{Title: "Alone", Author: "Eve"},
+	}
+	for _, b := range books {
+		if _, err := store.Insert(t.Context(), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete(t.Context(), books[5].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	titles := func(id int64, limit int) []string {
+		t.Helper()
+		related, err := store.Related(t.Context(), id, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, b := range related {
+			got = append(got, b.Title)
+		}
+		return got
+	}
+
+	// Step 1: The same author comes first, then genre, then decade. The
+	// book itself, unrelated books and deleted books are left out.
+	want := []string{"Same Author", "Same Genre", "Same Decade"}
+	if got := titles(books[0].ID, 5); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q; got %q", want, got)
+	}
+
+	// Step 2: The limit keeps the best matches
+	want = []string{"Same Author"}
+	if got := titles(books[0].ID, 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q; got %q", want, got)
+	}
+
+	// Step 3: A book with nothing in common gets an empty list, not an error
+	if got := titles(books[6].ID, 5); len(got) != 0 {
+		t.Errorf("want no related books; got %q", got)
+	}
+
+	// Step 4: An unknown book is ErrRecordNotFound
+	if _, err := store.Related(t.Context(), 999, 5); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("want ErrRecordNotFound; got %v", err)
+	}
+}
+
+func TestBookStore_IncrementViews(t *testing.T) {
+	store := newTestBookStore(t)
+
+	books := []*Book{
+		{Title: "Popular", Author: "Ann"},
+		{Title: "Obscure", Author: "Bob"},
+		{Title: "Quite Popular", Author: "Cat"},
+	}
+	for _, b := range books {
+		if _, err := store.Insert(t.Context(), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Step 1: Three views for the first book, one for the third
+	for _, id := range []int64{books[0].ID, books[0].ID, books[2].ID, books[0].ID} {
+		if err := store.IncrementViews(t.Context(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := store.Get(t.Context(), books[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Views != 3 {
+		t.Errorf("want 3 views; got %d", got.Views)
+	}
+	// A view isn't an edit
+	if !got.UpdatedAt.Equal(books[0].UpdatedAt) {
+		t.Errorf("want updated_at left alone; got %v, was %v", got.UpdatedAt, books[0].UpdatedAt)
+	}
+
+	// Step 2: Sorting by views puts the most viewed first
+	sorted, err := store.GetAll(t.Context(), BookFilters{SortByViews: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, b := range sorted {
+		titles = append(titles, b.Title)
+	}
+	want := []string{"Popular", "Quite Popular", "Obscure"}
+	if !reflect.DeepEqual(titles, want) {
+		t.Errorf("want %q; got %q", want, titles)
+	}
+
+	// Step 3: An unknown book is ErrRecordNotFound
+	if err := store.IncrementViews(t.Context(), 999); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("want ErrRecordNotFound; got %v", err)
+	}
+}
+
+func TestBookStore_GetByISBN(t *testing.T) {
+	store := newTestBookStore(t)
+
+	saved, _, err := store.UpsertByISBN(t.Context(), Book{Title: "Go Brain Teasers", Author: "Miki Tebeka", ISBN: "9781680508994"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.GetByISBN(t.Context(), "9781680508994")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != saved.ID {
+		t.Errorf("want book %d; got %d", saved.ID, got.ID)
+	}
+
+	// Unknown and deleted books aren't found
+	if _, err := store.GetByISBN(t.Context(), "9780134190440"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("unknown isbn: want ErrRecordNotFound; got %v", err)
+	}
+	if err := store.Delete(t.Context(), saved.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetByISBN(t.Context(), "9781680508994"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("deleted book: want ErrRecordNotFound; got %v", err)
+	}
+}
+
+func TestBookStore_DeleteByIDs(t *testing.T) {
+	store := newTestBookStore(t)
+
+	books := []*Book{
+		{Title: "One", Author: "Ann"},
+		{Title: "Two", Author: "Bob"},
+		{Title: "Three", Author: "Cat"},
+	}
+	for _, b := range books {
+		if _, err := store.Insert(t.Context(), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Two of the three go; the unknown ID is skipped
+	deleted, err := store.DeleteByIDs(t.Context(), []int64{books[0].ID, books[2].ID, 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("want 2 deleted; got %d", deleted)
+	}
+
+	left, err := store.GetAll(t.Context(), BookFilters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || left[0].ID != books[1].ID {
+		t.Errorf("want only book %d left; got %v", books[1].ID, left)
+	}
+
+	// They're soft-deleted, so they can still be restored
+	if err := store.Restore(t.Context(), books[0].ID); err != nil {
+		t.Errorf("want the deleted book restorable; got %v", err)
+	}
+
+	// A book that's already deleted isn't counted again
+	deleted, err = store.DeleteByIDs(t.Context(), []int64{books[2].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 0 {
+		t.Errorf("want an already deleted book not counted; got %d", deleted)
+	}
+}
+
+func TestBookStore_GetCoverPaths(t *testing.T) {
+	store := newTestBookStore(t)
+
+	books := []*Book{
+		{Title: "Covered", Author: "Ann"},
+		{Title: "Bare", Author: "Bob"},
+		{Title: "Covered but deleted", Author: "Cat"},
+	}
+	for _, b := range books {
+		if _, err := store.Insert(t.Context(), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, b := range []*Book{books[0], books[2]} {
+		if err := store.SetCoverPath(t.Context(), b.ID, fmt.Sprintf("%d.png", b.ID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete(t.Context(), books[2].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the undeleted book with a cover is in the map; the bare, deleted
+	// and unknown ones are left out
+	paths, err := store.GetCoverPaths(t.Context(), []int64{books[0].ID, books[1].ID, books[2].ID, 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]string{books[0].ID: fmt.Sprintf("%d.png", books[0].ID)}
+	if !maps.Equal(paths, want) {
+		t.Errorf("want %v; got %v", want, paths)
+	}
+}
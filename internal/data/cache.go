@@ -0,0 +1,97 @@
+// File: internal/data/cache.go
+package data
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// BookCache keeps the results of BookStore.GetAll in memory for a while, so
+// a catalog that's read far more often than it changes doesn't go to the
+// database for every list request.
+//
+// Results are keyed by the filters they were read with, so each combination
+// of query parameters (page, year range, ...) is cached separately. An entry
+// is used until TTL has passed, or until the stores write anything at all:
+// every insert, update or delete throws the whole cache away (Invalidate).
+// That's blunter than working out which entries a write affects, but it can
+// never serve a stale list from this process. Writes made by another process
+// (or straight to the database) only show up once the TTL runs out.
+//
+// A nil *BookCache is valid and caches nothing, which is how the stores run
+// when caching is switched off.
+type BookCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[BookFilters]cachedBooks
+	// gen counts invalidations. A read that started before a write mustn't
+	// put its (now stale) result into the cache once the write has finished,
+	// so put only stores a result if gen hasn't moved since the lookup.
+	gen uint64
+}
+
+// cachedBooks is one GetAll result and when it stops being valid.
+type cachedBooks struct {
+	books   []Book
+	expires time.Time
+}
+
+// NewBookCache returns an empty cache whose entries last for ttl.
+func NewBookCache(ttl time.Duration) *BookCache {
+	return &BookCache{
+		ttl:     ttl,
+		entries: make(map[BookFilters]cachedBooks),
+	}
+}
+
+// get returns the cached books for filters, if there are any that haven't
+// expired. gen is passed back to put along with the result of the query.
+func (c *BookCache) get(filters BookFilters) (books []Book, ok bool, gen uint64) {
+	if c == nil {
+		return nil, false, 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[filters]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false, c.gen
+	}
+	// Hand out a copy, so a caller changing its books can't change ours.
+	return slices.Clone(entry.books), true, c.gen
+}
+
+// put caches books for filters, unless the cache was invalidated since the
+// get that returned gen.
+func (c *BookCache) put(filters BookFilters, books []Book, gen uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	c.entries[filters] = cachedBooks{
+		books:   slices.Clone(books),
+		expires: time.Now().Add(c.ttl),
+	}
+}
+
+// Invalidate empties the cache. The stores call it after every write.
+func (c *BookCache) Invalidate() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+	c.gen++
+}
@@ -0,0 +1,110 @@
+// File: internal/data/cache_test.go
+package data
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newCachedTestBookStore returns a test store with a cache, and a function
+// that counts the SQL statements it has run so far.
+func newCachedTestBookStore(t *testing.T, ttl time.Duration) (*BookStore, func() int) {
+	t.Helper()
+
+	store := newTestBookStore(t)
+	store.Cache = NewBookCache(ttl)
+
+	var buf bytes.Buffer
+	store.SQLLogger = slog.New(slog.NewTextHandler(&buf, nil))
+	queries := func() int {
+		return strings.Count(buf.String(), "msg=sql")
+	}
+	return store, queries
+}
+
+func TestBookCache_GetAll(t *testing.T) {
+	store, queries := newCachedTestBookStore(t, time.Minute)
+	if _, err := store.Insert(t.Context(), &Book{Title: "Cached", Author: "Gary Clarke"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Step 1: The first read goes to the database, the second identical one doesn't
+	before := queries()
+	first, err := store.GetAll(t.Context(), BookFilters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.GetAll(t.Context(), BookFilters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := queries() - before; n != 1 {
+		t.Errorf("want 1 query for two identical reads; got %d", n)
+	}
+	if len(first) != 1 || len(second) != 1 || second[0].Title != "Cached" {
+		t.Errorf("want the cached book both times; got %v and %v", first, second)
+	}
+
+	// Step 2: Different filters are cached separately
+	before = queries()
+	if _, err := store.GetAll(t.Context(), BookFilters{Limit: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if n := queries() - before; n != 1 {
+		t.Errorf("want a query for new filters; got %d", n)
+	}
+
+	// Step 3: A write clears the cache, so the next read sees it
+	if _, err := store.Insert(t.Context(), &Book{Title: "Fresh", Author: "Gary Clarke"}); err != nil {
+		t.Fatal(err)
+	}
+	books, err := store.GetAll(t.Context(), BookFilters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 2 {
+		t.Errorf("want 2 books after the insert; got %d", len(books))
+	}
+}
+
+func TestBookCache_Expires(t *testing.T) {
+	store, queries := newCachedTestBookStore(t, time.Nanosecond)
+
+	before := queries()
+	for range 2 {
+		if _, err := store.GetAll(t.Context(), BookFilters{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := queries() - before; n != 2 {
+		t.Errorf("want both reads to query once the entry expired; got %d queries", n)
+	}
+}
+
+func TestBookCache_DoesNotCacheErrors(t *testing.T) {
+	store, _ := newCachedTestBookStore(t, time.Minute)
+
+	store.DB.Close()
+	if _, err := store.GetAll(t.Context(), BookFilters{}); err == nil {
+		t.Fatal("want an error from a closed database")
+	}
+	if _, ok, _ := store.Cache.get(BookFilters{}); ok {
+		t.Error("want the failed read left out of the cache")
+	}
+}
+
+func TestBookCache_StaleReadIsNotStored(t *testing.T) {
+	cache := NewBookCache(time.Minute)
+
+	// A read starts, a write invalidates the cache, then the read finishes
+	_, _, gen := cache.get(BookFilters{})
+	cache.Invalidate()
+	cache.put(BookFilters{}, []Book{{Title: "Stale"}}, gen)
+
+	if _, ok, _ := cache.get(BookFilters{}); ok {
+		t.Error("want a result read before the write to be dropped")
+	}
+}
@@ -0,0 +1,197 @@
+// File: internal/data/db_test.go
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenDB_PoolConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		pool     PoolConfig
+		wantOpen int
+	}{
+		// With no overrides, SQLite keeps its single connection.
+		{name: "sqlite default", pool: PoolConfig{}, wantOpen: 1},
+		{name: "override", pool: PoolConfig{MaxOpenConns: 4, MaxIdleConns: 2, MaxIdleTime: time.Minute}, wantOpen: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := OpenDB(DriverSQLite, ":memory:", tt.pool)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				db.Close()
+			})
+
+			if got := db.Stats().MaxOpenConnections; got != tt.wantOpen {
+				t.Errorf("MaxOpenConnections = %d, want %d", got, tt.wantOpen)
+			}
+		})
+	}
+}
+
+// TestSQLiteDSN_WAL opens a file database (WAL doesn't apply to :memory:)
+// with the pragmas and asks SQLite which modes are actually in effect.
+func TestSQLiteDSN_WAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "books.db")
+	dsn, err := SQLiteDSN("file:"+path+"?_pragma=busy_timeout(5000)", SQLitePragmas{WAL: true, Synchronous: "normal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenDB(DriverSQLite, dsn, PoolConfig{MaxOpenConns: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	var journalMode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil {
+		t.Fatal(err)
+	}
+	if journalMode != "wal" {
+		t.Errorf("journal_mode = %q, want %q", journalMode, "wal")
+	}
+
+	// PRAGMA synchronous reports a number: 1 is NORMAL.
+	var synchronous int
+	if err := db.QueryRow("PRAGMA synchronous").Scan(&synchronous); err != nil {
+		t.Fatal(err)
+	}
+	if synchronous != 1 {
+		t.Errorf("synchronous = %d, want 1 (NORMAL)", synchronous)
+	}
+
+	// busy_timeout from the original DSN is still there.
+	var busyTimeout int
+	if err := db.QueryRow("PRAGMA busy_timeout").Scan(&busyTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if busyTimeout != 5000 {
+		t.Errorf("busy_timeout = %d, want 5000", busyTimeout)
+	}
+}
+
+func TestSQLiteDSN(t *testing.T) {
+	tests := []struct {
+		name    string
+		dsn     string
+		pragmas SQLitePragmas
+		want    string
+		wantErr bool
+	}{
+		{name: "no pragmas", dsn: "file:x.db", want: "file:x.db?_pragma=foreign_keys(1)"},
+		{name: "default dsn", pragmas: SQLitePragmas{WAL: true}, want: DefaultSQLiteDSN + "&_pragma=journal_mode(WAL)"},
+		{name: "no query string yet", dsn: "file:x.db", pragmas: SQLitePragmas{Synchronous: "FULL"}, want: "file:x.db?_pragma=foreign_keys(1)&_pragma=synchronous(FULL)"},
+		{name: "foreign keys already set", dsn: "file:x.db?_pragma=foreign_keys(0)", want: "file:x.db?_pragma=foreign_keys(0)"},
+		{name: "bad synchronous", dsn: "file:x.db", pragmas: SQLitePragmas{Synchronous: "sometimes"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SQLiteDSN(tt.dsn, tt.pragmas)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSQLiteDSN_ForeignKeys checks foreign keys are enforced on every
+// connection in the pool, not just the first one.
+func TestSQLiteDSN_ForeignKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "books.db")
+	dsn, err := SQLiteDSN("file:"+path, SQLitePragmas{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenDB(DriverSQLite, dsn, PoolConfig{MaxOpenConns: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	if err := Migrate(db, DriverSQLite); err != nil {
+		t.Fatal(err)
+	}
+
+	// Step 1: Hold three connections at once, so the pool has to open
+	// separate ones, and ask each whether foreign keys are on
+	var conns []*sql.Conn
+	for range 3 {
+		conn, err := db.Conn(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	for i, conn := range conns {
+		var on int
+		if err := conn.QueryRowContext(t.Context(), "PRAGMA foreign_keys").Scan(&on); err != nil {
+			t.Fatal(err)
+		}
+		if on != 1 {
+			t.Errorf("connection %d: foreign_keys = %d, want 1", i+1, on)
+		}
+		conn.Close()
+	}
+
+	// Step 2: A review for a book that doesn't exist breaks the constraint
+	stores := NewStores(db, Options{Driver: DriverSQLite})
+	_, err = stores.Reviews.Insert(t.Context(), &Review{BookID: 999, Rating: 5, Body: "Ghost book"})
+	if err == nil || !strings.Contains(err.Error(), "FOREIGN KEY constraint failed") {
+		t.Errorf("want a foreign key constraint error; got %v", err)
+	}
+}
+
+// TestReadOnlyDatabase opens a real database file read-only, as if the
+// server had lost write permission on it, and checks writes report
+// ErrReadOnly rather than failing with some other error.
+func TestReadOnlyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "books.db")
+
+	// Step 1: Create the file and its tables while we can still write to it
+	db, err := OpenDB(DriverSQLite, "file:"+path, PoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db, DriverSQLite); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// Step 2: Open it again read-only. (Taking away the file's write
+	// permission would do the same, except when the tests run as root.)
+	db, err = OpenDB(DriverSQLite, "file:"+path+"?mode=ro", PoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	stores := NewStores(db, Options{Driver: DriverSQLite})
+
+	// Step 3: Reads still work, writes don't
+	if _, err := stores.Books.GetAll(t.Context(), BookFilters{}); err != nil {
+		t.Errorf("want reads to work; got %v", err)
+	}
+	_, err = stores.Books.Insert(t.Context(), &Book{Title: "Read Only", Author: "Gary Clarke"})
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("want ErrReadOnly; got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+// File: internal/data/dialect.go
+package data
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SQLite and PostgreSQL speak slightly different flavours of SQL.
+// Rather than writing every query twice, we write them once in the SQLite
+// style and translate the few parts that differ just before running them.
+
+// rebind rewrites the ? placeholders in query into the style the driver expects.
+//
+// SQLite uses ? for every parameter, while PostgreSQL numbers them: $1, $2, ...
+// Our queries never contain a literal "?" inside a string, so a simple
+// character-by-character replacement is all we need.
+func rebind(driver, query string) string {
+	if driver != DriverPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ddlReplacers swap the placeholder tokens used in our migrations for the
+// column definitions each database understands.
+var ddlReplacers = map[string]*strings.Replacer{
+	DriverSQLite: strings.NewReplacer(
+		"{{pk}}", "INTEGER PRIMARY KEY AUTOINCREMENT",
+		"{{timestamp}}", "DATETIME",
+	),
+	DriverPostgres: strings.NewReplacer(
+		"{{pk}}", "BIGSERIAL PRIMARY KEY",
+		"{{timestamp}}", "TIMESTAMPTZ",
+	),
+}
+
+// ddl rewrites the dialect tokens in a migration for the given driver.
+func ddl(driver, stmt string) string {
+	if r, ok := ddlReplacers[driver]; ok {
+		return r.Replace(stmt)
+	}
+	return stmt
+}
+
+// placeholders returns n comma-separated ? placeholders ("?, ?, ?") for an
+// IN (...) list. See GetByIDs for why building them into the SQL is safe.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// idArgs turns a list of IDs into query arguments to go with placeholders.
+func idArgs(ids []int64) []any {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
@@ -0,0 +1,35 @@
+// File: internal/data/dialect_test.go
+package data
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	query := `UPDATE books SET title = ?, author = ? WHERE id = ?`
+
+	// SQLite queries are left exactly as written.
+	if got := rebind(DriverSQLite, query); got != query {
+		t.Errorf("sqlite: want %q; got %q", query, got)
+	}
+
+	// PostgreSQL placeholders are numbered in order.
+	want := `UPDATE books SET title = $1, author = $2 WHERE id = $3`
+	if got := rebind(DriverPostgres, query); got != want {
+		t.Errorf("postgres: want %q; got %q", want, got)
+	}
+}
+
+func TestDeleteByIDsQuery(t *testing.T) {
+	// SQLite keeps its ? placeholders, one per ID.
+	if got := deleteByIDsQuery(DriverSQLite, 3); !strings.Contains(got, "id IN (?, ?, ?)") {
+		t.Errorf("sqlite: want ? placeholders; got %q", got)
+	}
+
+	// PostgreSQL gets them numbered, since txExec doesn't rebind for us.
+	got := deleteByIDsQuery(DriverPostgres, 3)
+	if !strings.Contains(got, "id IN ($1, $2, $3)") || strings.Contains(got, "?") {
+		t.Errorf("postgres: want $n placeholders; got %q", got)
+	}
+}
@@ -0,0 +1,72 @@
+// File: internal/data/errors.go
+package data
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// ErrRecordNotFound is returned when a lookup, update or delete doesn't find
+// the record it was looking for.
+//
+// Handlers check for it with errors.Is(err, data.ErrRecordNotFound) and
+// respond with a 404. Using our own error (rather than sql.ErrNoRows) means
+// the handlers don't need to know anything about database/sql.
+var ErrRecordNotFound = errors.New("record not found")
+
+// ErrDuplicateBook is returned when saving a book would give us two books
+// with the same title and author.
+var ErrDuplicateBook = errors.New("duplicate book")
+
+// ErrEditConflict is returned when a record changed between reading it and
+// writing it back, so saving would silently overwrite someone else's edit.
+// The client should fetch the record again and retry.
+var ErrEditConflict = errors.New("edit conflict")
+
+// ErrReadOnly is returned when a write fails because the database won't
+// accept writes: the SQLite file (or its directory) isn't writable by the
+// server, the disk was remounted read-only, or PostgreSQL is a read-only
+// replica. Reads still work, so the server keeps running, but it's something
+// an operator has to fix.
+var ErrReadOnly = errors.New("database is read-only")
+
+// isUniqueViolation reports whether err is the database rejecting a write
+// because it breaks a UNIQUE constraint or index.
+//
+// Each database reports this differently: SQLite uses the extended result
+// code SQLITE_CONSTRAINT_UNIQUE, and PostgreSQL uses SQLSTATE 23505.
+func isUniqueViolation(err error) bool {
+	var se sqliteError
+	if errors.As(err, &se) {
+		return se.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505"
+	}
+
+	return false
+}
+
+// isReadOnlyError reports whether err is the database refusing a write
+// because it's read-only.
+//
+// SQLite reports SQLITE_READONLY, or one of its extended codes (such as
+// SQLITE_READONLY_DBMOVED), so we compare only the primary code in the
+// lowest 8 bits. PostgreSQL uses SQLSTATE 25006, read_only_sql_transaction.
+func isReadOnlyError(err error) bool {
+	var se sqliteError
+	if errors.As(err, &se) {
+		return se.Code()&0xff == sqlite3.SQLITE_READONLY
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "25006"
+	}
+
+	return false
+}
@@ -0,0 +1,96 @@
+// File: internal/data/idempotency.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// DefaultIdempotencyTTL is how long an idempotency key is remembered, and
+// the default for the -idempotency-ttl flag.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// IdempotentResponse is the response we sent for a request with an
+// Idempotency-Key, kept so a retry of that request gets the same answer.
+//
+// RequestHash fingerprints the request the key was first used with. A key
+// only stands for one request: sending it again with a different body is a
+// client bug, not a retry.
+type IdempotentResponse struct {
+	Key         string
+	RequestHash string
+	Status      int
+	Body        []byte
+}
+
+// IdempotencyStore remembers the idempotency keys we've handled, for TTL
+// (DefaultIdempotencyTTL when zero). After that a key is forgotten and may
+// be used again.
+type IdempotencyStore struct {
+	DB         *sql.DB
+	Driver     string
+	MaxRetries int
+	RetryDelay time.Duration
+	TTL        time.Duration
+}
+
+// ttl returns the store's TTL, or the default when it isn't set.
+func (s *IdempotencyStore) ttl() time.Duration {
+	if s.TTL <= 0 {
+		return DefaultIdempotencyTTL
+	}
+	return s.TTL
+}
+
+// Get returns the saved response for key, or ErrRecordNotFound if we
+// haven't seen the key (or it has expired).
+func (s *IdempotencyStore) Get(ctx context.Context, key string) (*IdempotentResponse, error) {
+	query := rebind(s.Driver, `
+SELECT idempotency_key, request_hash, status, body FROM idempotency_keys
+WHERE idempotency_key = ? AND expires_at > ?`)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var resp IdempotentResponse
+	var body string
+	err := s.DB.QueryRowContext(ctx, query, key, time.Now().Unix()).
+		Scan(&resp.Key, &resp.RequestHash, &resp.Status, &body)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = []byte(body)
+	return &resp, nil
+}
+
+// Save remembers resp under resp.Key until the TTL runs out.
+//
+// Expired keys are deleted first, which keeps the table small and frees an
+// expired key to be saved again. If two requests with the same key race,
+// the second Save fails on the primary key; by then the first one has
+// already done the work, so callers can just log the error.
+func (s *IdempotencyStore) Save(ctx context.Context, resp *IdempotentResponse) error {
+	now := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return withRetry(s.MaxRetries, s.RetryDelay, func() error {
+		_, err := s.DB.ExecContext(ctx, rebind(s.Driver, `DELETE FROM idempotency_keys WHERE expires_at <= ?`), now.Unix())
+		if err != nil {
+			return err
+		}
+
+		_, err = s.DB.ExecContext(ctx, rebind(s.Driver, `
+INSERT INTO idempotency_keys (idempotency_key, request_hash, status, body, expires_at)
+VALUES (?, ?, ?, ?, ?)`),
+			resp.Key, resp.RequestHash, resp.Status, string(resp.Body), now.Add(s.ttl()).Unix())
+		return err
+	})
+}
@@ -0,0 +1,65 @@
+// File: internal/data/idempotency_test.go
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestIdempotencyStore(t *testing.T, ttl time.Duration) *IdempotencyStore {
+	t.Helper()
+
+	db := openTestDB(t)
+	if err := Migrate(db, DriverSQLite); err != nil {
+		t.Fatal(err)
+	}
+
+	stores := NewStores(db, Options{Driver: DriverSQLite, IdempotencyTTL: ttl})
+	return &stores.Idempotency
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	store := newTestIdempotencyStore(t, time.Hour)
+
+	// Step 1: An unknown key isn't found
+	if _, err := store.Get(t.Context(), "abc"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("want ErrRecordNotFound; got %v", err)
+	}
+
+	// Step 2: A saved response comes back as it was
+	want := IdempotentResponse{Key: "abc", RequestHash: "hash", Status: 201, Body: []byte(`{"id":1}`)}
+	if err := store.Save(t.Context(), &want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Get(t.Context(), "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Key != want.Key || got.RequestHash != want.RequestHash || got.Status != want.Status || string(got.Body) != string(want.Body) {
+		t.Errorf("want %+v; got %+v", want, *got)
+	}
+
+	// Step 3: A key can only be saved once while it's remembered
+	if err := store.Save(t.Context(), &want); err == nil {
+		t.Error("want an error saving the same key twice")
+	}
+}
+
+func TestIdempotencyStore_Expires(t *testing.T) {
+	// Expiry is counted in whole seconds, so a nanosecond has run out at once
+	store := newTestIdempotencyStore(t, time.Nanosecond)
+
+	resp := IdempotentResponse{Key: "abc", RequestHash: "hash", Status: 201, Body: []byte(`{}`)}
+	if err := store.Save(t.Context(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(t.Context(), "abc"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("want an expired key to be forgotten; got %v", err)
+	}
+
+	// ...and the key can be used again
+	if err := store.Save(t.Context(), &resp); err != nil {
+		t.Errorf("want an expired key to be saved again; got %v", err)
+	}
+}
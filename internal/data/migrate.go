@@ -1,55 +1,472 @@
 // File: internal/data/migrate.go
 package data
 
-import "database/sql"
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
 
-// Migrate creates the tables we need for this project.
-// In real-world projects, migrations are usually run with a separate tool,
-// but for this course we run them at startup to keep things simple.
-func Migrate(db *sql.DB) error {
-	// DDL = Data Definition Language. This is the subset of SQL used to define
-	// or modify the database schema (tables, columns, indexes, constraints).
-	// It's different from DML (Data Manipulation Language), which you use to
-	// insert, update, delete, or query rows of data.
-	const ddl = `
+// migration is a single, numbered change to the database schema.
+//
+// Each migration has a version (1, 2, 3, ...), the SQL needed to apply it
+// (up) and the SQL that undoes it again (down), used by MigrateDown.
+// Once a migration has been released you should never edit it — instead, add a
+// new migration with the next version number that makes the further change.
+type migration struct {
+	version int
+	up      string
+	down    string
+
+	// upPostgres and downPostgres, when set, are run instead of up and down
+	// on PostgreSQL. We only need them when the two databases can't share a
+	// statement, even with {{tokens}}.
+	upPostgres   string
+	downPostgres string
+}
+
+// migrations is the full, ordered history of our schema.
+// To change the schema, append a new migration to the end of this slice.
+//
+// DDL = Data Definition Language. This is the subset of SQL used to define
+// or modify the database schema (tables, columns, indexes, constraints).
+// It's different from DML (Data Manipulation Language), which you use to
+// insert, update, delete, or query rows of data.
+//
+// Migrations are written in SQLite's dialect, with a few {{tokens}} (such as
+// {{pk}} for an auto-incrementing primary key) standing in for the parts that
+// differ between databases. See ddl() in dialect.go for the translations.
+var migrations = []migration{
+	{
+		version: 1,
+		// IF NOT EXISTS means databases created before we had versioned
+		// migrations (which already have a books table) are left untouched.
+		up: `
 CREATE TABLE IF NOT EXISTS books (
-  id     INTEGER PRIMARY KEY AUTOINCREMENT,
+  id     {{pk}},
   title  TEXT NOT NULL,
   author TEXT,
   year   INTEGER
-);`
-	// Exec runs the DDL statement. If the table already exists, the
-	// IF NOT EXISTS clause ensures nothing bad happens.
-	_, err := db.Exec(ddl)
-	return err
-}
-
-// SeedIfEmpty inserts demo books if the table is empty.
-// This is just for demo purposes - we’ll remove it later once POST /books is implemented.
-// Once added..run the following commands:
-// go run ./cmd/api
-// sqlite3 books.db "SELECT * FROM books;"
-// You should see two rows in the output. If you run it again, no duplicates will be added.
-func SeedIfEmpty(db *sql.DB) error {
-	var count int
-
-	// Count how many rows currently exist in the books table.
-	err := db.QueryRow(`SELECT COUNT(*) FROM books`).
-		Scan(&count)
+);`,
+		down: `DROP TABLE books;`,
+	},
+	{
+		version: 2,
+		// The unique index on (title, author) stops the same book being stored twice.
+		up:   `CREATE UNIQUE INDEX IF NOT EXISTS books_title_author_idx ON books (title, author);`,
+		down: `DROP INDEX books_title_author_idx;`,
+	},
+	{
+		version: 3,
+		// Track when each book was created and last modified.
+		//
+		// SQLite won't let ALTER TABLE ADD COLUMN use a non-constant default like
+		// CURRENT_TIMESTAMP, so we use SQLite's recommended workaround: build a
+		// new table with the extra columns, copy the rows across, drop the old
+		// table and rename the new one into its place (recreating its index).
+		// Existing rows get "now" as both timestamps.
+		up: `
+CREATE TABLE books_new (
+  id         {{pk}},
+  title      TEXT NOT NULL,
+  author     TEXT,
+  year       INTEGER,
+  created_at {{timestamp}} NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at {{timestamp}} NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO books_new (id, title, author, year) SELECT id, title, author, year FROM books;
+DROP TABLE books;
+ALTER TABLE books_new RENAME TO books;
+CREATE UNIQUE INDEX books_title_author_idx ON books (title, author);`,
+		// PostgreSQL can add the columns in place.
+		upPostgres: `
+ALTER TABLE books ADD COLUMN created_at {{timestamp}} NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE books ADD COLUMN updated_at {{timestamp}} NOT NULL DEFAULT CURRENT_TIMESTAMP;`,
+		// Going back is the same dance in reverse: a table without the timestamps.
+		down: `
+CREATE TABLE books_old (
+  id     {{pk}},
+  title  TEXT NOT NULL,
+  author TEXT,
+  year   INTEGER
+);
+INSERT INTO books_old (id, title, author, year) SELECT id, title, author, year FROM books;
+DROP TABLE books;
+ALTER TABLE books_old RENAME TO books;
+CREATE UNIQUE INDEX books_title_author_idx ON books (title, author);`,
+		downPostgres: `
+ALTER TABLE books DROP COLUMN created_at;
+ALTER TABLE books DROP COLUMN updated_at;`,
+	},
+	{
+		version: 4,
+		// Soft deletes: instead of removing a row, Delete stamps deleted_at.
+		// NULL means the book hasn't been deleted.
+		up:   `ALTER TABLE books ADD COLUMN deleted_at {{timestamp}};`,
+		down: `ALTER TABLE books DROP COLUMN deleted_at;`,
+	},
+	{
+		version: 5,
+		// Normalise authors into their own table, so each author is stored once
+		// and books point at them with author_id. We create an author row for
+		// every distinct name already in use, then link the existing books.
+		//
+		// books.author stays as a plain copy of the name, so the existing
+		// (title, author) unique index keeps working. Reads use the authors table.
+		up: `
+CREATE TABLE authors (
+  id   {{pk}},
+  name TEXT NOT NULL UNIQUE
+);
+INSERT INTO authors (name)
+  SELECT DISTINCT author FROM books WHERE author IS NOT NULL AND author <> '';
+ALTER TABLE books ADD COLUMN author_id BIGINT REFERENCES authors (id);
+UPDATE books SET author_id = (SELECT id FROM authors WHERE authors.name = books.author);`,
+		// SQLite refuses to DROP COLUMN a column with a foreign key, so on
+		// SQLite we rebuild books without author_id, like migration 3 does.
+		// Nothing is lost: books.author still holds every name.
+		down: `
+CREATE TABLE books_old (
+  id         {{pk}},
+  title      TEXT NOT NULL,
+  author     TEXT,
+  year       INTEGER,
+  created_at {{timestamp}} NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at {{timestamp}} NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  deleted_at {{timestamp}}
+);
+INSERT INTO books_old (id, title, author, year, created_at, updated_at, deleted_at)
+  SELECT id, title, author, year, created_at, updated_at, deleted_at FROM books;
+DROP TABLE books;
+ALTER TABLE books_old RENAME TO books;
+CREATE UNIQUE INDEX books_title_author_idx ON books (title, author);
+DROP TABLE authors;`,
+		downPostgres: `
+ALTER TABLE books DROP COLUMN author_id;
+DROP TABLE authors;`,
+	},
+	{
+		version: 6,
+		// Reviews belong to a book. ON DELETE CASCADE removes a book's reviews
+		// if the book row itself is ever removed (soft deletes leave them alone).
+		// The index makes "all reviews for book X" a quick lookup.
+		up: `
+CREATE TABLE reviews (
+  id         {{pk}},
+  book_id    BIGINT NOT NULL REFERENCES books (id) ON DELETE CASCADE,
+  rating     INTEGER NOT NULL CHECK (rating BETWEEN 1 AND 5),
+  body       TEXT NOT NULL,
+  created_at {{timestamp}} NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX reviews_book_id_idx ON reviews (book_id);`,
+		down: `DROP TABLE reviews;`,
+	},
+	{
+		version: 7,
+		// An optional ISBN, used to match books when syncing with other systems.
+		// Existing books have no ISBN (NULL). A unique index still allows any
+		// number of NULLs, so only real ISBNs have to be unique.
+		up: `
+ALTER TABLE books ADD COLUMN isbn TEXT;
+CREATE UNIQUE INDEX books_isbn_idx ON books (isbn);`,
+		// SQLite can't drop a column that's still indexed, so the index goes first.
+		down: `
+DROP INDEX books_isbn_idx;
+ALTER TABLE books DROP COLUMN isbn;`,
+	},
+	{
+		version: 8,
+		// Where the book's cover image is stored, relative to the covers
+		// directory. NULL means the book has no cover.
+		up:   `ALTER TABLE books ADD COLUMN cover_path TEXT;`,
+		down: `ALTER TABLE books DROP COLUMN cover_path;`,
+	},
+	{
+		version: 9,
+		// An optional genre, free text such as "Programming". NULL means we
+		// don't know it; the index speeds up grouping and filtering by genre.
+		up: `
+ALTER TABLE books ADD COLUMN genre TEXT;
+CREATE INDEX books_genre_idx ON books (genre);`,
+		down: `
+DROP INDEX books_genre_idx;
+ALTER TABLE books DROP COLUMN genre;`,
+	},
+	{
+		version: 10,
+		// Idempotency keys we've already handled, with the response we sent,
+		// so a retried POST can be answered without doing the work twice.
+		// expires_at is a Unix time in seconds rather than a {{timestamp}}:
+		// comparing it with "now" is then the same plain < in both databases.
+		up: `
+CREATE TABLE idempotency_keys (
+  idempotency_key TEXT PRIMARY KEY,
+  request_hash    TEXT NOT NULL,
+  status          INTEGER NOT NULL,
+  body            TEXT NOT NULL,
+  expires_at      BIGINT NOT NULL
+);
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);`,
+		down: `DROP TABLE idempotency_keys;`,
+	},
+	{
+		version: 11,
+		// How many times each book has been viewed (GET /books/{id}), for
+		// sorting by popularity. The index serves ORDER BY views.
+		up: `
+ALTER TABLE books ADD COLUMN views INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX books_views_idx ON books (views);`,
+		down: `
+DROP INDEX books_views_idx;
+ALTER TABLE books DROP COLUMN views;`,
+	},
+	{
+		version: 12,
+		// A deleted book shouldn't stop the same book being created again, so
+		// the unique (title, author) index becomes a partial index that only
+		// covers books that haven't been deleted. Both databases support the
+		// WHERE, and SeedBooks' ON CONFLICT DO NOTHING still applies to it.
+		up: `
+DROP INDEX books_title_author_idx;
+CREATE UNIQUE INDEX books_title_author_idx ON books (title, author) WHERE deleted_at IS NULL;`,
+		// Going back fails if a deleted book now shares its title and author
+		// with another book; one of them has to be removed first.
+		down: `
+DROP INDEX books_title_author_idx;
+CREATE UNIQUE INDEX books_title_author_idx ON books (title, author);`,
+	},
+}
+
+// Migrate brings the database schema up to date.
+// In real-world projects, migrations are usually run with a separate tool,
+// but for this course we run them at startup to keep things simple.
+//
+// It's safe to call Migrate as many times as you like: only migrations that
+// haven't been applied yet are run. driver is DriverSQLite or DriverPostgres,
+// and decides which SQL dialect the migrations are written in.
+func Migrate(db *sql.DB, driver string) error {
+	return migrate(db, driver, migrations)
+}
+
+// migrate applies every migration in ms that is newer than the version
+// recorded in the schema_migrations table.
+//
+// Each migration runs in its own transaction together with the INSERT that
+// records it. So either the schema change AND the record are saved, or neither
+// is — we can never end up with a half-applied migration.
+func migrate(db *sql.DB, driver string, ms []migration) error {
+	// Find the newest version we've applied so far (0 for a brand-new database).
+	// schema_migrations, which remembers the versions already applied, is
+	// created along the way if it doesn't exist yet.
+	current, err := SchemaVersion(db)
 	if err != nil {
 		return err
 	}
 
-	// If there are already rows, do nothing.
-	if count > 0 {
-		return nil
+	for i, m := range ms {
+		// Guard against mistakes in the migrations slice: versions must be unique
+		// and in ascending order, otherwise "newer than current" means nothing.
+		if i > 0 && m.version <= ms[i-1].version {
+			return fmt.Errorf("migration %d is out of order", m.version)
+		}
+
+		// Skip anything that has already been applied.
+		if m.version <= current {
+			continue
+		}
+
+		if err := applyMigration(db, driver, m); err != nil {
+			return fmt.Errorf("migration %d: %w", m.version, err)
+		}
 	}
 
-	// Insert with explicit IDs to simplify testing and demos
-	_, err = db.Exec(`
-INSERT INTO books (id, title, author, year) VALUES
-  (1, 'The Go Programming Language', 'Alan Donovan', 2015),
-  (2, 'Designing Data-Intensive Applications', 'Martin Kleppmann', 2017)`)
+	return nil
+}
+
+// applyMigration runs a single migration and records it, inside one transaction.
+func applyMigration(db *sql.DB, driver string, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	up := m.up
+	if driver == DriverPostgres && m.upPostgres != "" {
+		up = m.upPostgres
+	}
+
+	if _, err := tx.Exec(ddl(driver, up)); err != nil {
+		return err
+	}
+
+	query := rebind(driver, `INSERT INTO schema_migrations (version) VALUES (?)`)
+	if _, err := tx.Exec(query, m.version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MigrateDown rolls the database schema back to toVersion, undoing every
+// applied migration newer than that, newest first. MigrateDown(db, driver, 0)
+// undoes them all — including dropping the books table, so be careful.
+//
+// Like migrate, each step runs in its own transaction together with the
+// DELETE that removes it from schema_migrations.
+func MigrateDown(db *sql.DB, driver string, toVersion int) error {
+	return migrateDown(db, driver, migrations, toVersion)
+}
+
+// migrateDown undoes the migrations in ms that are newer than toVersion and
+// have been applied, in reverse order.
+func migrateDown(db *sql.DB, driver string, ms []migration, toVersion int) error {
+	if toVersion < 0 {
+		return fmt.Errorf("can't migrate down to version %d", toVersion)
+	}
+
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	for i := len(ms) - 1; i >= 0; i-- {
+		m := ms[i]
+		// Leave alone anything not applied yet, or that we're keeping.
+		if m.version > current || m.version <= toVersion {
+			continue
+		}
+
+		if err := revertMigration(db, driver, m); err != nil {
+			return fmt.Errorf("migration %d down: %w", m.version, err)
+		}
+	}
+
+	return nil
+}
+
+// revertMigration runs a single migration's down SQL and forgets it was
+// applied, inside one transaction.
+func revertMigration(db *sql.DB, driver string, m migration) error {
+	down := m.down
+	if driver == DriverPostgres && m.downPostgres != "" {
+		down = m.downPostgres
+	}
+	if down == "" {
+		return errors.New("no down migration")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(ddl(driver, down)); err != nil {
+		return err
+	}
+
+	query := rebind(driver, `DELETE FROM schema_migrations WHERE version = ?`)
+	if _, err := tx.Exec(query, m.version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SchemaVersion returns the newest migration version applied to the
+// database, or 0 if it hasn't been migrated at all.
+func SchemaVersion(db *sql.DB) (int, error) {
+	_, err := db.Exec(`
+CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER PRIMARY KEY
+);`)
+	if err != nil {
+		return 0, err
+	}
+
+	var current int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	return current, err
+}
+
+// demoBooks is the canonical demo data for the project.
+//
+// These rows are identified by their fixed IDs (1 and 2) and by their
+// title+author pair. SeedIfEmpty will only ever insert these exact rows,
+// and never changes them once they exist — if you edit or delete one of them
+// through the API, your change wins until the row is gone again.
+var demoBooks = []Book{
+	{ID: 1, Title: "The Go Programming Language", Author: "Alan Donovan", Year: intPtr(2015)},
+	{ID: 2, Title: "Designing Data-Intensive Applications", Author: "Martin Kleppmann", Year: intPtr(2017)},
+}
+
+// SeedIfEmpty makes sure the demo books exist.
+//
+// Despite the name, it no longer checks whether the table is empty. Instead
+// every demo row is inserted with an UPSERT ("INSERT ... ON CONFLICT DO NOTHING"):
+// if a row with the same ID or the same title+author already exists, the insert
+// is silently skipped. That makes it idempotent — you can run it on every
+// startup, against an empty, partially-populated or full table, and you'll
+// never get duplicates or errors.
+func SeedIfEmpty(db *sql.DB, driver string) error {
+	return SeedBooks(db, driver, demoBooks)
+}
+
+// SeedBooks inserts books the same way SeedIfEmpty inserts the demo books,
+// skipping any that already exist. It's how a -seed-file gets loaded, and
+// how tests set up exactly the books they expect.
+//
+// A book with an ID keeps it; a book without one (ID 0) gets the next free
+// ID from the database, and only its title+author decides whether it's
+// already there.
+func SeedBooks(db *sql.DB, driver string, books []Book) error {
+	// Run all the inserts in a single transaction so we never end up with
+	// only half of the demo data.
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	// ON CONFLICT without a target covers both unique constraints we care about:
+	// the fixed primary key (id) and the unique (title, author) index.
+	withID := rebind(driver, `
+INSERT INTO books (id, title, author, author_id, year) VALUES (?, ?, ?, ?, ?)
+ON CONFLICT DO NOTHING`)
+	withoutID := rebind(driver, `
+INSERT INTO books (title, author, author_id, year) VALUES (?, ?, ?, ?)
+ON CONFLICT DO NOTHING`)
+
+	ctx := context.Background()
+	for _, b := range books {
+		authorID, err := getOrCreateAuthor(ctx, tx, driver, b.Author)
+		if err != nil {
+			return err
+		}
+		if b.ID == 0 {
+			_, err = tx.Exec(withoutID, b.Title, b.Author, authorID, nullYear(b.Year))
+		} else {
+			_, err = tx.Exec(withID, b.ID, b.Title, b.Author, authorID, nullYear(b.Year))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// PostgreSQL hands out IDs from a separate sequence, which doesn't notice
+	// that we inserted rows with explicit IDs. Move the sequence past the
+	// highest ID so the next INSERT doesn't try to reuse 1 or 2.
+	if driver == DriverPostgres {
+		_, err := tx.Exec(`SELECT setval(pg_get_serial_sequence('books', 'id'), (SELECT MAX(id) FROM books))`)
+		if err != nil {
+			return err
+		}
+	}
 
-	return err
+	return tx.Commit()
 }
@@ -0,0 +1,248 @@
+// File: internal/data/migrate_test.go
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// openTestDB opens a fresh in-memory SQLite database for a single test.
+// Foreign keys are enforced, as they are for the real database (see SQLiteDSN).
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", "file::memory:?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every new connection to ":memory:" gets its own empty database,
+	// so we limit the pool to one connection to keep all queries on the same one.
+	db.SetMaxOpenConns(1)
+
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	return db
+}
+
+// schemaVersion returns the newest version recorded in schema_migrations.
+func schemaVersion(t *testing.T, db *sql.DB) int {
+	t.Helper()
+
+	var v int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestMigrate_AppliesMigrationsInSequence(t *testing.T) {
+	db := openTestDB(t)
+
+	// Step 1: Apply a first migration on its own.
+	first := []migration{
+		{version: 1, up: `CREATE TABLE widgets (id INTEGER PRIMARY KEY);`},
+	}
+	if err := migrate(db, DriverSQLite, first); err != nil {
+		t.Fatal(err)
+	}
+	if v := schemaVersion(t, db); v != 1 {
+		t.Errorf("want schema version 1; got %d", v)
+	}
+
+	// Step 2: Add a second migration. Only the new one should run —
+	// if migration 1 ran again, CREATE TABLE widgets would fail.
+	both := append(first, migration{version: 2, up: `ALTER TABLE widgets ADD COLUMN name TEXT;`})
+	if err := migrate(db, DriverSQLite, both); err != nil {
+		t.Fatal(err)
+	}
+	if v := schemaVersion(t, db); v != 2 {
+		t.Errorf("want schema version 2; got %d", v)
+	}
+
+	// Step 3: The column added by migration 2 should now exist.
+	if _, err := db.Exec(`INSERT INTO widgets (name) VALUES ('sprocket')`); err != nil {
+		t.Errorf("expected widgets.name column to exist: %v", err)
+	}
+
+	// Step 4: Running again with nothing new is a no-op.
+	if err := migrate(db, DriverSQLite, both); err != nil {
+		t.Errorf("expected repeated migrate to succeed; got %v", err)
+	}
+}
+
+func TestMigrate_FailedMigrationIsRolledBack(t *testing.T) {
+	db := openTestDB(t)
+
+	// The second statement is invalid, so the whole migration should be rolled back.
+	ms := []migration{
+		{version: 1, up: `CREATE TABLE widgets (id INTEGER PRIMARY KEY); NOT VALID SQL;`},
+	}
+	if err := migrate(db, DriverSQLite, ms); err == nil {
+		t.Fatal("expected an error for invalid SQL")
+	}
+
+	if v := schemaVersion(t, db); v != 0 {
+		t.Errorf("want schema version 0; got %d", v)
+	}
+	if _, err := db.Exec(`SELECT * FROM widgets`); err == nil {
+		t.Errorf("expected widgets table to have been rolled back")
+	}
+}
+
+func TestMigrate_IsSafeToRunRepeatedly(t *testing.T) {
+	db := openTestDB(t)
+
+	for i := 0; i < 2; i++ {
+		if err := Migrate(db, DriverSQLite); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+	}
+
+	if v := schemaVersion(t, db); v != len(migrations) {
+		t.Errorf("want schema version %d; got %d", len(migrations), v)
+	}
+}
+
+func TestMigrateDown_RollsBackOneStep(t *testing.T) {
+	db := openTestDB(t)
+
+	ms := []migration{
+		{version: 1, up: `CREATE TABLE widgets (id INTEGER PRIMARY KEY);`, down: `DROP TABLE widgets;`},
+		{version: 2, up: `ALTER TABLE widgets ADD COLUMN name TEXT;`, down: `ALTER TABLE widgets DROP COLUMN name;`},
+	}
+
+	// Step 1: Migrate up two steps.
+	if err := migrate(db, DriverSQLite, ms); err != nil {
+		t.Fatal(err)
+	}
+	if v := schemaVersion(t, db); v != 2 {
+		t.Fatalf("want schema version 2; got %d", v)
+	}
+
+	// Step 2: Roll back one.
+	if err := migrateDown(db, DriverSQLite, ms, 1); err != nil {
+		t.Fatal(err)
+	}
+	if v := schemaVersion(t, db); v != 1 {
+		t.Errorf("want schema version 1; got %d", v)
+	}
+
+	// Step 3: Migration 2's column is gone, but migration 1's table is still there.
+	if _, err := db.Exec(`INSERT INTO widgets (name) VALUES ('sprocket')`); err == nil {
+		t.Error("expected widgets.name column to have been dropped")
+	}
+	if _, err := db.Exec(`INSERT INTO widgets (id) VALUES (1)`); err != nil {
+		t.Errorf("expected widgets table to still exist: %v", err)
+	}
+
+	// Step 4: Migrating up again re-applies migration 2.
+	if err := migrate(db, DriverSQLite, ms); err != nil {
+		t.Fatal(err)
+	}
+	if v := schemaVersion(t, db); v != 2 {
+		t.Errorf("want schema version 2; got %d", v)
+	}
+}
+
+func TestMigrateDown_FailedStepIsRolledBack(t *testing.T) {
+	db := openTestDB(t)
+
+	ms := []migration{
+		{version: 1, up: `CREATE TABLE widgets (id INTEGER PRIMARY KEY);`, down: `DROP TABLE widgets; NOT VALID SQL;`},
+	}
+	if err := migrate(db, DriverSQLite, ms); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateDown(db, DriverSQLite, ms, 0); err == nil {
+		t.Fatal("expected an error for invalid SQL")
+	}
+	if v := schemaVersion(t, db); v != 1 {
+		t.Errorf("want schema version 1; got %d", v)
+	}
+	if _, err := db.Exec(`SELECT * FROM widgets`); err != nil {
+		t.Errorf("expected widgets table to still exist: %v", err)
+	}
+}
+
+// Every real migration has to be reversible: migrate all the way down and
+// back up again, with some data in the table.
+func TestMigrateDown_AllMigrations(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := Migrate(db, DriverSQLite); err != nil {
+		t.Fatal(err)
+	}
+	if err := SeedIfEmpty(db, DriverSQLite); err != nil {
+		t.Fatal(err)
+	}
+
+	// One step at a time, checking the data survives until books itself goes.
+	for v := len(migrations) - 1; v >= 1; v-- {
+		if err := MigrateDown(db, DriverSQLite, v); err != nil {
+			t.Fatalf("down to %d: %v", v, err)
+		}
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM books`).Scan(&n); err != nil || n != len(demoBooks) {
+			t.Fatalf("down to %d: want %d books; got %d (%v)", v, len(demoBooks), n, err)
+		}
+	}
+	if err := MigrateDown(db, DriverSQLite, 0); err != nil {
+		t.Fatal(err)
+	}
+	if v := schemaVersion(t, db); v != 0 {
+		t.Errorf("want schema version 0; got %d", v)
+	}
+
+	if err := Migrate(db, DriverSQLite); err != nil {
+		t.Fatalf("migrating up again: %v", err)
+	}
+}
+
+func TestSeedBooks(t *testing.T) {
+	db := openTestDB(t)
+	if err := Migrate(db, DriverSQLite); err != nil {
+		t.Fatal(err)
+	}
+
+	fixtures := []Book{
+		{ID: 7, Title: "Fixed ID", Author: "Gary Clarke", Year: intPtr(2020)},
+		{Title: "Next Free ID", Author: "Gary Clarke"},
+	}
+
+	// Seeding twice gives the same books, not duplicates
+	for i := 0; i < 2; i++ {
+		if err := SeedBooks(db, DriverSQLite, fixtures); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+	}
+
+	rows, err := db.Query(`SELECT id, title FROM books ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var got []string
+	for rows.Next() {
+		var id int64
+		var title string
+		if err := rows.Scan(&id, &title); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%d %s", id, title))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The book with an ID keeps it; the other comes after it
+	want := []string{"7 Fixed ID", "8 Next Free ID"}
+	if !slices.Equal(got, want) {
+		t.Errorf("want books %q; got %q", want, got)
+	}
+}
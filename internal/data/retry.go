@@ -0,0 +1,70 @@
+// File: internal/data/retry.go
+package data
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	sqlite3 "modernc.org/sqlite/lib" // SQLite's result codes, e.g. SQLITE_BUSY
+)
+
+// Default retry settings, used as the defaults for the -db-max-retries
+// and -db-retry-delay flags.
+const (
+	DefaultMaxRetries = 3
+	DefaultRetryDelay = 50 * time.Millisecond
+)
+
+// sqliteError is the part of the SQLite driver's error type we care about.
+// Matching on a small interface (instead of the driver's concrete *sqlite.Error)
+// lets tests fake a "database is locked" error without a real lock.
+type sqliteError interface {
+	error
+	Code() int
+}
+
+// isBusyError reports whether err is SQLite telling us the database is
+// busy or locked by another connection. These errors are temporary:
+// trying the same statement again a moment later usually works.
+func isBusyError(err error) bool {
+	var se sqliteError
+	if !errors.As(err, &se) {
+		return false
+	}
+
+	// The driver may report an "extended" result code (e.g. SQLITE_BUSY_SNAPSHOT).
+	// The lowest 8 bits always hold the primary code, so we mask the rest off.
+	switch se.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return true
+	default:
+		return false
+	}
+}
+
+// withRetry calls fn, and if it fails with a busy/locked error, calls it again
+// up to maxRetries more times.
+//
+// Between attempts we wait using exponential backoff: baseDelay, then 2x
+// baseDelay, then 4x, and so on. Backing off gives whoever holds the lock
+// time to finish, rather than hammering the database.
+//
+// Any other error (or success) is returned straight away. Every write goes
+// through here, so it's also where we spot a read-only database: that error
+// is wrapped in ErrReadOnly, so callers can tell it apart from the driver's
+// other errors without knowing which database they're talking to.
+func withRetry(maxRetries int, baseDelay time.Duration, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if isReadOnlyError(err) {
+			return fmt.Errorf("%w: %w", ErrReadOnly, err)
+		}
+		if err == nil || !isBusyError(err) || attempt >= maxRetries {
+			return err
+		}
+
+		// baseDelay << attempt doubles the delay on each attempt: 1x, 2x, 4x...
+		time.Sleep(baseDelay << attempt)
+	}
+}
@@ -0,0 +1,89 @@
+// File: internal/data/retry_test.go
+package data
+
+import (
+	"errors"
+	"testing"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// fakeSQLiteError looks just like the driver's error to isBusyError.
+type fakeSQLiteError struct {
+	code int
+}
+
+func (e fakeSQLiteError) Error() string { return "database is locked" }
+func (e fakeSQLiteError) Code() int     { return e.code }
+
+func TestWithRetry_SucceedsAfterTransientBusyError(t *testing.T) {
+	calls := 0
+
+	// Fail with SQLITE_BUSY twice, then succeed.
+	err := withRetry(3, 0, func() error {
+		calls++
+		if calls < 3 {
+			return fakeSQLiteError{code: sqlite3.SQLITE_BUSY}
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("expected eventual success; got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("want 3 calls; got %d", calls)
+	}
+}
+
+func TestWithRetry_GivesUpAfterMaxRetries(t *testing.T) {
+	calls := 0
+
+	err := withRetry(2, 0, func() error {
+		calls++
+		return fakeSQLiteError{code: sqlite3.SQLITE_BUSY}
+	})
+
+	if !isBusyError(err) {
+		t.Errorf("expected the busy error to be returned; got %v", err)
+	}
+	// 1 initial attempt + 2 retries.
+	if calls != 3 {
+		t.Errorf("want 3 calls; got %d", calls)
+	}
+}
+
+func TestWithRetry_DoesNotRetryOtherErrors(t *testing.T) {
+	calls := 0
+	boom := errors.New("boom")
+
+	err := withRetry(3, 0, func() error {
+		calls++
+		return boom
+	})
+
+	if !errors.Is(err, boom) {
+		t.Errorf("want %v; got %v", boom, err)
+	}
+	if calls != 1 {
+		t.Errorf("want 1 call; got %d", calls)
+	}
+}
+
+func TestWithRetry_ReadOnlyError(t *testing.T) {
+	calls := 0
+
+	// SQLITE_READONLY_DBMOVED is one of SQLITE_READONLY's extended codes
+	err := withRetry(3, 0, func() error {
+		calls++
+		return fakeSQLiteError{code: sqlite3.SQLITE_READONLY_DBMOVED}
+	})
+
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("want ErrReadOnly; got %v", err)
+	}
+	// Waiting won't make the database writable, so there's no retry
+	if calls != 1 {
+		t.Errorf("want 1 call; got %d", calls)
+	}
+}
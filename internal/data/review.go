@@ -0,0 +1,30 @@
+// File: internal/data/review.go
+package data
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"time"
+)
+
+// Review is a reader's review of a single book: a rating from 1 to 5 and
+// some text. CreatedAt is set by the database.
+type Review struct {
+	XMLName   xml.Name  `json:"-" xml:"review"`
+	ID        int64     `json:"id" xml:"id"`
+	BookID    int64     `json:"book_id" xml:"book_id"`
+	Rating    int       `json:"rating" xml:"rating"`
+	Body      string    `json:"body" xml:"body"`
+	CreatedAt time.Time `json:"created_at" xml:"created_at"`
+}
+
+// MarshalJSON writes a review as JSON, with CreatedAt in UTC like a book's
+// timestamps (see Book.MarshalJSON).
+func (rv Review) MarshalJSON() ([]byte, error) {
+	// review has Review's fields and tags but none of its methods, so
+	// json.Marshal doesn't call back into this one.
+	type review Review
+	r := review(rv)
+	r.CreatedAt = r.CreatedAt.UTC()
+	return json.Marshal(r)
+}
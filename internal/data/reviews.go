@@ -0,0 +1,78 @@
+// File: internal/data/reviews.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ReviewStore provides methods for working with book reviews.
+// Like BookStore, writes are retried when SQLite reports it's busy.
+type ReviewStore struct {
+	DB         *sql.DB
+	Driver     string
+	MaxRetries int
+	RetryDelay time.Duration
+
+	// Cache is the book store's cache, if it has one. A new review changes
+	// the book's rating in every list it appears in, so Insert empties it.
+	Cache *BookCache
+}
+
+// GetByBook returns every review for the given book, oldest first.
+// A book with no reviews gives an empty slice, not an error.
+func (s *ReviewStore) GetByBook(ctx context.Context, bookID int64) ([]Review, error) {
+	query := rebind(s.Driver, `
+SELECT id, book_id, rating, body, created_at FROM reviews
+WHERE book_id = ?
+ORDER BY id`)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Start with an empty (rather than nil) slice so a book without reviews
+	// is encoded as [] in JSON instead of null.
+	reviews := []Review{}
+	for rows.Next() {
+		var rv Review
+		if err := rows.Scan(&rv.ID, &rv.BookID, &rv.Rating, &rv.Body, &rv.CreatedAt); err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, rv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+// Insert saves a new review, filling in its ID and CreatedAt from the database.
+// It doesn't check the book exists; callers should do that first.
+func (s *ReviewStore) Insert(ctx context.Context, review *Review) (*Review, error) {
+	query := rebind(s.Driver, `
+INSERT INTO reviews (book_id, rating, body) VALUES (?, ?, ?)
+RETURNING id, created_at`)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	defer s.Cache.Invalidate()
+
+	err := withRetry(s.MaxRetries, s.RetryDelay, func() error {
+		return s.DB.QueryRowContext(ctx, query, review.BookID, review.Rating, review.Body).
+			Scan(&review.ID, &review.CreatedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return review, nil
+}
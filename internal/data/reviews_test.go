@@ -0,0 +1,41 @@
+// File: internal/data/reviews_test.go
+package data
+
+import "testing"
+
+func TestBookStore_AverageRating(t *testing.T) {
+	store := newTestBookStore(t)
+	reviews := NewStores(store.DB, Options{Driver: DriverSQLite}).Reviews
+
+	// Step 1: Insert a book and give it two reviews
+	book, err := store.Insert(t.Context(), &Book{Title: "Ratings", Author: "Gary Clarke", Year: intPtr(2024)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rating := range []int{4, 5} {
+		if _, err := reviews.Insert(t.Context(), &Review{BookID: book.ID, Rating: rating, Body: "Good read"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Step 2: Get should report the average and the count
+	got, err := store.Get(t.Context(), book.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.AverageRating != 4.5 || got.ReviewCount != 2 {
+		t.Errorf("want average 4.5 from 2 reviews; got %v from %d", got.AverageRating, got.ReviewCount)
+	}
+
+	// Step 3: A book without reviews reports zeros (checked through GetAll)
+	if _, err := store.Insert(t.Context(), &Book{Title: "Unrated", Author: "Gary Clarke", Year: intPtr(2024)}); err != nil {
+		t.Fatal(err)
+	}
+	books, err := store.GetAll(t.Context(), BookFilters{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 2 || books[1].AverageRating != 0 || books[1].ReviewCount != 0 {
+		t.Errorf("want the unrated book to report zeros; got %+v", books)
+	}
+}
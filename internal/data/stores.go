@@ -1,19 +1,92 @@
 // File: internal/data/stores.go
 package data
 
-import "database/sql"
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// Options are the settings shared by all of our stores.
+type Options struct {
+	// Driver is DriverSQLite or DriverPostgres, so each store can speak
+	// the right SQL dialect.
+	Driver string
+
+	// MaxRetries and RetryDelay control how writes are retried when SQLite
+	// reports the database is busy. Zero MaxRetries disables retrying.
+	MaxRetries int
+	RetryDelay time.Duration
+
+	// SQLLogger, when set, logs every statement the book store runs along
+	// with its arguments and duration. Leave it nil in production.
+	SQLLogger *slog.Logger
+
+	// SlowQueryThreshold, when above zero, has the book store warn about
+	// any statement that takes at least this long. The warnings go to Logger.
+	SlowQueryThreshold time.Duration
+	Logger             *slog.Logger
+
+	// CacheTTL, when above zero, caches the book list in memory for this
+	// long (see BookCache). Zero means no caching.
+	CacheTTL time.Duration
+
+	// IdempotencyTTL is how long idempotency keys are remembered. Zero
+	// means DefaultIdempotencyTTL.
+	IdempotencyTTL time.Duration
+}
 
 type Stores struct {
-	Books BookStore
+	Books   BookStore
+	Authors AuthorStore
+	Reviews ReviewStore
+
+	Idempotency IdempotencyStore
 }
 
 // NewStores is a constructor function. It takes a database connection
 // and returns a Stores struct containing all of our application’s
-// data stores (for now, just the BookStore). Using a constructor
-// like this keeps the setup logic in one place and makes it easier
-// to add more stores later.
-func NewStores(db *sql.DB) Stores {
+// data stores (books, authors, reviews and idempotency keys). Using a
+// constructor like this keeps the setup logic in one place and makes it
+// easier to add more stores later.
+func NewStores(db *sql.DB, opts Options) Stores {
+	// The book and review stores share the cache, so a write to either
+	// one clears it.
+	var cache *BookCache
+	if opts.CacheTTL > 0 {
+		cache = NewBookCache(opts.CacheTTL)
+	}
+
 	return Stores{
-		Books: BookStore{DB: db},
+		Books: BookStore{
+			DB:         db,
+			Driver:     opts.Driver,
+			MaxRetries: opts.MaxRetries,
+			RetryDelay: opts.RetryDelay,
+			SQLLogger:  opts.SQLLogger,
+
+			SlowQueryThreshold: opts.SlowQueryThreshold,
+			Logger:             opts.Logger,
+
+			Cache: cache,
+		},
+		Authors: AuthorStore{
+			DB:     db,
+			Driver: opts.Driver,
+		},
+		Reviews: ReviewStore{
+			DB:         db,
+			Driver:     opts.Driver,
+			MaxRetries: opts.MaxRetries,
+			RetryDelay: opts.RetryDelay,
+			Cache:      cache,
+		},
+		Idempotency: IdempotencyStore{
+			DB:         db,
+			Driver:     opts.Driver,
+			MaxRetries: opts.MaxRetries,
+			RetryDelay: opts.RetryDelay,
+			TTL:        opts.IdempotencyTTL,
+		},
 	}
 }
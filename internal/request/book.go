@@ -1,7 +1,38 @@
 package request
 
+import "github.com/garyclarke/first-go-app/internal/data"
+
+// FullBookRequest is the body of a request that sets every field of a book.
+// Year is a pointer because it's optional: leaving it out (or sending null)
+// means the year is unknown. Genre is optional too; empty means unknown.
 type FullBookRequest struct {
 	Title  string `json:"title"`
 	Author string `json:"author"`
-	Year   int    `json:"year"`
+	Year   *int   `json:"year"`
+	Genre  string `json:"genre"`
+}
+
+// PutBookRequest is the body of PUT /books/{id}. It's a FullBookRequest,
+// but clients that send back the whole book they fetched may include its
+// id too. The ID in the URL still decides which book is updated; ID is only
+// here so ValidatePutBookRequest can check the two agree.
+type PutBookRequest struct {
+	ID *int64 `json:"id"`
+	FullBookRequest
+}
+
+// YearRequest is the body of PATCH /books/{id}/year, which corrects just
+// the year. Year is a pointer so leaving it out isn't mistaken for 0.
+type YearRequest struct {
+	Year *int `json:"year"`
+}
+
+// Book maps the request onto a data.Book, ready to be validated and saved.
+func (br *FullBookRequest) Book() data.Book {
+	return data.Book{
+		Title:  br.Title,
+		Author: br.Author,
+		Year:   br.Year,
+		Genre:  br.Genre,
+	}
 }
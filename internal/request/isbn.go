@@ -0,0 +1,56 @@
+// File: internal/request/isbn.go
+package request
+
+import "strings"
+
+// NormalizeISBN checks raw is a valid ISBN-10 or ISBN-13 and returns it
+// without any hyphens or spaces, e.g. "978-0-13-419044-0" becomes
+// "9780134190440". ok is false if raw isn't a valid ISBN.
+//
+// Both formats end in a check digit, calculated from the other digits, so
+// most typos (a wrong or swapped digit) are caught.
+func NormalizeISBN(raw string) (isbn string, ok bool) {
+	isbn = strings.NewReplacer("-", "", " ", "").Replace(raw)
+
+	switch len(isbn) {
+	case 10:
+		// ISBN-10: weight the digits 10, 9, ..., 1. The total must divide by 11.
+		// The check digit can be X, meaning 10.
+		sum := 0
+		for i, c := range isbn {
+			var d int
+			switch {
+			case c >= '0' && c <= '9':
+				d = int(c - '0')
+			case (c == 'X' || c == 'x') && i == 9:
+				d = 10
+			default:
+				return "", false
+			}
+			sum += d * (10 - i)
+		}
+		if sum%11 != 0 {
+			return "", false
+		}
+		return strings.ToUpper(isbn), true
+	case 13:
+		// ISBN-13: weight the digits 1, 3, 1, 3, ... The total must divide by 10.
+		sum := 0
+		for i, c := range isbn {
+			if c < '0' || c > '9' {
+				return "", false
+			}
+			d := int(c - '0')
+			if i%2 == 1 {
+				d *= 3
+			}
+			sum += d
+		}
+		if sum%10 != 0 {
+			return "", false
+		}
+		return isbn, true
+	default:
+		return "", false
+	}
+}
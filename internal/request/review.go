@@ -0,0 +1,6 @@
+package request
+
+type ReviewRequest struct {
+	Rating int    `json:"rating"`
+	Body   string `json:"body"`
+}
@@ -1,23 +1,68 @@
 // File: internal/request/validate.go
 package request
 
+import (
+	"strings"
+	"time"
+)
+
+// ValidateFullBookRequest checks a FullBookRequest. The rules themselves
+// belong to the book, so we map the request into a data.Book and let
+// Book.Validate do the work.
+//
+// It tidies the request first, trimming any whitespace from around the title
+// and author in br itself, so the trimmed values are the ones that get saved.
 func ValidateFullBookRequest(br *FullBookRequest) map[string]string {
-	// Make errors map to hold errors
+	br.Title = strings.TrimSpace(br.Title)
+	br.Author = strings.TrimSpace(br.Author)
+
+	return br.Book().Validate()
+}
+
+// ValidatePutBookRequest checks a PutBookRequest for the book with the
+// given ID (from the URL). On top of ValidateFullBookRequest's checks, an id
+// in the body must be that same ID: a different one almost certainly means
+// the client is about to overwrite the wrong book.
+func ValidatePutBookRequest(pr *PutBookRequest, id int64) map[string]string {
+	errors := ValidateFullBookRequest(&pr.FullBookRequest)
+
+	if pr.ID != nil && *pr.ID != id {
+		errors["id"] = "id must match the book ID in the URL"
+	}
+
+	return errors
+}
+
+// ValidateYearRequest checks a YearRequest: the year must be there, be
+// positive, and not be later than this year, since a book can't have been
+// published in the future.
+func ValidateYearRequest(yr *YearRequest) map[string]string {
 	errors := make(map[string]string)
 
-	// Validate title != ""
-	if br.Title == "" {
-		errors["title"] = "title is required"
+	switch {
+	case yr.Year == nil:
+		errors["year"] = "year is required"
+	case *yr.Year < 1:
+		errors["year"] = "year must be a positive integer"
+	case *yr.Year > time.Now().Year():
+		errors["year"] = "year must not be in the future"
 	}
 
-	// Validate author != ""
-	if br.Author == "" {
-		errors["author"] = "author is required"
+	return errors
+}
+
+func ValidateReviewRequest(rr *ReviewRequest) map[string]string {
+	// Make errors map to hold errors
+	errors := make(map[string]string)
+
+	// Validate 1 <= rating <= 5
+	if rr.Rating < 1 || rr.Rating > 5 {
+		errors["rating"] = "rating must be between 1 and 5"
 	}
 
-	// Validate year > 0
-	if br.Year < 1 {
-		errors["year"] = "year must be a positive integer"
+	// Validate body != "" (whitespace alone doesn't count)
+	if strings.TrimSpace(rr.Body) == "" {
+		errors["body"] = "body is required"
 	}
 
 	// return errors map
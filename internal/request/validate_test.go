@@ -1,14 +1,23 @@
 // File: internal/request/validate_test.go
 package request
 
-import "testing"
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// intPtr returns a pointer to n, for filling in FullBookRequest.Year.
+func intPtr(n int) *int {
+	return &n
+}
 
 func TestValidateFullBookRequest_ValidInput(t *testing.T) {
 	// Create FullBookRequest br
 	br := FullBookRequest{
 		Title:  "Valid Book",
 		Author: "Valid Author",
-		Year:   1999,
+		Year:   intPtr(1999),
 	}
 
 	// errors := ValidateFullBookRequest(br)
@@ -30,13 +39,31 @@ func TestValidateFullBookRequest_InvalidInput(t *testing.T) {
 		{
 			name:     "missing all fields",
 			br:       FullBookRequest{},
-			wantKeys: []string{"title", "author", "year"},
+			wantKeys: []string{"title", "author"}, // The year is optional
+		},
+		{
+			name: "zero year",
+			br: FullBookRequest{
+				Title:  "Test Title",   // Valid title
+				Author: "Valid Author", // Valid author
+				Year:   intPtr(0),      // A year we know must be positive
+			},
+			wantKeys: []string{"year"},
+		},
+		{
+			name: "negative year",
+			br: FullBookRequest{
+				Title:  "Test Title",   // Valid title
+				Author: "Valid Author", // Valid author
+				Year:   intPtr(-1999),  // BC dates aren't supported
+			},
+			wantKeys: []string{"year"},
 		},
 		{
 			name: "missing title",
 			br: FullBookRequest{
 				Author: "Valid Author", // Valid author
-				Year:   1999,           // Valid year
+				Year:   intPtr(1999),   // Valid year
 			},
 			wantKeys: []string{"title"}, // Only title should fail validation
 		},
@@ -44,10 +71,43 @@ func TestValidateFullBookRequest_InvalidInput(t *testing.T) {
 			name: "missing author",
 			br: FullBookRequest{
 				Title: "Test Title", // Valid title
-				Year:  1999,         // Valid year
+				Year:  intPtr(1999), // Valid year
 			},
 			wantKeys: []string{"author"}, // Only author should fail validation
 		},
+		{
+			name: "whitespace-only title",
+			br: FullBookRequest{
+				Title:  "   ",          // Spaces don't count as a title
+				Author: "Valid Author", // Valid author
+				Year:   intPtr(1999),   // Valid year
+			},
+			wantKeys: []string{"title"},
+		},
+		{
+			name: "tab-only title",
+			br: FullBookRequest{
+				Title:  "\t",           // Blank once trimmed
+				Author: "Valid Author", // Valid author
+			},
+			wantKeys: []string{"title"},
+		},
+		{
+			name: "title with a null byte",
+			br: FullBookRequest{
+				Title:  "Test\x00Title", // A control character in the middle
+				Author: "Valid Author",  // Valid author
+			},
+			wantKeys: []string{"title"},
+		},
+		{
+			name: "author with a newline",
+			br: FullBookRequest{
+				Title:  "Test Title",
+				Author: "Valid\nAuthor",
+			},
+			wantKeys: []string{"author"},
+		},
 	}
 
 	// loop over the test cases, tc is the current test case
@@ -68,6 +128,134 @@ func TestValidateFullBookRequest_InvalidInput(t *testing.T) {
 					t.Errorf("%s: expected error for %s but is missing", tc.name, key)
 				}
 			}
+
+			// ...and that there are no others (a count alone could hide a swap)
+			for key, message := range errors {
+				if !slices.Contains(tc.wantKeys, key) {
+					t.Errorf("%s: unexpected error for %s: %q", tc.name, key, message)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateFullBookRequest_TrimsWhitespace(t *testing.T) {
+	br := FullBookRequest{
+		Title:  "  Valid Book\t",
+		Author: "\nValid Author ",
+	}
+
+	if errors := ValidateFullBookRequest(&br); len(errors) > 0 {
+		t.Fatalf("expected no validation errors, got %v", errors)
+	}
+
+	// The request itself is trimmed, so the tidy values are what get saved
+	if br.Title != "Valid Book" || br.Author != "Valid Author" {
+		t.Errorf("want trimmed title and author; got %q and %q", br.Title, br.Author)
+	}
+}
+
+func TestValidatePutBookRequest(t *testing.T) {
+	id := func(n int64) *int64 { return &n }
+
+	tests := []struct {
+		name     string
+		pr       PutBookRequest
+		wantKeys []string
+	}{
+		{name: "no id in the body", pr: PutBookRequest{FullBookRequest: FullBookRequest{Title: "Go", Author: "Alan Donovan"}}},
+		{name: "matching id", pr: PutBookRequest{ID: id(1), FullBookRequest: FullBookRequest{Title: "Go", Author: "Alan Donovan"}}},
+		{name: "mismatched id", pr: PutBookRequest{ID: id(2), FullBookRequest: FullBookRequest{Title: "Go", Author: "Alan Donovan"}}, wantKeys: []string{"id"}},
+		{name: "mismatched id and no title or author", pr: PutBookRequest{ID: id(2)}, wantKeys: []string{"id", "title", "author"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errors := ValidatePutBookRequest(&tt.pr, 1)
+			if len(errors) != len(tt.wantKeys) {
+				t.Errorf("want errors for %v; got %v", tt.wantKeys, errors)
+			}
+			for _, key := range tt.wantKeys {
+				if _, ok := errors[key]; !ok {
+					t.Errorf("want an error for %q; got %v", key, errors)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateReviewRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		rr       ReviewRequest
+		wantKeys []string
+	}{
+		{name: "valid", rr: ReviewRequest{Rating: 5, Body: "Loved it"}, wantKeys: nil},
+		{name: "missing all fields", rr: ReviewRequest{}, wantKeys: []string{"rating", "body"}},
+		{name: "rating too high", rr: ReviewRequest{Rating: 6, Body: "Off the scale"}, wantKeys: []string{"rating"}},
+		{name: "blank body", rr: ReviewRequest{Rating: 3, Body: "   "}, wantKeys: []string{"body"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			errors := ValidateReviewRequest(&tc.rr)
+
+			if len(errors) != len(tc.wantKeys) {
+				t.Errorf("expected %d validation errors; got %d: %v", len(tc.wantKeys), len(errors), errors)
+			}
+			for _, key := range tc.wantKeys {
+				if _, ok := errors[key]; !ok {
+					t.Errorf("expected error for %s but is missing", key)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateYearRequest(t *testing.T) {
+	thisYear := time.Now().Year()
+	tests := []struct {
+		name      string
+		yr        YearRequest
+		wantError bool
+	}{
+		{name: "valid", yr: YearRequest{Year: intPtr(2020)}},
+		{name: "this year", yr: YearRequest{Year: intPtr(thisYear)}},
+		{name: "missing", yr: YearRequest{}, wantError: true},
+		{name: "zero", yr: YearRequest{Year: intPtr(0)}, wantError: true},
+		{name: "next year", yr: YearRequest{Year: intPtr(thisYear + 1)}, wantError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			errors := ValidateYearRequest(&tc.yr)
+			if _, got := errors["year"]; got != tc.wantError {
+				t.Errorf("want a year error: %v; got %v", tc.wantError, errors)
+			}
+		})
+	}
+}
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		raw    string
+		want   string
+		wantOK bool
+	}{
+		{"978-0-13-419044-0", "9780134190440", true},
+		{"0-306-40615-2", "0306406152", true},
+		{"080442957x", "080442957X", true},
+		{"978-0-13-419044-1", "", false}, // wrong check digit
+		{"12345", "", false},             // wrong length
+		{"97801341904AB", "", false},     // not digits
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.raw, func(t *testing.T) {
+			got, ok := NormalizeISBN(tc.raw)
+			if ok != tc.wantOK || (ok && got != tc.want) {
+				t.Errorf("NormalizeISBN(%q) = %q, %v; want %q, %v", tc.raw, got, ok, tc.want, tc.wantOK)
+			}
 		})
 	}
 }